@@ -30,6 +30,28 @@ type appConfigOptions struct {
 	PollInterval string
 	OneOff       bool
 	TestMode     bool
+	Preview      bool
+	ReadOnly     bool
+	OutputFormat string
+	PageCacheDir string
+	Replay       bool
+	ReportPath   string
+	// Send the email even if the storage directory can't be opened
+	DegradeOnStorageError bool
+	// Retry failed scrape cycles this many times, waiting RunRetryBackoff
+	// before the first retry
+	RunRetries      uint
+	RunRetryBackoff time.Duration
+	// Paths to the subject and intro templates
+	SubjectTemplateFile string
+	IntroTemplateFile   string
+	// Path to PEM-encoded certificates to trust when scraping over HTTPS
+	CACertFile string
+	// Split emails larger than MaxEmailBytes into several emails
+	MaxEmailBytes    uint
+	SplitLargeEmails bool
+	// Maximum number of link items in each email
+	MaxTotalItems uint
 }
 
 // mockLinksrcInfo contains metadata about test HTTP servers so we can use it
@@ -47,6 +69,14 @@ type mockLinksrcInfo struct {
 	CaptionSelector string
 	// Not required
 	ItemSelector string
+	// Not required
+	Cookie string
+	// Not required
+	Method string
+	// Not required
+	Body string
+	// Not required
+	InsecureSkipVerify bool
 	// The linkSelector, captionSelector, and itemSelector in a link source
 	// config. Leave blank if you would like to use valid defaults.
 	SelectorsOverride string
@@ -81,14 +111,36 @@ func createUserConfig(opts appConfigOptions) (userconfig.Meta, error) {
 			SkipCertVerification: true,
 		},
 		Scraping: userconfig.Scraping{
-			Interval:       v,
-			StorageDirPath: opts.StorageDir,
-			OneOff:         opts.OneOff,
-			TestMode:       opts.TestMode,
-			LinkExpiryDays: 180,
+			Interval:              v,
+			StorageDirPath:        opts.StorageDir,
+			OneOff:                opts.OneOff,
+			TestMode:              opts.TestMode,
+			Preview:               opts.Preview,
+			ReadOnly:              opts.ReadOnly,
+			OutputFormat:          opts.OutputFormat,
+			PageCacheDir:          opts.PageCacheDir,
+			Replay:                opts.Replay,
+			ReportPath:            opts.ReportPath,
+			DegradeOnStorageError: opts.DegradeOnStorageError,
+			RunRetries:            opts.RunRetries,
+			RunRetryBackoff:       opts.RunRetryBackoff,
+			SubjectTemplateFile:   opts.SubjectTemplateFile,
+			IntroTemplateFile:     opts.IntroTemplateFile,
+			CACertFile:            opts.CACertFile,
+			MaxEmailBytes:         opts.MaxEmailBytes,
+			SplitLargeEmails:      opts.SplitLargeEmails,
+			MaxTotalItems:         opts.MaxTotalItems,
+			LinkExpiryDays:        180,
 		},
 	}
 
+	// Parse any templates the same way we would for a config file
+	s, err := config.Scraping.CheckAndSetDefaults()
+	if err != nil {
+		return userconfig.Meta{}, err
+	}
+	config.Scraping = s
+
 	config.LinkSources = make([]linksrc.Config, len(opts.LinkSources))
 	for i, ls := range opts.LinkSources {
 		if ls.URL == "" || ls.Name == "" {
@@ -99,12 +151,16 @@ func createUserConfig(opts appConfigOptions) (userconfig.Meta, error) {
 			return userconfig.Meta{}, err
 		}
 		config.LinkSources[i] = linksrc.Config{
-			Name:            ls.Name,
-			URL:             *u,
-			MaxItems:        uint(ls.MaxItems),
-			ItemSelector:    cascadia.MustCompile("ul li"),
-			CaptionSelector: cascadia.MustCompile("p"),
-			LinkSelector:    cascadia.MustCompile("a"),
+			Name:               ls.Name,
+			URL:                *u,
+			MaxItems:           uint(ls.MaxItems),
+			Cookie:             ls.Cookie,
+			Method:             ls.Method,
+			Body:               ls.Body,
+			InsecureSkipVerify: ls.InsecureSkipVerify,
+			ItemSelector:       cascadia.MustCompile("ul li"),
+			CaptionSelector:    cascadia.MustCompile("p"),
+			LinkSelector:       cascadia.MustCompile("a"),
 		}
 		switch {
 		case ls.CaptionSelector != "":
@@ -2,17 +2,29 @@ package e2e
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"html/template"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ptgott/one-newsletter/scrape"
 	"github.com/ptgott/one-newsletter/smtptest"
+	"github.com/ptgott/one-newsletter/userconfig"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -72,7 +84,7 @@ func TestNewsletterEmailSending(t *testing.T) {
 		IterationLimit: uint(expectedEmails - 1),
 	}
 
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 	ems, err := testenv.SMTPServer.RetrieveEmails(0)
 
 	if err != nil {
@@ -144,7 +156,7 @@ func TestNewsletterEmailUpdates(t *testing.T) {
 		IterationLimit: 1,
 	}
 
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 
 	// Run the application from the entrypoint with our new config
 
@@ -163,7 +175,7 @@ func TestNewsletterEmailUpdates(t *testing.T) {
 	testenv.update(linksToUpdate)
 	ut := time.Now().UnixNano()
 	log.Info().Msg("finished updating the mock link sites")
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 	em2, err := testenv.SMTPServer.RetrieveEmails(ut)
 	if err != nil {
 		t.Errorf("can't retrieve emails after the update: %v", err)
@@ -251,7 +263,7 @@ func TestMaxLinkLimits(t *testing.T) {
 		IterationLimit: 1,
 	}
 
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 	em, err := testenv.SMTPServer.RetrieveEmails(0)
 	if err != nil {
 		t.Errorf("could not retrieve emails: %v", err)
@@ -352,7 +364,7 @@ func TestEmailSendingWithBadScrapeConfig(t *testing.T) {
 		IterationLimit: 1,
 	}
 
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 
 	em, err := testenv.SMTPServer.RetrieveEmails(0)
 	if err != nil {
@@ -419,7 +431,7 @@ func TestTestModeFlag(t *testing.T) {
 		OutputWr:       &msg,
 	}
 
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 
 	em1, err := testenv.SMTPServer.RetrieveEmails(0)
 	if err != nil {
@@ -444,6 +456,279 @@ func TestTestModeFlag(t *testing.T) {
 	}
 }
 
+// Make sure that check results for the -output json flag have the shape that
+// CI pipelines expect, with and without errors.
+func TestCheckResultJSON(t *testing.T) {
+	testCases := []struct {
+		description string
+		errs        []error
+		expected    string
+	}{
+		{
+			description: "success",
+			errs:        nil,
+			expected:    `{"ok":true,"errors":[]}` + "\n",
+		},
+		{
+			description: "success with nil errors",
+			errs:        []error{nil},
+			expected:    `{"ok":true,"errors":[]}` + "\n",
+		},
+		{
+			description: "failure",
+			errs: []error{
+				errors.New("Problem validating your config: polling interval must be at least 5 seconds"),
+				nil,
+				errors.New("the link source must include a URL"),
+			},
+			expected: `{"ok":false,"errors":["Problem validating your config: polling interval must be at least 5 seconds","the link source must include a URL"]}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := scrape.NewCheckResult(tc.errs...).WriteJSON(&b); err != nil {
+				t.Fatalf("unexpected error writing the check result: %v", err)
+			}
+			if b.String() != tc.expected {
+				t.Errorf("expected %q but got %q", tc.expected, b.String())
+			}
+		})
+	}
+}
+
+// Make sure that checking a config in test mode fails if every link source
+// fails, but not if only some of them do.
+func TestRunCheckResult(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		description string
+		sources     []mockLinksrcInfo
+		expectOK    bool
+	}{
+		{
+			description: "only failing link sources",
+			sources: []mockLinksrcInfo{
+				{URL: srv.URL + "/first", Name: "first-missing-site"},
+				{URL: srv.URL + "/second", Name: "second-missing-site"},
+			},
+			expectOK: false,
+		},
+		{
+			description: "some failing link sources",
+			sources: []mockLinksrcInfo{
+				{URL: srv.URL + "/first", Name: "missing-site"},
+				{URL: testenv.urls()[0], Name: "working-site"},
+			},
+			expectOK: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			config, err := createUserConfig(appConfigOptions{
+				SMTPServerAddress: testenv.SMTPServer.Address(),
+				LinkSources:       tc.sources,
+				StorageDir:        testenv.tempDirPath,
+				PollInterval:      "5s", // Ignored here
+				TestMode:          true,
+			})
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+
+			sc := scrape.Config{OutputWr: io.Discard}
+			if err := scrape.StartLoop(context.Background(), &sc, &config); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+			st, ok := sc.Status()
+			if !ok {
+				t.Fatal("expected a status after the run")
+			}
+
+			r := scrape.NewRunCheckResult(st)
+			if r.OK != tc.expectOK {
+				t.Errorf("expected ok to be %v but got %+v", tc.expectOK, r)
+			}
+			if !tc.expectOK && len(r.Errors) != len(tc.sources) {
+				t.Errorf("expected an error for each link source but got %v", r.Errors)
+			}
+		})
+	}
+}
+
+// Test that the -preview flag causes the email body to be written to a file
+// whose path is printed to stdout.
+func TestPreviewFlag(t *testing.T) {
+	epubs := 1
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	// Keep the preview file within the test's own directory
+	t.Setenv("TMPDIR", t.TempDir())
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+			TestMode:          true,
+			Preview:           true,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var msg bytes.Buffer
+
+	scrapeConfig := scrape.Config{
+		TickCh:         nil,
+		IterationLimit: 1,
+		OutputWr:       &msg,
+	}
+
+	if err := scrape.StartLoop(context.Background(), &scrapeConfig, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	o := strings.TrimSpace(msg.String())
+	if !strings.HasPrefix(o, "file://") {
+		t.Fatalf("expected the output to be a file URL but got %q", o)
+	}
+
+	b, err := os.ReadFile(strings.TrimPrefix(o, "file://"))
+	if err != nil {
+		t.Fatalf("could not read the preview file: %v", err)
+	}
+
+	links := smtptest.ExtractItems(string(b))
+	if len(links) != epubs*linksPerPub {
+		t.Errorf(
+			"expecting %v links in the preview file, but got %v",
+			epubs*linksPerPub,
+			len(links),
+		)
+	}
+}
+
+// Test that test mode can print link items as JSON Lines
+func TestJSONLinesOutput(t *testing.T) {
+	epubs := 2
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+			TestMode:          true,
+			OutputFormat:      userconfig.OutputFormatJSONLines,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var msg bytes.Buffer
+
+	scrapeConfig := scrape.Config{
+		TickCh:         nil,
+		IterationLimit: 1,
+		OutputWr:       &msg,
+	}
+
+	if err := scrape.StartLoop(context.Background(), &scrapeConfig, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(msg.String()), "\n")
+	if len(lines) != epubs*linksPerPub {
+		t.Fatalf(
+			"expecting %v lines of output, but got %v",
+			epubs*linksPerPub,
+			len(lines),
+		)
+	}
+
+	pubs := make(map[string]struct{})
+	for _, l := range lines {
+		var item map[string]string
+		if err := json.Unmarshal([]byte(l), &item); err != nil {
+			t.Fatalf("could not parse the line %q as JSON: %v", l, err)
+		}
+		for _, k := range []string{"publication", "caption", "url"} {
+			if item[k] == "" {
+				t.Errorf("expected the line %q to include the %q field", l, k)
+			}
+		}
+		pubs[item["publication"]] = struct{}{}
+	}
+
+	if len(pubs) != epubs {
+		t.Errorf("expected link items from %v publications but got %v", epubs, len(pubs))
+	}
+}
+
 func TestOneOffFlag(t *testing.T) {
 	epubs := 3
 	linksPerPub := 5
@@ -493,7 +778,7 @@ func TestOneOffFlag(t *testing.T) {
 
 	// The -oneoff flag should cause the scraper loop to run as a one-off
 	// job
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 
 	dbAfter := totalBadgerDataFileSize(testenv.tempDirPath)
 
@@ -572,7 +857,7 @@ func TestOneOffFlagWithNoEmailFlag(t *testing.T) {
 
 	// The -oneoff flag should cause the scraper loop to run as a one-off
 	// job
-	scrape.StartLoop(&scrapeConfig, &config)
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
 
 	ems, err := testenv.SMTPServer.RetrieveEmails(0)
 	if err != nil {
@@ -598,3 +883,2242 @@ func TestOneOffFlagWithNoEmailFlag(t *testing.T) {
 	}
 
 }
+
+// Make sure that the -readonly flag checks link items against an existing
+// database without writing new ones to it.
+func TestReadOnlyFlag(t *testing.T) {
+	linksToUpdate := 2
+	epubs := 1
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	// Configure link site checks for each fake e-publicaiton we've spun up.
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		// not expecting errors since these URLs are guaranteed to be
+		// for running servers, and don't come from user input
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	opts := appConfigOptions{
+		SMTPServerAddress: testenv.SMTPServer.Address(),
+		LinkSources:       u,
+		StorageDir:        testenv.tempDirPath,
+		PollInterval:      "5s", // Ignored here
+	}
+
+	config, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	// Populate the database with the initial link items
+	if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+		t.Fatalf("could not run the initial scrape: %v", err)
+	}
+
+	testenv.update(linksToUpdate)
+
+	opts.ReadOnly = true
+	roConfig, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	dbBefore := totalBadgerDataFileSize(testenv.tempDirPath)
+	ut := time.Now().UnixNano()
+
+	// Since the read-only runs don't record the updated link items, each
+	// one should find the same new items.
+	for i := 0; i < 2; i++ {
+		if err := scrape.Run(&scrape.Config{}, &roConfig); err != nil {
+			t.Fatalf("could not run the read-only scrape: %v", err)
+		}
+	}
+
+	dbAfter := totalBadgerDataFileSize(testenv.tempDirPath)
+
+	if dbAfter != dbBefore {
+		t.Errorf(
+			"the -readonly flag must not change the database: expecting data directory size to be %v but got %v",
+			dbBefore,
+			dbAfter,
+		)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(ut)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+
+	if len(ems) != 2 {
+		t.Fatalf("expecting 2 emails but got %v", len(ems))
+	}
+
+	for _, em := range ems {
+		links := smtptest.ExtractItems(em)
+		if len(links) != linksToUpdate {
+			t.Errorf(
+				"expecting %v links in each read-only email, but got %v",
+				linksToUpdate,
+				len(links),
+			)
+		}
+	}
+}
+
+// Make sure that no emails are sent while scraping is paused, and that they
+// resume once the pause is over.
+func TestPauseUntil(t *testing.T) {
+	epubs := 1
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	// Configure link site checks for each fake e-publicaiton we've spun up.
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		// not expecting errors since these URLs are guaranteed to be
+		// for running servers, and don't come from user input
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+	config.Scraping.PauseUntil = time.Now().Add(time.Duration(1) * time.Hour)
+
+	scrape.StartLoop(context.Background(), &scrape.Config{
+		TickCh:         nil,
+		IterationLimit: 2,
+	}, &config)
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+	if len(ems) != 0 {
+		t.Fatalf("expected no emails while paused but got %v", len(ems))
+	}
+
+	config.Scraping.PauseUntil = time.Now().Add(-time.Duration(1) * time.Hour)
+
+	scrape.StartLoop(context.Background(), &scrape.Config{
+		TickCh:         nil,
+		IterationLimit: 1,
+	}, &config)
+
+	ems, err = testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+	if len(ems) != 2 {
+		t.Fatalf("expected 2 emails after the pause but got %v", len(ems))
+	}
+}
+
+// Make sure that we can extract link items from a saved HTML file without a
+// configuration file or network access.
+func TestExtractFile(t *testing.T) {
+	c, err := scrape.NewExtractConfig("http://www.example.com", "div a.itemName")
+	if err != nil {
+		t.Fatalf("could not create the extraction config: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := scrape.ExtractFile(
+		&out,
+		filepath.Join("..", "linksrc", "testdata", "straightforward.html"),
+		c,
+	); err != nil {
+		t.Fatalf("could not extract link items: %v", err)
+	}
+
+	o := out.String()
+	for _, u := range []string{
+		"http://www.example.com/stories/hot-take",
+		"http://www.example.com/stories/stuff-happened",
+		"http://www.example.com/storiesreally-true",
+	} {
+		if !strings.Contains(o, u) {
+			t.Errorf("expected the output to include %v but got %v", u, o)
+		}
+	}
+	if !strings.Contains(o, "Link items: 3") {
+		t.Errorf("expected the output to include three link items but got %v", o)
+	}
+}
+
+// recordingTransport is an http.RoundTripper that records the URL of each
+// request before sending it with http.DefaultTransport.
+type recordingTransport struct {
+	mu         sync.Mutex
+	urls       []string
+	userAgents []string
+}
+
+// RoundTrip implements http.RoundTripper
+func (rt *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.urls = append(rt.urls, r.URL.String())
+	rt.userAgents = append(rt.userAgents, r.Header.Get("User-Agent"))
+	rt.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// Make sure that the scraper sends requests with the HTTP client we provide,
+// and reuses it for each scrape cycle.
+func TestInjectedHTTPClient(t *testing.T) {
+	epubs := 2
+	linksPerPub := 5
+	iterations := 2
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	rt := &recordingTransport{}
+	scrapeConfig := scrape.Config{
+		TickCh: nil,
+		// Since we scrape right away, before using the iteration limit.
+		IterationLimit: uint(iterations - 1),
+		HTTPClient: &http.Client{
+			Transport: rt,
+		},
+	}
+
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
+
+	if len(rt.urls) != epubs*iterations {
+		t.Fatalf(
+			"expected %v requests via the injected client but got %v",
+			epubs*iterations,
+			len(rt.urls),
+		)
+	}
+
+	for _, eu := range urls {
+		var n int
+		for _, ru := range rt.urls {
+			if ru == eu {
+				n++
+			}
+		}
+		if n != iterations {
+			t.Errorf("expected %v requests to %v but got %v", iterations, eu, n)
+		}
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	def := http.DefaultTransport.(*http.Transport)
+	testCases := []struct {
+		description             string
+		scraping                userconfig.Scraping
+		expectedIdleConnsByHost int
+		expectedIdleConns       int
+		expectedIdleTimeout     time.Duration
+	}{
+		{
+			description:             "defaults",
+			scraping:                userconfig.Scraping{},
+			expectedIdleConnsByHost: def.MaxIdleConnsPerHost,
+			expectedIdleConns:       def.MaxIdleConns,
+			expectedIdleTimeout:     def.IdleConnTimeout,
+		},
+		{
+			description: "connection settings",
+			scraping: userconfig.Scraping{
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     time.Duration(2) * time.Hour,
+			},
+			expectedIdleConnsByHost: 10,
+			expectedIdleConns:       def.MaxIdleConns,
+			expectedIdleTimeout:     time.Duration(2) * time.Hour,
+		},
+		{
+			description: "more idle connections per host than in total",
+			scraping: userconfig.Scraping{
+				MaxIdleConnsPerHost: 500,
+			},
+			expectedIdleConnsByHost: 500,
+			expectedIdleConns:       500,
+			expectedIdleTimeout:     def.IdleConnTimeout,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			c := scrape.NewHTTPClient(tc.scraping)
+			tr, ok := c.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("expected an *http.Transport but got %T", c.Transport)
+			}
+			if tr == def {
+				t.Fatal("expected a copy of the default transport, not the default transport itself")
+			}
+			if tr.MaxIdleConnsPerHost != tc.expectedIdleConnsByHost {
+				t.Errorf("expected %v idle connections per host but got %v", tc.expectedIdleConnsByHost, tr.MaxIdleConnsPerHost)
+			}
+			if tr.MaxIdleConns != tc.expectedIdleConns {
+				t.Errorf("expected %v idle connections but got %v", tc.expectedIdleConns, tr.MaxIdleConns)
+			}
+			if tr.IdleConnTimeout != tc.expectedIdleTimeout {
+				t.Errorf("expected an idle timeout of %v but got %v", tc.expectedIdleTimeout, tr.IdleConnTimeout)
+			}
+		})
+	}
+}
+
+// Make sure that we can scrape an HTTPS link source signed by a private CA,
+// either by trusting the CA or by skipping verification for the link source.
+func TestPrivateCA(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+	listings := []mockArticleListing{
+		{Caption: "An article from the internal dashboard", URL: "https://www.example.com/articles/1"},
+	}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := tmpl.Execute(rw, listings); err != nil {
+			panic(fmt.Sprintf("error executing the link site template: %v", err))
+		}
+	}))
+	defer srv.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(ca, b, 0600); err != nil {
+		t.Fatalf("can't write the CA certificate file: %v", err)
+	}
+
+	testCases := []struct {
+		description        string
+		caCertFile         string
+		insecureSkipVerify bool
+	}{
+		{
+			description: "trusting the CA",
+			caCertFile:  ca,
+		},
+		{
+			description:        "skipping verification",
+			insecureSkipVerify: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress: testenv.SMTPServer.Address(),
+					LinkSources: []mockLinksrcInfo{
+						{
+							URL:                srv.URL,
+							Name:               "internal-site",
+							InsecureSkipVerify: tc.insecureSkipVerify,
+						},
+					},
+					StorageDir:   testenv.tempDirPath,
+					PollInterval: "5s", // Ignored here
+					TestMode:     true,
+					OutputFormat: userconfig.OutputFormatJSONLines,
+					CACertFile:   tc.caCertFile,
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+
+			var msg bytes.Buffer
+			if err := scrape.Run(&scrape.Config{OutputWr: &msg}, &config); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+
+			if o := msg.String(); !strings.Contains(o, listings[0].Caption) {
+				t.Errorf("expected the output to include %q but got %v", listings[0].Caption, o)
+			}
+		})
+	}
+}
+
+// Make sure that requests rotate through the configured User-Agents, picking
+// up where the last scrape cycle left off.
+func TestUserAgentRotation(t *testing.T) {
+	epubs := 2
+	iterations := 3
+	userAgents := []string{
+		"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	}
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       5,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+	config.Scraping.UserAgents = userAgents
+
+	rt := &recordingTransport{}
+	scrapeConfig := scrape.Config{
+		TickCh: nil,
+		// Since we scrape right away, before using the iteration limit.
+		IterationLimit: uint(iterations - 1),
+		HTTPClient: &http.Client{
+			Transport: rt,
+		},
+	}
+
+	scrape.StartLoop(context.Background(), &scrapeConfig, &config)
+
+	// Link sources within a scrape cycle send their requests in no
+	// particular order, so only compare the number of times we used each
+	// User-Agent.
+	counts := make(map[string]int)
+	for _, ua := range rt.userAgents {
+		counts[ua]++
+	}
+	n := epubs * iterations / len(userAgents)
+	for _, ua := range userAgents {
+		if counts[ua] != n {
+			t.Errorf("expected %v requests with the User-Agent %q but got %v", n, ua, counts[ua])
+		}
+	}
+	if len(rt.userAgents) != epubs*iterations {
+		t.Errorf("expected %v requests but got %v", epubs*iterations, len(rt.userAgents))
+	}
+}
+
+// Make sure that replaying cached pages extracts the same link items as the
+// scrape that cached them, without sending any requests.
+func TestReplayPageCache(t *testing.T) {
+	epubs := 2
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	opts := appConfigOptions{
+		SMTPServerAddress: testenv.SMTPServer.Address(),
+		LinkSources:       u,
+		StorageDir:        testenv.tempDirPath,
+		PollInterval:      "5s", // Ignored here
+		TestMode:          true,
+		OutputFormat:      userconfig.OutputFormatJSONLines,
+		PageCacheDir:      t.TempDir(),
+	}
+
+	config, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var live bytes.Buffer
+	if err := scrape.Run(&scrape.Config{OutputWr: &live}, &config); err != nil {
+		t.Fatalf("could not run the scrape that caches pages: %v", err)
+	}
+
+	// Change the pages so we can tell if the replay uses the network
+	testenv.update(2)
+
+	opts.Replay = true
+	replayConfig, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var replayed bytes.Buffer
+	rt := &recordingTransport{}
+	if err := scrape.Run(&scrape.Config{
+		OutputWr: &replayed,
+		HTTPClient: &http.Client{
+			Transport: rt,
+		},
+	}, &replayConfig); err != nil {
+		t.Fatalf("could not run the replay scrape: %v", err)
+	}
+
+	if len(rt.urls) != 0 {
+		t.Errorf("expected no requests while replaying but got %v", rt.urls)
+	}
+
+	ll := strings.Split(strings.TrimSpace(live.String()), "\n")
+	rl := strings.Split(strings.TrimSpace(replayed.String()), "\n")
+	if len(ll) != epubs*linksPerPub {
+		t.Fatalf(
+			"expecting %v lines of output, but got %v",
+			epubs*linksPerPub,
+			len(ll),
+		)
+	}
+	// Link items within a set aren't in a fixed order
+	sort.Strings(ll)
+	sort.Strings(rl)
+	if strings.Join(ll, "\n") != strings.Join(rl, "\n") {
+		t.Errorf(
+			"expected the replay to produce the output\n%v\nbut got\n%v",
+			strings.Join(ll, "\n"),
+			strings.Join(rl, "\n"),
+		)
+	}
+}
+
+// Make sure that replaying cached pages keeps the status code of the response
+// we cached and tells apart POST requests to the same URL with different
+// bodies.
+func TestReplayPageCacheResponseDetails(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+	listings := map[string][]mockArticleListing{
+		"first": {
+			{Caption: "An article for the first query", URL: "https://www.example.com/articles/1"},
+		},
+		"second": {
+			{Caption: "An article for the second query", URL: "https://www.example.com/articles/2"},
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		l, ok := listings[string(b)]
+		if !ok {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := tmpl.Execute(rw, l); err != nil {
+			panic(fmt.Sprintf("error executing the link site template: %v", err))
+		}
+	}))
+	defer srv.Close()
+
+	opts := appConfigOptions{
+		SMTPServerAddress: testenv.SMTPServer.Address(),
+		LinkSources: []mockLinksrcInfo{
+			{
+				URL:    srv.URL,
+				Name:   "first-query",
+				Method: http.MethodPost,
+				Body:   "first",
+			},
+			{
+				URL:    srv.URL,
+				Name:   "second-query",
+				Method: http.MethodPost,
+				Body:   "second",
+			},
+			{
+				URL:  srv.URL + "/missing",
+				Name: "missing-page",
+			},
+		},
+		StorageDir:   testenv.tempDirPath,
+		PollInterval: "5s", // Ignored here
+		TestMode:     true,
+		PageCacheDir: t.TempDir(),
+	}
+
+	config, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	if err := scrape.Run(&scrape.Config{OutputWr: io.Discard}, &config); err != nil {
+		t.Fatalf("could not run the scrape that caches pages: %v", err)
+	}
+
+	opts.Replay = true
+	replayConfig, err := createUserConfig(opts)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var replayed bytes.Buffer
+	if err := scrape.Run(&scrape.Config{OutputWr: &replayed}, &replayConfig); err != nil {
+		t.Fatalf("could not run the replay scrape: %v", err)
+	}
+
+	o := replayed.String()
+	for _, l := range listings {
+		if n := strings.Count(o, l[0].Caption); n != 1 {
+			t.Errorf("expected the replay to include %q once but got it %v times: %v", l[0].Caption, n, o)
+		}
+	}
+	if !strings.Contains(o, "find the website at this URL") {
+		t.Errorf("expected the replay to report the cached 404 status but got: %v", o)
+	}
+}
+
+// Make sure that when a link source fails before we can parse its page, e.g.,
+// because the page is missing from the cache, the request is invalid, or the
+// site is down, the scrape cycle reports the problem in the link source's
+// section and still includes the other link sources.
+func TestLinkSourceRequestErrors(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 2,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+
+	// A site that refuses connections
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cases := []struct {
+		description string
+		source      mockLinksrcInfo
+		replay      bool
+		message     string
+	}{
+		{
+			description: "replaying a page that isn't cached",
+			source: mockLinksrcInfo{
+				URL:  urls[0],
+				Name: "uncached-site",
+			},
+			replay:  true,
+			message: "Could not read this site's page from the page cache",
+		},
+		{
+			description: "invalid request method",
+			source: mockLinksrcInfo{
+				URL:    urls[0],
+				Name:   "bad-method-site",
+				Method: "BAD METHOD",
+				Body:   "query",
+			},
+			message: "Could not create the request for this site",
+		},
+		{
+			description: "site that refuses connections",
+			source: mockLinksrcInfo{
+				URL:  down.URL,
+				Name: "down-site",
+			},
+			message: "Could not reach this site",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			config, err := createUserConfig(appConfigOptions{
+				SMTPServerAddress: testenv.SMTPServer.Address(),
+				LinkSources: []mockLinksrcInfo{
+					c.source,
+					{
+						URL:  urls[1],
+						Name: "working-site",
+					},
+				},
+				StorageDir:   testenv.tempDirPath,
+				PollInterval: "5s", // Ignored here
+				TestMode:     true,
+				PageCacheDir: t.TempDir(),
+				Replay:       c.replay,
+			})
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+
+			var out bytes.Buffer
+			ech := make(chan error, 1)
+			go func() {
+				ech <- scrape.Run(&scrape.Config{OutputWr: &out}, &config)
+			}()
+
+			select {
+			case err := <-ech:
+				if err != nil {
+					t.Fatalf("expected no error but got %v", err)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("the scrape cycle did not return")
+			}
+
+			o := strings.ReplaceAll(out.String(), "&#39;", "'")
+			if !strings.Contains(o, c.message) {
+				t.Errorf("expected the email to include %q but got %v", c.message, o)
+			}
+			// The working site isn't in the page cache either
+			if !c.replay && len(smtptest.ExtractItems(o)) != 1 {
+				t.Errorf("expected the email to include the link item from the working site but got %v", o)
+			}
+		})
+	}
+}
+
+// Make sure that a link source that's down doesn't stop the scraper, and that
+// each scrape cycle releases the database for the next one.
+func TestUnreachableLinkSource(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config, err := createUserConfig(appConfigOptions{
+		SMTPServerAddress: testenv.SMTPServer.Address(),
+		LinkSources: []mockLinksrcInfo{
+			{URL: down.URL, Name: "down-site"},
+			{URL: testenv.urls()[0], Name: "working-site"},
+		},
+		StorageDir:   testenv.tempDirPath,
+		PollInterval: "5s", // Ignored here
+	})
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	if err := scrape.StartLoop(context.Background(), &scrape.Config{
+		IterationLimit: 1,
+	}, &config); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+	}
+	// The second scrape cycle has no new link items, but still emails
+	// the message about the link source that's down
+	if len(ems) != 2 {
+		t.Fatalf("expected 2 emails but got %v", len(ems))
+	}
+	if !strings.Contains(ems[0], "Could not reach this site") {
+		t.Errorf("expected the email to report the link source that's down but got %v", ems[0])
+	}
+}
+
+// Make sure that we send the configured cookie with the first request to a
+// link source, and that cookies the link source sets carry over to the
+// requests that follow.
+func TestLinkSourceCookies(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+	listings := []mockArticleListing{
+		{Caption: "Members-only article", URL: "https://www.example.com/articles/1"},
+		{Caption: "Another members-only article", URL: "https://www.example.com/articles/2"},
+	}
+
+	mux := http.NewServeMux()
+	// The first page needs the configured cookie, then starts a session and
+	// redirects to the second page, which needs the session cookie.
+	mux.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		if c, err := req.Cookie("consent"); err != nil || c.Value != "yes" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+		http.Redirect(rw, req, "/page2", http.StatusFound)
+	})
+	mux.HandleFunc("/page2", func(rw http.ResponseWriter, req *http.Request) {
+		if c, err := req.Cookie("session"); err != nil || c.Value != "abc123" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if err := tmpl.Execute(rw, listings); err != nil {
+			panic(fmt.Sprintf("error executing the link site template: %v", err))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources: []mockLinksrcInfo{
+				{
+					URL:    srv.URL + "/",
+					Name:   "paywalled-site",
+					Cookie: "consent=yes",
+				},
+			},
+			StorageDir:   testenv.tempDirPath,
+			PollInterval: "5s", // Ignored here
+			TestMode:     true,
+			OutputFormat: userconfig.OutputFormatJSONLines,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var msg bytes.Buffer
+	if err := scrape.Run(&scrape.Config{OutputWr: &msg}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	o := msg.String()
+	for _, l := range listings {
+		if !strings.Contains(o, l.Caption) {
+			t.Errorf("expected the output to include %q but got %v", l.Caption, o)
+		}
+	}
+}
+
+// Make sure that the scraper can request link sources that only list their
+// links in response to a POST request with a JSON body.
+func TestPostLinkSource(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+	listings := []mockArticleListing{
+		{Caption: "The latest article from the API", URL: "https://www.example.com/articles/1"},
+		{Caption: "Another article from the API", URL: "https://www.example.com/articles/2"},
+	}
+	query := `{"query": "latest"}`
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := io.ReadAll(req.Body)
+		if err != nil || string(b) != query ||
+			req.Header.Get("Content-Type") != "application/json" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := tmpl.Execute(rw, listings); err != nil {
+			panic(fmt.Sprintf("error executing the link site template: %v", err))
+		}
+	}))
+	defer srv.Close()
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources: []mockLinksrcInfo{
+				{
+					URL:    srv.URL,
+					Name:   "api-site",
+					Method: http.MethodPost,
+					Body:   query,
+				},
+			},
+			StorageDir:   testenv.tempDirPath,
+			PollInterval: "5s", // Ignored here
+			TestMode:     true,
+			OutputFormat: userconfig.OutputFormatJSONLines,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var msg bytes.Buffer
+	if err := scrape.Run(&scrape.Config{OutputWr: &msg}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	o := msg.String()
+	for _, l := range listings {
+		if !strings.Contains(o, l.Caption) {
+			t.Errorf("expected the output to include %q but got %v", l.Caption, o)
+		}
+	}
+}
+
+// Make sure that the scraper appends a run report for each scrape cycle.
+func TestRunReport(t *testing.T) {
+	epubs := 2
+	linksPerPub := 5
+	iterations := 2
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	rp := filepath.Join(t.TempDir(), "runs.jsonl")
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+			ReportPath:        rp,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	scrapeConfig := scrape.Config{
+		TickCh: nil,
+		// Since we scrape right away, before using the iteration limit.
+		IterationLimit: uint(iterations - 1),
+	}
+
+	if err := scrape.StartLoop(context.Background(), &scrapeConfig, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	b, err := os.ReadFile(rp)
+	if err != nil {
+		t.Fatalf("could not read the run report: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != iterations {
+		t.Fatalf("expected %v run reports but got %v", iterations, len(lines))
+	}
+
+	for i, l := range lines {
+		var r struct {
+			Timestamp  string `json:"timestamp"`
+			SendStatus string `json:"sendStatus"`
+			Sources    []struct {
+				Name     string `json:"name"`
+				Items    int    `json:"items"`
+				NewItems int    `json:"newItems"`
+			} `json:"sources"`
+		}
+		if err := json.Unmarshal([]byte(l), &r); err != nil {
+			t.Fatalf("could not parse the run report %q as JSON: %v", l, err)
+		}
+		if r.Timestamp == "" {
+			t.Errorf("expected run report %v to include a timestamp", i)
+		}
+		if r.SendStatus != "sent" {
+			t.Errorf("expected run report %v to have a send status of sent but got %q", i, r.SendStatus)
+		}
+		if len(r.Sources) != epubs {
+			t.Fatalf("expected run report %v to include %v sources but got %v", i, epubs, len(r.Sources))
+		}
+		// The pages don't change between runs, so only the first run
+		// finds new link items.
+		wantNew := linksPerPub
+		if i > 0 {
+			wantNew = 0
+		}
+		for _, s := range r.Sources {
+			if s.Items != linksPerPub || s.NewItems != wantNew {
+				t.Errorf(
+					"expected run report %v to include %v items and %v new items for %v but got %v and %v",
+					i,
+					linksPerPub,
+					wantNew,
+					s.Name,
+					s.Items,
+					s.NewItems,
+				)
+			}
+		}
+	}
+}
+
+// Make sure that an embedding program can read the outcome of the latest run
+// from the scrape.Config.
+func TestRunStatus(t *testing.T) {
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	var sc scrape.Config
+	if _, ok := sc.Status(); ok {
+		t.Fatal("expected no status before the first run")
+	}
+
+	before := time.Now()
+	if err := scrape.Run(&sc, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	st, ok := sc.Status()
+	if !ok {
+		t.Fatal("expected a status after the first run")
+	}
+	if st.Start.Before(before) || st.Duration <= 0 {
+		t.Errorf("expected the status to cover the latest run but got a start of %v and a duration of %v", st.Start, st.Duration)
+	}
+	if st.Err != nil || !st.Sent {
+		t.Errorf("expected the status of a successful run but got a send status of %v and the error %v", st.Sent, st.Err)
+	}
+	if len(st.Sources) != 1 || st.Sources[0].NewItems != linksPerPub {
+		t.Errorf("expected one source with %v new items in the status but got %+v", linksPerPub, st.Sources)
+	} else if st.Sources[0].ParseDuration <= 0 {
+		t.Errorf("expected the status to include how long the source took to parse")
+	}
+
+	// A run that fails replaces the status of the one before it
+	config.Scraping.ReadOnly = true
+	config.Scraping.StorageDirPath = filepath.Join(t.TempDir(), "missing")
+	if err := scrape.Run(&sc, &config); err == nil {
+		t.Fatal("expected an error running the scraper without a database")
+	}
+	st, _ = sc.Status()
+	if st.Err == nil || st.Sent {
+		t.Errorf("expected the status of a failed run but got a send status of %v and no error", st.Sent)
+	}
+}
+
+// Make sure that we log the size and download time of each link source's
+// response, and warn about responses over the configured size.
+func TestDownloadLogging(t *testing.T) {
+	epubs := 2
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+			TestMode:          true,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+	// The mock link sites are much larger than this
+	config.Scraping.SlowSourceWarnBytes = 10
+
+	// Scrapers log concurrently, so synchronize writes to the buffer
+	var buf bytes.Buffer
+	l := log.Logger
+	log.Logger = zerolog.New(zerolog.SyncWriter(&buf)).Level(zerolog.DebugLevel)
+	defer func() {
+		log.Logger = l
+	}()
+
+	if err := scrape.Run(&scrape.Config{OutputWr: io.Discard}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	downloads := make(map[string]bool)
+	warnings := make(map[string]bool)
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e map[string]interface{}
+		if err := json.Unmarshal([]byte(l), &e); err != nil {
+			t.Fatalf("could not parse the log line %q as JSON: %v", l, err)
+		}
+		n, _ := e["linkSource"].(string)
+		switch e["message"] {
+		case "downloaded the link source":
+			if e["level"] != "debug" {
+				t.Errorf("expected the download log for %v to be at the debug level but got %v", n, e["level"])
+			}
+			if b, ok := e["responseBytes"].(float64); !ok || b == 0 {
+				t.Errorf("expected the download log for %v to include the response size but got %v", n, l)
+			}
+			if _, ok := e["downloadMs"].(float64); !ok {
+				t.Errorf("expected the download log for %v to include the download time but got %v", n, l)
+			}
+			downloads[n] = true
+		case "the link source's response is larger than expected":
+			warnings[n] = true
+		}
+	}
+
+	for _, ls := range u {
+		if !downloads[ls.Name] {
+			t.Errorf("expected a download log for %v", ls.Name)
+		}
+		if !warnings[ls.Name] {
+			t.Errorf("expected a response size warning for %v", ls.Name)
+		}
+	}
+}
+
+// Make sure that the test recipient receives the email instead of the
+// configured recipient.
+func TestTestToAddress(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       5,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	config.EmailSettings.TestToAddress = "tester@example.com"
+	checked, err := config.CheckAndSetDefaults()
+	if err != nil {
+		t.Fatalf("unexpected error checking the app config: %v", err)
+	}
+
+	// Sending to a test address runs once even without -oneoff
+	ut := time.Now().UnixNano()
+	if err := scrape.StartLoop(context.Background(), &scrape.Config{
+		IterationLimit: 1,
+	}, &checked); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(ut)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+
+	if len(ems) != 1 {
+		t.Fatalf("expecting 1 email but got %v", len(ems))
+	}
+
+	if !strings.Contains(ems[0], "To: <tester@example.com>") {
+		t.Errorf("expected the email to go to the test recipient but got %v", ems[0])
+	}
+	if strings.Contains(ems[0], "recipient@example.com") {
+		t.Errorf("expected the email not to go to the configured recipient but got %v", ems[0])
+	}
+
+	// The test send didn't store any link items, so the next normal run
+	// emails the same link items to the configured recipient
+	ut = time.Now().UnixNano()
+	if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+	ems2, err := testenv.SMTPServer.RetrieveEmails(ut)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+	if len(ems2) != 1 {
+		t.Fatalf("expecting 1 email after the test send but got %v", len(ems2))
+	}
+	if !strings.Contains(ems2[0], "To: <recipient@example.com>") {
+		t.Errorf("expected the email to go to the configured recipient but got %v", ems2[0])
+	}
+	before, after := smtptest.ExtractItems(ems[0]), smtptest.ExtractItems(ems2[0])
+	sort.Strings(before)
+	sort.Strings(after)
+	if len(before) == 0 || strings.Join(before, "\n") != strings.Join(after, "\n") {
+		t.Errorf("expected the configured recipient to get the link items %v but got %v", before, after)
+	}
+}
+
+// Make sure that, if the user allows it, we still send an email when we can't
+// open the database, and that we fail the run otherwise.
+func TestDegradeOnStorageError(t *testing.T) {
+	testCases := []struct {
+		description string
+		degrade     bool
+		expectErr   bool
+		expectEmail bool
+	}{
+		{
+			description: "no fallback",
+			degrade:     false,
+			expectErr:   true,
+			expectEmail: false,
+		},
+		{
+			description: "fallback",
+			degrade:     true,
+			expectErr:   false,
+			expectEmail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+				numHTTPServers: 1,
+				numLinks:       5,
+			})
+
+			defer testenv.tearDown()
+
+			if err != nil {
+				t.Fatalf("error starting test environment: %v", err)
+			}
+
+			// Use a storage directory within a regular file, which we
+			// can't create even with root privileges, unlike a
+			// directory without write permissions.
+			f := filepath.Join(testenv.tempDirPath, "not-a-directory")
+			if err := os.WriteFile(f, []byte("hello"), 0o444); err != nil {
+				t.Fatalf("can't create the file for the storage directory: %v", err)
+			}
+
+			urls := testenv.urls()
+			u := make([]mockLinksrcInfo, len(urls), len(urls))
+			for i := range urls {
+				pu, _ := url.Parse(urls[i])
+
+				u[i] = mockLinksrcInfo{
+					URL:  urls[i],
+					Name: fmt.Sprintf("site-%v", pu.Port()),
+				}
+			}
+
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress:     testenv.SMTPServer.Address(),
+					LinkSources:           u,
+					StorageDir:            filepath.Join(f, "db"),
+					PollInterval:          "5s", // Ignored here
+					DegradeOnStorageError: tc.degrade,
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+
+			ut := time.Now().UnixNano()
+			res, err := scrape.RunWithResult(&scrape.Config{}, &config)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("expected error status of %v but got %v", tc.expectErr, err)
+			}
+
+			ems, err := testenv.SMTPServer.RetrieveEmails(ut)
+			if err != nil {
+				t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+			}
+
+			if !tc.expectEmail {
+				if len(ems) != 0 {
+					t.Fatalf("expecting no emails but got %v", len(ems))
+				}
+				return
+			}
+
+			if len(ems) != 1 {
+				t.Fatalf("expecting 1 email but got %v", len(ems))
+			}
+			if res.StorageErr == nil {
+				t.Error("expected the run result to include the storage error")
+			}
+			if res.Sources[0].NewItems != 5 {
+				t.Errorf("expected every link item to be new but got %v new items", res.Sources[0].NewItems)
+			}
+			if !strings.Contains(ems[0], "could not open its database") {
+				t.Errorf("expected the email to include a notice about the database but got %v", ems[0])
+			}
+		})
+	}
+}
+
+// Make sure that cancelling the context passed to StartLoop stops the
+// scraper.
+func TestStartLoopCancellation(t *testing.T) {
+	epubs := 1
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: epubs,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	// The tick channel never receives a tick, so the scraper would wait
+	// forever after the first scrape unless we stop it.
+	scrapeConfig := scrape.Config{
+		TickCh: make(chan time.Time),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- scrape.StartLoop(ctx, &scrapeConfig, &config)
+	}()
+
+	// Wait for the first email, which is sent right away
+	deadline := time.Now().Add(time.Duration(10) * time.Second)
+	for {
+		ems, err := testenv.SMTPServer.RetrieveEmails(0)
+		if err != nil {
+			t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+		}
+		if len(ems) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the first email")
+		}
+		time.Sleep(time.Duration(50) * time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error after stopping the scraper but got %v", err)
+		}
+	case <-time.After(time.Duration(5) * time.Second):
+		t.Fatal("the scraper did not stop after the context was cancelled")
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	testCases := []struct {
+		description    string
+		runRetries     uint
+		expectedEmails int
+	}{
+		{
+			description:    "send fails once with a retry",
+			runRetries:     2,
+			expectedEmails: 1,
+		},
+		{
+			description:    "send fails once without retries",
+			runRetries:     0,
+			expectedEmails: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			linksPerPub := 5
+			testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+				numHTTPServers: 1,
+				numLinks:       linksPerPub,
+			})
+
+			defer testenv.tearDown()
+
+			if err != nil {
+				t.Fatalf("error starting test environment: %v", err)
+			}
+
+			urls := testenv.urls()
+			u := make([]mockLinksrcInfo, len(urls), len(urls))
+			for i := range urls {
+				pu, _ := url.Parse(urls[i])
+
+				u[i] = mockLinksrcInfo{
+					URL:  urls[i],
+					Name: fmt.Sprintf("site-%v", pu.Port()),
+				}
+			}
+
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress: testenv.SMTPServer.Address(),
+					LinkSources:       u,
+					StorageDir:        testenv.tempDirPath,
+					PollInterval:      "5s",
+					OneOff:            true,
+					RunRetries:        tc.runRetries,
+					RunRetryBackoff:   time.Duration(10) * time.Millisecond,
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+
+			// The SMTP relay is briefly unavailable
+			testenv.SMTPServer.(*smtptest.InProcessServer).RejectNext(1)
+
+			if err := scrape.StartLoop(context.Background(), &scrape.Config{}, &config); err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+
+			ems, err := testenv.SMTPServer.RetrieveEmails(0)
+			if err != nil {
+				t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+			}
+
+			if len(ems) != tc.expectedEmails {
+				t.Fatalf("expected %v emails but got %v", tc.expectedEmails, len(ems))
+			}
+
+			for _, em := range ems {
+				if n := len(smtptest.ExtractItems(em)); n != linksPerPub {
+					t.Errorf("expected the email to include %v link items but got %v", linksPerPub, n)
+				}
+			}
+		})
+	}
+}
+
+// Make sure that if only some parts of a split newsletter fail to send, we
+// don't retry the scrape cycle and send the other parts twice, and that the
+// link items in the failed parts are still new in the next scrape cycle.
+func TestRunRetriesAfterPartialSend(t *testing.T) {
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 2,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored here
+			RunRetries:        2,
+			RunRetryBackoff:   time.Duration(10) * time.Millisecond,
+			// Small enough for one link source per email
+			MaxEmailBytes:    3000,
+			SplitLargeEmails: true,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	// The SMTP relay accepts the first part and rejects the second
+	testenv.SMTPServer.(*smtptest.InProcessServer).RejectAfter(1, 1)
+
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	if err := scrape.StartLoop(context.Background(), &scrape.Config{
+		TickCh:         ch,
+		IterationLimit: 1,
+	}, &config); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+	}
+
+	// The first part from the first scrape cycle, then the part that
+	// failed, sent in the second scrape cycle
+	if len(ems) != 2 {
+		t.Fatalf("expected 2 emails but got %v", len(ems))
+	}
+
+	seen := make(map[string]bool)
+	for _, em := range ems {
+		items := smtptest.ExtractItems(em)
+		if len(items) != linksPerPub {
+			t.Errorf("expected each email to include %v link items but got %v", linksPerPub, len(items))
+		}
+		for _, it := range items {
+			if seen[it] {
+				t.Errorf("got the link item %v in more than one email", it)
+			}
+			seen[it] = true
+		}
+	}
+}
+
+func TestRunWarnings(t *testing.T) {
+	testCases := []struct {
+		description      string
+		itemSelector     string
+		warnIfZeroItems  bool
+		warnIfSlowerThan time.Duration
+		expectedWarnings []string
+	}{
+		{
+			description:      "selectors that no longer match",
+			itemSelector:     "ol li",
+			warnIfZeroItems:  true,
+			expectedWarnings: []string{"the scrape found no new link items"},
+		},
+		{
+			description:     "new link items",
+			warnIfZeroItems: true,
+		},
+		{
+			description:      "slow run",
+			warnIfSlowerThan: time.Duration(1) * time.Nanosecond,
+			expectedWarnings: []string{"the scrape took longer than expected"},
+		},
+		{
+			description:      "selectors that no longer match without a warning",
+			itemSelector:     "ol li",
+			warnIfSlowerThan: time.Duration(1) * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+				numHTTPServers: 1,
+				numLinks:       5,
+			})
+
+			defer testenv.tearDown()
+
+			if err != nil {
+				t.Fatalf("error starting test environment: %v", err)
+			}
+
+			urls := testenv.urls()
+			u := make([]mockLinksrcInfo, len(urls), len(urls))
+			for i := range urls {
+				pu, _ := url.Parse(urls[i])
+
+				u[i] = mockLinksrcInfo{
+					URL:          urls[i],
+					Name:         fmt.Sprintf("site-%v", pu.Port()),
+					ItemSelector: tc.itemSelector,
+				}
+			}
+
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress: testenv.SMTPServer.Address(),
+					LinkSources:       u,
+					StorageDir:        testenv.tempDirPath,
+					PollInterval:      "5s", // Ignored here
+					TestMode:          true,
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+			config.Scraping.WarnIfZeroItems = tc.warnIfZeroItems
+			config.Scraping.WarnIfSlowerThan = tc.warnIfSlowerThan
+
+			var buf bytes.Buffer
+			l := log.Logger
+			log.Logger = zerolog.New(zerolog.SyncWriter(&buf)).Level(zerolog.WarnLevel)
+			defer func() {
+				log.Logger = l
+			}()
+
+			if err := scrape.StartLoop(
+				context.Background(),
+				&scrape.Config{OutputWr: io.Discard},
+				&config,
+			); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+
+			var warnings []string
+			for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				if l == "" {
+					continue
+				}
+				var e map[string]interface{}
+				if err := json.Unmarshal([]byte(l), &e); err != nil {
+					t.Fatalf("could not parse the log line %q as JSON: %v", l, err)
+				}
+				if e["level"] == "warn" {
+					warnings = append(warnings, e["message"].(string))
+				}
+			}
+
+			if len(warnings) != len(tc.expectedWarnings) {
+				t.Fatalf("expected the warnings %v but got %v", tc.expectedWarnings, warnings)
+			}
+			for i := range warnings {
+				if warnings[i] != tc.expectedWarnings[i] {
+					t.Errorf("expected the warnings %v but got %v", tc.expectedWarnings, warnings)
+				}
+			}
+		})
+	}
+}
+
+// Make sure that link items we leave out of an email to stay within
+// maxTotalItems are still new in the next email.
+func TestLeftOutItemsStayNew(t *testing.T) {
+	linksPerPub := 5
+	maxItems := 2
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources: []mockLinksrcInfo{
+				{URL: testenv.urls()[0], Name: "site"},
+			},
+			StorageDir:    testenv.tempDirPath,
+			PollInterval:  "5s", // Ignored here
+			MaxTotalItems: uint(maxItems),
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	// Enough runs to send every link item
+	runs := (linksPerPub + maxItems - 1) / maxItems
+	for i := 0; i < runs; i++ {
+		if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+			t.Fatalf("unexpected error running the scraper: %v", err)
+		}
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+	if len(ems) != runs {
+		t.Fatalf("expected %v emails but got %v", runs, len(ems))
+	}
+
+	seen := make(map[string]bool)
+	for _, em := range ems {
+		for _, it := range smtptest.ExtractItems(em) {
+			if seen[it] {
+				t.Errorf("got the link item %v in more than one email", it)
+			}
+			seen[it] = true
+		}
+	}
+	if len(seen) != linksPerPub {
+		t.Errorf("expected the emails to include all %v link items but got %v", linksPerPub, len(seen))
+	}
+}
+
+func TestFailedSendKeepsLinkItems(t *testing.T) {
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		pu, _ := url.Parse(urls[i])
+
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: fmt.Sprintf("site-%v", pu.Port()),
+		}
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress: testenv.SMTPServer.Address(),
+			LinkSources:       u,
+			StorageDir:        testenv.tempDirPath,
+			PollInterval:      "5s", // Ignored in this case
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	// The first email fails to send
+	testenv.SMTPServer.(*smtptest.InProcessServer).RejectNext(1)
+	res, err := scrape.RunWithResult(&scrape.Config{}, &config)
+	if err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+	if res.SendErr == nil {
+		t.Fatal("expected the first email to fail to send")
+	}
+
+	if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(0)
+	if err != nil {
+		t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+	}
+	if len(ems) != 1 {
+		t.Fatalf("expected one email but got %v", len(ems))
+	}
+	if n := len(smtptest.ExtractItems(ems[0])); n != linksPerPub {
+		t.Errorf("expected the link items from the failed email in the next one, but got %v of %v", n, linksPerPub)
+	}
+
+	// Now that the reader has the link items, they're no longer new
+	ut := time.Now().UnixNano()
+	if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+	ems, err = testenv.SMTPServer.RetrieveEmails(ut)
+	if err != nil {
+		t.Fatalf("can't retrieve email from the test SMTP server: %v", err)
+	}
+	for _, em := range ems {
+		if n := len(smtptest.ExtractItems(em)); n != 0 {
+			t.Errorf("expected no link items after a successful send but got %v", n)
+		}
+	}
+}
+
+// Make sure that a link source that publishes the same caption at a new URL
+// every day, e.g., a daily briefing, only sends the caption once if it
+// dedupes captions across runs.
+func TestDedupeCaptionAcrossRuns(t *testing.T) {
+	testCases := []struct {
+		description   string
+		dedupe        bool
+		expectedLinks int
+	}{
+		{
+			description:   "caption deduplication off",
+			dedupe:        false,
+			expectedLinks: 2,
+		},
+		{
+			description:   "caption deduplication on",
+			dedupe:        true,
+			expectedLinks: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+				numHTTPServers: 1,
+				numLinks:       1,
+			})
+
+			defer testenv.tearDown()
+
+			if err != nil {
+				t.Fatalf("error starting test environment: %v", err)
+			}
+
+			tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+			var mu sync.Mutex
+			listings := []mockArticleListing{
+				{Caption: "Your morning briefing for today", URL: "https://www.example.com/briefing/2026-10-15"},
+				{Caption: "A story that ran this morning", URL: "https://www.example.com/articles/1"},
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if err := tmpl.Execute(rw, listings); err != nil {
+					panic(fmt.Sprintf("error executing the link site template: %v", err))
+				}
+			}))
+			defer srv.Close()
+
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress: testenv.SMTPServer.Address(),
+					LinkSources: []mockLinksrcInfo{
+						{
+							URL:  srv.URL,
+							Name: "daily-site",
+						},
+					},
+					StorageDir:   testenv.tempDirPath,
+					PollInterval: "5s", // Ignored in this case
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+			config.LinkSources[0].DedupeCaptionAcrossRuns = tc.dedupe
+
+			// The first run stores every link item in the database
+			if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+
+			// The next day's briefing has the same caption at a
+			// new URL
+			mu.Lock()
+			listings = []mockArticleListing{
+				{Caption: "Your morning briefing for today", URL: "https://www.example.com/briefing/2026-10-16"},
+				{Caption: "A story that ran the next morning", URL: "https://www.example.com/articles/2"},
+				{Caption: "A story that ran this morning", URL: "https://www.example.com/articles/1"},
+			}
+			mu.Unlock()
+			ut := time.Now().UnixNano()
+
+			if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+
+			ems, err := testenv.SMTPServer.RetrieveEmails(ut)
+			if err != nil {
+				t.Fatalf("can't retrieve emails after the update: %v", err)
+			}
+			if len(ems) != 1 {
+				t.Fatalf("expected one email after the update but got %v", len(ems))
+			}
+
+			if n := len(smtptest.ExtractItems(ems[0])); n != tc.expectedLinks {
+				t.Errorf("expected %v links in the second email but got %v", tc.expectedLinks, n)
+			}
+		})
+	}
+}
+
+// Make sure that the sectionOrder option puts the sections of the email in
+// the expected order, no matter which link source finishes first.
+func TestSectionOrder(t *testing.T) {
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       1,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	// The number of link items at each link source, in the order of the
+	// config
+	counts := map[string]int{
+		"quiet-site":  1,
+		"busy-site":   3,
+		"steady-site": 2,
+	}
+	names := []string{"quiet-site", "busy-site", "steady-site"}
+
+	tmpl := template.Must(template.New("listings").Parse(linkSiteTmpl))
+	var sources []mockLinksrcInfo
+	for _, n := range names {
+		var listings []mockArticleListing
+		for i := 0; i < counts[n]; i++ {
+			listings = append(listings, mockArticleListing{
+				Caption: fmt.Sprintf("Article number %v from %v", i, n),
+				URL:     fmt.Sprintf("https://www.example.com/%v/%v", n, i),
+			})
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if err := tmpl.Execute(rw, listings); err != nil {
+				panic(fmt.Sprintf("error executing the link site template: %v", err))
+			}
+		}))
+		defer srv.Close()
+		sources = append(sources, mockLinksrcInfo{
+			URL:  srv.URL,
+			Name: n,
+		})
+	}
+
+	testCases := []struct {
+		order    string
+		expected []string
+	}{
+		{
+			order:    "",
+			expected: []string{"quiet-site", "busy-site", "steady-site"},
+		},
+		{
+			order:    userconfig.SectionOrderConfig,
+			expected: []string{"quiet-site", "busy-site", "steady-site"},
+		},
+		{
+			order:    userconfig.SectionOrderName,
+			expected: []string{"busy-site", "quiet-site", "steady-site"},
+		},
+		{
+			order:    userconfig.SectionOrderCountDesc,
+			expected: []string{"busy-site", "steady-site", "quiet-site"},
+		},
+		{
+			order:    userconfig.SectionOrderCountAsc,
+			expected: []string{"quiet-site", "steady-site", "busy-site"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("order %q", tc.order), func(t *testing.T) {
+			config, err := createUserConfig(
+				appConfigOptions{
+					SMTPServerAddress: testenv.SMTPServer.Address(),
+					LinkSources:       sources,
+					StorageDir:        testenv.tempDirPath,
+					PollInterval:      "5s", // Ignored here
+					TestMode:          true,
+				},
+			)
+			if err != nil {
+				panic(fmt.Sprintf("can't create the app config: %v", err))
+			}
+			config.Scraping.SectionOrder = tc.order
+
+			var msg bytes.Buffer
+			if err := scrape.Run(&scrape.Config{OutputWr: &msg}, &config); err != nil {
+				t.Fatalf("unexpected error running the scraper: %v", err)
+			}
+
+			o := msg.String()
+			last := -1
+			for _, n := range tc.expected {
+				i := strings.Index(o, n)
+				if i == -1 {
+					t.Fatalf("expected the email to include a section for %v but got %v", n, o)
+				}
+				if i < last {
+					t.Fatalf("expected the sections in the order %v but got %v", tc.expected, o)
+				}
+				last = i
+			}
+		})
+	}
+}
+
+func TestNewsletterTemplates(t *testing.T) {
+	linksPerPub := 5
+	testenv, err := startTestEnvironment(t, testEnvironmentConfig{
+		numHTTPServers: 1,
+		numLinks:       linksPerPub,
+	})
+
+	defer testenv.tearDown()
+
+	if err != nil {
+		t.Fatalf("error starting test environment: %v", err)
+	}
+
+	urls := testenv.urls()
+	u := make([]mockLinksrcInfo, len(urls), len(urls))
+	for i := range urls {
+		u[i] = mockLinksrcInfo{
+			URL:  urls[i],
+			Name: "template-site",
+		}
+	}
+
+	dir := t.TempDir()
+	sp := filepath.Join(dir, "subject.tmpl")
+	if err := os.WriteFile(sp, []byte("{{.Count}} new links\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ip := filepath.Join(dir, "intro.tmpl")
+	if err := os.WriteFile(ip, []byte("Links from {{range .Sources}}{{.}}{{end}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := createUserConfig(
+		appConfigOptions{
+			SMTPServerAddress:   testenv.SMTPServer.Address(),
+			LinkSources:         u,
+			StorageDir:          testenv.tempDirPath,
+			PollInterval:        "5s", // Ignored in this case
+			SubjectTemplateFile: sp,
+			IntroTemplateFile:   ip,
+		},
+	)
+	if err != nil {
+		panic(fmt.Sprintf("can't create the app config: %v", err))
+	}
+
+	ut := time.Now().UnixNano()
+	if err := scrape.Run(&scrape.Config{}, &config); err != nil {
+		t.Fatalf("unexpected error running the scraper: %v", err)
+	}
+
+	ems, err := testenv.SMTPServer.RetrieveEmails(ut)
+	if err != nil {
+		t.Fatalf("can't retrieve emails from the test SMTP server: %v", err)
+	}
+	if len(ems) != 1 {
+		t.Fatalf("expected one email but got %v", len(ems))
+	}
+	if !strings.Contains(ems[0], fmt.Sprintf("Subject: %v new links\r\n", linksPerPub)) {
+		t.Errorf("expected the subject to include the number of link items but got %v", ems[0])
+	}
+	if !strings.Contains(ems[0], "Links from template-site") {
+		t.Errorf("expected the email to include the intro but got %v", ems[0])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/ptgott/one-newsletter/smtptest"
 )
@@ -59,6 +60,12 @@ func startTestEnvironment(t *testing.T, c testEnvironmentConfig) (*testEnvironme
 	te.SMTPServer = ts
 
 	go ts.Start()
+	// Make sure the server is listening before we return, so a test that
+	// doesn't send any email can't close the server before it starts
+	// listening and leave it running for the next test.
+	if err := ts.WaitUntilReady(time.Duration(5) * time.Second); err != nil {
+		return te, err
+	}
 
 	sg := startTestServerGroup(c.numHTTPServers, c.numLinks)
 
@@ -5,19 +5,32 @@ import (
 	"bytes"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/smtp"
 	"net/textproto"
 	"net/url"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/idna"
+	yaml "gopkg.in/yaml.v2"
 )
 
 type localStatus int
 
 const smtpScheme string = "smtp://"
 
+// By default, give up on connecting to the SMTP server after this long so that
+// an unresponsive server doesn't block the scraper.
+const defaultDialTimeout = 10 * time.Second
+
 // UserConfig represents config options provided the user. Not meant to be used
 // directly for sending email without validation.
 //
@@ -35,6 +48,20 @@ type UserConfig struct {
 	// in a test environment but certification verification, since any cert used
 	// by a test server would need to be self signed.
 	SkipCertVerification bool
+	// How long to wait when connecting to the SMTP server before giving up
+	DialTimeout time.Duration
+	// Send email without authenticating, e.g., to a relay on localhost. If
+	// this is true, UserName and Password are optional, and we only
+	// authenticate if they're provided and the server advertises AUTH.
+	AllowUnauthenticated bool
+	// Put the text/html MIME part before the text/plain part. This breaks
+	// with RFC 2046, which puts the best representation last, but some
+	// clients display the first part regardless.
+	HTMLPartFirst bool
+	// Send email to this address instead of ToAddress, e.g., to check a real
+	// send through the SMTP server without emailing the usual recipient.
+	// Ignored if blank.
+	TestToAddress string
 }
 
 // CheckAndSetDefaults validates s and either returns a copy of c with default
@@ -61,19 +88,98 @@ func (c *UserConfig) CheckAndSetDefaults() (UserConfig, error) {
 		return UserConfig{}, errors.New("email config must include a \"to\" address for sending email")
 	}
 
-	if c.UserName == "" {
+	if c.UserName == "" && !c.AllowUnauthenticated {
 		return UserConfig{}, errors.New(
 			"email config must include a username for the SMTP relay server or message transfer agent",
 		)
 	}
 
-	if c.Password == "" {
+	if c.Password == "" && !c.AllowUnauthenticated {
 		return UserConfig{}, errors.New("email config must include a password for the SMTP relay server or MTA")
 	}
 
+	// Convert internationalized domain names to Punycode, since the SMTP
+	// protocol only supports ASCII hostnames.
+	h, err := domainToASCII(c.SMTPServerHost)
+	if err != nil {
+		return UserConfig{}, fmt.Errorf("the SMTP server host %v is not a valid domain name: %v", c.SMTPServerHost, err)
+	}
+	uc.SMTPServerHost = h
+
+	fa, err := addressToASCII(c.FromAddress)
+	if err != nil {
+		return UserConfig{}, fmt.Errorf("the \"from\" address is invalid: %v", err)
+	}
+	uc.FromAddress = fa
+
+	ta, err := addressToASCII(c.ToAddress)
+	if err != nil {
+		return UserConfig{}, fmt.Errorf("the \"to\" address is invalid: %v", err)
+	}
+	uc.ToAddress = ta
+
+	if c.TestToAddress != "" {
+		tta, err := addressToASCII(c.TestToAddress)
+		if err != nil {
+			return UserConfig{}, fmt.Errorf("the test \"to\" address is invalid: %v", err)
+		}
+		log.Warn().
+			Str("testToAddress", tta).
+			Msg("sending email to the test address instead of the configured recipient")
+		uc.ToAddress = tta
+	}
+
+	if c.DialTimeout < 0 {
+		return UserConfig{}, errors.New("the dial timeout for the SMTP server can't be negative")
+	}
+
+	if c.DialTimeout == 0 {
+		uc.DialTimeout = defaultDialTimeout
+	}
+
 	return uc, nil
 }
 
+// idnaProfile converts internationalized domain names for lookup without the
+// STD3 rules of idna.Lookup, which reject hostnames that work in practice,
+// e.g., names with underscores on a private network.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false))
+
+// domainToASCII converts the domain name d to Punycode. IP addresses and
+// names that are already ASCII are returned as is.
+func domainToASCII(d string) (string, error) {
+	if net.ParseIP(strings.Trim(d, "[]")) != nil || isASCII(d) {
+		return d, nil
+	}
+	return idnaProfile.ToASCII(d)
+}
+
+// isASCII returns whether s only contains ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+// addressToASCII converts the domain part of the email address a to Punycode.
+// The local part is left as is.
+func addressToASCII(a string) (string, error) {
+	i := strings.LastIndex(a, "@")
+	if i == -1 {
+		return "", fmt.Errorf("%v must include a domain", a)
+	}
+
+	d, err := domainToASCII(a[i+1:])
+	if err != nil {
+		return "", fmt.Errorf("%v has an invalid domain: %v", a, err)
+	}
+
+	return a[:i+1] + d, nil
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface. Validation is
 // performed here.
 func (uc *UserConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -108,6 +214,13 @@ func (uc *UserConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return errors.New("the SMTP server address is not a valid URL: " + err.Error())
 	}
 
+	// If the address is missing altogether, CheckAndSetDefaults reports it.
+	if ok {
+		if err := validateSMTPAddress(u); err != nil {
+			return err
+		}
+	}
+
 	uc.SMTPServerHost = u.Hostname()
 	uc.SMTPServerPort = u.Port()
 
@@ -134,14 +247,123 @@ func (uc *UserConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		pw = ""
 	}
 	uc.Password = pw
+
+	if au, ok := v["allowUnauthenticated"]; ok {
+		b, err := strconv.ParseBool(au)
+		if err != nil {
+			return errors.New("invalid allowUnauthenticated: must be true or false")
+		}
+		uc.AllowUnauthenticated = b
+	}
+
+	if hf, ok := v["htmlPartFirst"]; ok {
+		b, err := strconv.ParseBool(hf)
+		if err != nil {
+			return errors.New("invalid htmlPartFirst: must be true or false")
+		}
+		uc.HTMLPartFirst = b
+	}
+
+	if dt, ok := v["dialTimeout"]; ok {
+		d, err := time.ParseDuration(dt)
+		if err != nil {
+			return fmt.Errorf("cannot parse the dial timeout: %v", err)
+		}
+		uc.DialTimeout = d
+	}
+
 	return nil
 }
 
+// MarshalYAML implements the yaml.Marshaler interface. We use the same keys as
+// UnmarshalYAML and leave out options with zero values.
+func (uc UserConfig) MarshalYAML() (interface{}, error) {
+	var o yaml.MapSlice
+	add := func(key string, v interface{}) {
+		if reflect.ValueOf(v).IsZero() {
+			return
+		}
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		o = append(o, yaml.MapItem{Key: key, Value: v})
+	}
+
+	if uc.SMTPServerHost != "" {
+		add("smtpServerAddress", net.JoinHostPort(uc.SMTPServerHost, uc.SMTPServerPort))
+	}
+	add("fromAddress", uc.FromAddress)
+	add("toAddress", uc.ToAddress)
+	add("username", uc.UserName)
+	add("password", uc.Password)
+	add("allowUnauthenticated", uc.AllowUnauthenticated)
+	add("htmlPartFirst", uc.HTMLPartFirst)
+	add("dialTimeout", uc.DialTimeout)
+	add("skipCertVerification", uc.SkipCertVerification)
+
+	return o, nil
+}
+
+// validateSMTPAddress checks that the SMTP server URL u includes a host and a
+// port number that we can dial.
+func validateSMTPAddress(u *url.URL) error {
+	if u.Hostname() == "" {
+		return fmt.Errorf("the SMTP server address %v must include a host", u.Host)
+	}
+
+	if u.Port() == "" {
+		return fmt.Errorf("the SMTP server address %v must include a port", u.Host)
+	}
+
+	p, err := strconv.Atoi(u.Port())
+	if err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf(
+			"the SMTP server port %v must be a number between 1 and 65535",
+			u.Port(),
+		)
+	}
+
+	return nil
+}
+
+// The subject of each newsletter email if the caller doesn't supply one
+const newsletterSubject = "New links to look at"
+
 // SendNewsletter sends the newsletter to the SMTP server. Callers must supply the
 // newsletter as the `text/plain` MIME type in the asText param  and the
 // `text/html` type in asHTML. A lack of an error means the message was
 // received by the destination SMTP server.
 func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
+	return uc.sendNewsletter(newsletterSubject, asText, asHTML)
+}
+
+// SendNewsletterWithSubject is like SendNewsletter, but uses subject as the
+// subject line. If subject is blank, we use the default.
+func (uc UserConfig) SendNewsletterWithSubject(subject string, asText, asHTML []byte) error {
+	if subject == "" {
+		subject = newsletterSubject
+	}
+	return uc.sendNewsletter(subject, asText, asHTML)
+}
+
+// SendNewsletterPart is like SendNewsletterWithSubject, but for one of several
+// emails that make up a newsletter that's too large to send at once. part is
+// the 1-based number of this email out of parts emails, and we add it to the
+// subject.
+func (uc UserConfig) SendNewsletterPart(subject string, asText, asHTML []byte, part, parts int) error {
+	if subject == "" {
+		subject = newsletterSubject
+	}
+	return uc.sendNewsletter(
+		fmt.Sprintf("%v (part %v of %v)", subject, part, parts),
+		asText,
+		asHTML,
+	)
+}
+
+// sendNewsletter sends the newsletter to the SMTP server with the subject
+// line subject. See SendNewsletter.
+func (uc UserConfig) sendNewsletter(subject string, asText, asHTML []byte) error {
 
 	auth := smtp.PlainAuth("", uc.UserName, uc.Password, uc.SMTPServerHost)
 
@@ -157,7 +379,8 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 	// Note that as per RFC 2046, we're putting the `text/html` entity
 	// last within the "multipart/alternative" entity since it's the best
 	// representation of the document. Servers can use the `text/plain`
-	// entity as well if they need to.
+	// entity as well if they need to. Users can reverse this order for
+	// clients that always display the first entity.
 
 	// Write the RFC 822 message headers. We need to do this manually. See:
 	// https://golang.org/pkg/net/smtp/#SendMail
@@ -166,7 +389,7 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 	headerWriter := textproto.NewWriter(msg)
 	headerWriter.PrintfLine("From: Your Link Newsletter<%s>", uc.FromAddress)
 	headerWriter.PrintfLine("To: <%s>", uc.ToAddress)
-	headerWriter.PrintfLine("Subject: New links to look at")
+	headerWriter.PrintfLine("Subject: %v", encodeSubject(subject))
 
 	// Create the multipart/alternative RFC 2046 entity
 	var ab bytes.Buffer
@@ -180,15 +403,24 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 	)
 	headerWriter.PrintfLine("") // blank line before message body
 
-	pw, _ := altWriter.CreatePart(
-		map[string][]string{"Content-Type": {"text/plain"}},
-	)
-	pw.Write(asText)
+	type part struct {
+		contentType string
+		body        []byte
+	}
+	parts := []part{
+		{contentType: "text/plain", body: asText},
+		{contentType: "text/html", body: asHTML},
+	}
+	if uc.HTMLPartFirst {
+		parts[0], parts[1] = parts[1], parts[0]
+	}
 
-	hw, _ := altWriter.CreatePart(
-		map[string][]string{"Content-Type": {"text/html"}},
-	)
-	hw.Write(asHTML)
+	for _, p := range parts {
+		w, _ := altWriter.CreatePart(
+			map[string][]string{"Content-Type": {p.contentType}},
+		)
+		w.Write(p.body)
+	}
 
 	msg.Write(ab.Bytes()) // add the multipart body to the email message
 	msg.Flush()
@@ -196,12 +428,27 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 	// Send the email. This is copied with minor adjustments from smtp.SendMail
 	// See: https://golang.org/src/net/smtp/smtp.go?s=9381:9459#L313
 
-	// Connect to the remote SMTP server.
-	c, err := smtp.Dial(uc.SMTPServerHost + ":" + uc.SMTPServerPort)
+	// Connect to the remote SMTP server. We dial the connection ourselves,
+	// rather than using smtp.Dial, so we can time out if the server is
+	// unresponsive. The timeout also applies to reading the server's
+	// greeting, since a server can accept a connection without ever
+	// responding.
+	addr := net.JoinHostPort(uc.SMTPServerHost, uc.SMTPServerPort)
+	conn, err := net.DialTimeout("tcp", addr, uc.DialTimeout)
+	if err != nil {
+		return fmt.Errorf("cannot connect to the remote SMTP server: %v", err)
+	}
+
+	if uc.DialTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(uc.DialTimeout))
+	}
+	c, err := smtp.NewClient(conn, uc.SMTPServerHost)
 	if err != nil {
-		log.Fatal().Err(err).Msg("cannot connect to the remote SMTP server")
+		conn.Close()
+		return fmt.Errorf("cannot start an SMTP session with the remote server: %v", err)
 	}
 	defer c.Close()
+	conn.SetDeadline(time.Time{})
 
 	if ok, _ := c.Extension("STARTTLS"); ok {
 		config := &tls.Config{
@@ -217,11 +464,22 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 		return errors.New("SMTP server does not support STARTTLS")
 	}
 
-	if ok, _ := c.Extension("AUTH"); !ok {
+	// Only skip AUTH if the user has opted into unauthenticated email.
+	// Otherwise, we'd send the email without the credentials the user
+	// expects us to use.
+	hasAuth, _ := c.Extension("AUTH")
+	hasCreds := uc.UserName != "" && uc.Password != ""
+	switch {
+	case hasAuth && hasCreds:
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	case uc.AllowUnauthenticated:
+		log.Debug().Msg("sending email without SMTP authentication")
+	case !hasAuth:
 		return errors.New("SMTP server doesn't support AUTH")
-	}
-	if err = c.Auth(auth); err != nil {
-		return err
+	default:
+		return errors.New("SMTP credentials are required unless allowUnauthenticated is true")
 	}
 
 	if err := c.Mail(uc.FromAddress); err != nil {
@@ -252,3 +510,10 @@ func (uc UserConfig) SendNewsletter(asText, asHTML []byte) error {
 	}
 	return nil
 }
+
+// encodeSubject prepares subject for the Subject header. Subjects can come
+// from user templates, so we collapse any line breaks that would end the
+// header early, then use RFC 2047 encoding if the subject isn't plain ASCII.
+func encodeSubject(subject string) string {
+	return mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject), " "))
+}
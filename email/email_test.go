@@ -3,11 +3,13 @@ package email
 import (
 	"bytes"
 	"mime/multipart"
+	"net"
 	"net/url"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ptgott/one-newsletter/smtptest"
 
@@ -46,6 +48,67 @@ password: 123456-A_BCDE
 `,
 			shouldBeError: false,
 		},
+		{
+			description: "no host",
+			input: `smtpServerAddress: smtp://:25
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+`,
+			shouldBeError: true,
+		},
+		{
+			description: "no port",
+			input: `smtpServerAddress: smtp://0.0.0.0
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+`,
+			shouldBeError: true,
+		},
+		{
+			description: "non-numeric port",
+			input: `smtpServerAddress: 0.0.0.0:smtp
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+`,
+			shouldBeError: true,
+		},
+		{
+			description: "port out of range",
+			input: `smtpServerAddress: smtp://0.0.0.0:65536
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+`,
+			shouldBeError: true,
+		},
+		{
+			description: "port zero",
+			input: `smtpServerAddress: smtp://0.0.0.0:0
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+`,
+			shouldBeError: true,
+		},
+		{
+			description: "unparseable dial timeout",
+			input: `smtpServerAddress: smtp://0.0.0.0:123
+fromAddress: mynewsletter@example.com
+toAddress: recipient@example.com
+username: MyUser123
+password: 123456-A_BCDE
+dialTimeout: 5 seconds
+`,
+			shouldBeError: true,
+		},
 		{
 			description:   "not a map[string]string",
 			input:         `[]`,
@@ -105,6 +168,10 @@ func TestSend(t *testing.T) {
 	}(srv)
 	defer srv.Close()
 
+	if err := srv.WaitUntilReady(time.Duration(5) * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
 	err = uc.SendNewsletter(bodText, bodHTML)
 	if err != nil {
 		t.Fatalf(
@@ -181,6 +248,277 @@ func TestSend(t *testing.T) {
 
 }
 
+// TestSendPartOrder makes sure that we can reverse the order of the MIME
+// parts within the email.
+func TestSendPartOrder(t *testing.T) {
+	cases := []struct {
+		description   string
+		htmlPartFirst bool
+		expected      []string
+	}{
+		{
+			description:   "default order",
+			htmlPartFirst: false,
+			expected:      []string{"text/plain", "text/html"},
+		},
+		{
+			description:   "HTML part first",
+			htmlPartFirst: true,
+			expected:      []string{"text/html", "text/plain"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			k, crt, err := smtptest.GenerateTLSFiles(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := smtptest.NewInProcessServer(k, crt)
+
+			u, err := url.Parse("smtp://" + srv.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uc := UserConfig{
+				FromAddress:          "me@example.com",
+				ToAddress:            "you@example.com",
+				SMTPServerHost:       u.Hostname(),
+				SMTPServerPort:       u.Port(),
+				UserName:             "myuser",
+				Password:             "mypassword",
+				SkipCertVerification: true, // since it's a self-signed cert
+				HTMLPartFirst:        c.htmlPartFirst,
+			}
+
+			go srv.Start()
+			defer srv.Close()
+
+			if err := srv.WaitUntilReady(time.Duration(5) * time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := uc.SendNewsletter([]byte("text"), []byte("<html></html>")); err != nil {
+				t.Fatalf("unexpected error when sending the email: %v", err)
+			}
+
+			b, err := srv.RetrieveEmails(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) != 1 {
+				t.Fatalf("expected to have sent one email, but sent %v instead", len(b))
+			}
+
+			m := regexp.MustCompile(
+				"Content-Type: multipart/alternative; boundary=(\\w+)",
+			).FindStringSubmatch(b[0])
+			if len(m) == 0 {
+				t.Fatal("could not find the expected header with a boundary attribute")
+			}
+
+			s := strings.SplitAfterN(b[0], "\r\n\r\n", 2)
+			if len(s) < 2 {
+				t.Fatal("expecting a blank line after the headers, but got none")
+			}
+
+			rdr := multipart.NewReader(bytes.NewBuffer([]byte(s[1])), m[1])
+			var actual []string
+			for {
+				p, err := rdr.NextPart()
+				if err != nil {
+					break
+				}
+				actual = append(actual, p.Header.Get("Content-Type"))
+			}
+
+			if !reflect.DeepEqual(actual, c.expected) {
+				t.Fatalf("expected MIME parts in the order %v but got %v", c.expected, actual)
+			}
+		})
+	}
+}
+
+// TestSendWithoutAuth makes sure that we can send email to a server that
+// doesn't advertise AUTH if, and only if, the user allows it.
+func TestSendWithoutAuth(t *testing.T) {
+	cases := []struct {
+		description          string
+		allowUnauthenticated bool
+		expectErr            bool
+	}{
+		{
+			description:          "unauthenticated email allowed",
+			allowUnauthenticated: true,
+			expectErr:            false,
+		},
+		{
+			description:          "unauthenticated email not allowed",
+			allowUnauthenticated: false,
+			expectErr:            true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			k, crt, err := smtptest.GenerateTLSFiles(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := smtptest.NewInProcessServer(k, crt)
+			srv.DisableAuth()
+
+			u, err := url.Parse("smtp://" + srv.Address())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			uc := UserConfig{
+				FromAddress:          "me@example.com",
+				ToAddress:            "you@example.com",
+				SMTPServerHost:       u.Hostname(),
+				SMTPServerPort:       u.Port(),
+				SkipCertVerification: true, // since it's a self-signed cert
+				AllowUnauthenticated: c.allowUnauthenticated,
+			}
+
+			go srv.Start()
+			defer srv.Close()
+
+			if err := srv.WaitUntilReady(time.Duration(5) * time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			err = uc.SendNewsletter([]byte("text"), []byte("<html></html>"))
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error status %v but got %v", c.expectErr, err)
+			}
+
+			b, err := srv.RetrieveEmails(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.expectErr && len(b) != 1 {
+				t.Fatalf("expected to have sent one email, but sent %v instead", len(b))
+			}
+		})
+	}
+}
+
+// TestSendDialTimeout makes sure that we give up on an SMTP server that
+// doesn't respond instead of blocking.
+func TestSendDialTimeout(t *testing.T) {
+	// The listener never accepts connections, so the client never receives
+	// a greeting from the server.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	h, p, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := UserConfig{
+		FromAddress:    "me@example.com",
+		ToAddress:      "you@example.com",
+		SMTPServerHost: h,
+		SMTPServerPort: p,
+		UserName:       "myuser",
+		Password:       "mypassword",
+		DialTimeout:    time.Duration(100) * time.Millisecond,
+	}
+
+	start := time.Now()
+	err = uc.SendNewsletter([]byte("text"), []byte("<html></html>"))
+	if err == nil {
+		t.Fatal("expected an error connecting to the SMTP server but got nil")
+	}
+	if elapsed := time.Since(start); elapsed > time.Duration(5)*time.Second {
+		t.Fatalf("expected to give up on the SMTP server quickly but took %v", elapsed)
+	}
+}
+
+func TestSendNewsletterPart(t *testing.T) {
+	k, c, err := smtptest.GenerateTLSFiles(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := smtptest.NewInProcessServer(k, c)
+
+	u, err := url.Parse("smtp://" + srv.Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uc := UserConfig{
+		FromAddress:          "me@example.com",
+		ToAddress:            "you@example.com",
+		SMTPServerHost:       u.Hostname(),
+		SMTPServerPort:       u.Port(),
+		UserName:             "myuser",
+		Password:             "mypassword",
+		SkipCertVerification: true, // since it's a self-signed cert
+	}
+
+	go srv.Start()
+	defer srv.Close()
+
+	if err := srv.WaitUntilReady(time.Duration(5) * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := uc.SendNewsletterPart("", []byte("text"), []byte("<html></html>"), 2, 3); err != nil {
+		t.Fatalf("unexpected error when sending the email: %v", err)
+	}
+
+	b, err := srv.RetrieveEmails(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1 {
+		t.Fatalf("expected to have sent one email, but sent %v instead", len(b))
+	}
+	if !strings.Contains(b[0], "Subject: New links to look at (part 2 of 3)\r\n") {
+		t.Errorf("expected the subject to include the part number but got %v", b[0])
+	}
+}
+
+func TestEncodeSubject(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		expected    string
+	}{
+		{
+			description: "plain ASCII",
+			input:       "New links to look at",
+			expected:    "New links to look at",
+		},
+		{
+			description: "line breaks",
+			input:       "5 new links\r\nBcc: someone@example.com\n",
+			expected:    "5 new links Bcc: someone@example.com",
+		},
+		{
+			description: "non-ASCII characters",
+			input:       "Nouveaux liens à lire",
+			expected:    "=?utf-8?q?Nouveaux_liens_=C3=A0_lire?=",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			if s := encodeSubject(c.input); s != c.expected {
+				t.Errorf("expected %q but got %q", c.expected, s)
+			}
+		})
+	}
+}
+
 func TestCheckAndSetDefaults(t *testing.T) {
 	cases := []struct {
 		description        string
@@ -207,8 +545,206 @@ func TestCheckAndSetDefaults(t *testing.T) {
 				UserName:             "MyUser123",
 				Password:             "123456-A_BCDE",
 				SkipCertVerification: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "internationalized domain names",
+			input: UserConfig{
+				SMTPServerHost:       "smtp.münchen.example",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@bücher.example",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "smtp.xn--mnchen-3ya.example",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@xn--bcher-kva.example",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "IPv6 SMTP host",
+			input: UserConfig{
+				SMTPServerHost:       "::1",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "::1",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "SMTP host with an underscore",
+			input: UserConfig{
+				SMTPServerHost:       "smtp_relay.internal",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@mail_host.internal",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "smtp_relay.internal",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@mail_host.internal",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "internationalized SMTP host with an underscore",
+			input: UserConfig{
+				SMTPServerHost:       "smtp_relay.münchen.example",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "smtp_relay.xn--mnchen-3ya.example",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "no credentials with unauthenticated email allowed",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				AllowUnauthenticated: true,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				AllowUnauthenticated: true,
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "address without a domain",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+			},
+			expectErrSubstring: "domain",
+			expected:           UserConfig{},
+		},
+		{
+			description: "test recipient",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				TestToAddress:        "me@bücher.example",
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "me@xn--bcher-kva.example",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				TestToAddress:        "me@bücher.example",
+				DialTimeout:          defaultDialTimeout,
+			},
+		},
+		{
+			description: "test recipient without a domain",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				TestToAddress:        "me",
+			},
+			expectErrSubstring: "test",
+			expected:           UserConfig{},
+		},
+		{
+			description: "custom dial timeout",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          time.Second,
+			},
+			expected: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          time.Second,
 			},
 		},
+		{
+			description: "negative dial timeout",
+			input: UserConfig{
+				SMTPServerHost:       "0.0.0.0",
+				SMTPServerPort:       "25",
+				FromAddress:          "mynewsletter@example.com",
+				ToAddress:            "recipient@example.com",
+				UserName:             "MyUser123",
+				Password:             "123456-A_BCDE",
+				SkipCertVerification: true,
+				DialTimeout:          -time.Second,
+			},
+			expectErrSubstring: "timeout",
+			expected:           UserConfig{},
+		},
 		{
 			description: "no port",
 			input: UserConfig{
@@ -3,4 +3,5 @@ package html
 // html is responsible for generating HTML and text bodies for inclusion in an
 // email. It's not concerned with the lower-level logic involved in sending
 // the email. As a result, the generated HTML can be used for other purposes,
-// e.g., displaying via an HTTP server (not implemented here).
+// e.g., displaying via an HTTP server (not implemented here). Other programs
+// can use RenderNewsletter to generate both bodies in one call.
@@ -1,18 +1,27 @@
 package html
 
 import (
+	"fmt"
 	"html/template"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/ptgott/one-newsletter/linksrc"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 // BodySectionContent is used to populate email body templates
 type BodySectionContent struct {
 	PubName  string
+	URL      string // The link source itself, so readers can visit the site
 	Items    []linksrc.LinkItem
-	Overview string // General statement about the links scraped for the site
+	Overview string   // General statement about the links scraped for the site
+	Priority int      // Sections with lower priorities lose link items first
+	Messages []string // Ad hoc notes about the link source, e.g., errors
 }
 
 // NewBodySectionContent readies a linksrc.Set for inclusion in an email body.
@@ -20,15 +29,28 @@ type BodySectionContent struct {
 // originally parsed, and BodySectionContent as close as possible to what
 // a reader would want to see, while decoupling the two.
 func NewBodySectionContent(s linksrc.Set) BodySectionContent {
+	return newBodySectionContent(s, true)
+}
+
+// newBodySectionContent is like NewBodySectionContent, but only includes the
+// messages of s in the overview if inlineMessages is true. Otherwise, callers
+// can display the messages separately.
+func newBodySectionContent(s linksrc.Set, inlineMessages bool) BodySectionContent {
 	li := s.LinkItems()
+	u := s.URL()
 	bsc := BodySectionContent{
-		Items:   li,
-		PubName: s.Name,
+		Items:    li,
+		PubName:  s.Name,
+		URL:      u.String(),
+		Priority: s.Priority(),
+		Messages: s.Messages(),
 	}
 
 	if len(li) == 0 {
 		bsc.Overview = "We could not find any links for this site. "
-		bsc.Overview = bsc.Overview + strings.Join(s.Messages(), " ")
+		if inlineMessages {
+			bsc.Overview = bsc.Overview + strings.Join(s.Messages(), " ")
+		}
 		return bsc
 	}
 
@@ -36,38 +58,68 @@ func NewBodySectionContent(s linksrc.Set) BodySectionContent {
 	return bsc
 }
 
-// Template meant to be populated with a []linksrc.Set
+// Template meant to be populated with an emailTemplateData.
 // Using tables for layout to avoid cross-client irregularities.
 // See here for best practices:
 // https://www.smashingmagazine.com/2017/01/introduction-building-sending-html-email-for-web-developers/#using-html-tables-for-layout
 const emailBodyHTML = `<html>
 <head>
 </head>
-<body>
-	<p>One Newsletter found the following links.</p>
-	{{ range . }}
-		<h2>{{ .PubName }}</h2>
+<body>{{ if .ImageURL }}
+	<img src="{{ .ImageURL }}" alt="">{{ end }}
+	<p>{{ .Heading }}</p>{{ range .Notices }}
+	<p><strong>{{ . }}</strong></p>{{ end }}{{ if .Intro }}
+	<p>{{ .Intro }}</p>{{ end }}
+	{{ range .Sections }}
+		<h2>{{ if .URL }}<a href="{{ .URL }}">{{ .PubName }}</a>{{ else }}{{ .PubName }}{{ end }}</h2>
 		<p>{{ .Overview }}</p>
 		<ul>
 		{{ range .Items }}
-			<li>{{ .Caption }} (<a href="{{ .LinkURL }}">here</a>)</li>
+			<li>{{ if and $.MarkNew (not .Seen) }}<strong>NEW</strong> {{ end }}{{ if .Summary }}<strong>{{ end }}{{ if .RichCaption }}{{ richCaption .RichCaption }}{{ else }}{{ .Caption }}{{ end }}{{ if .Summary }}</strong>{{ end }} (<a href="{{ .LinkURL }}">here</a>){{ if .Summary }}<br>
+			<span style="color: #666666;">{{ .Summary }}</span>{{ end }}</li>
+		{{ end }}
+		</ul>
+	{{ end }}{{ if .Diagnostics }}
+	<h2>Diagnostics</h2>
+	{{ range .Diagnostics }}
+		<h3>{{ .PubName }}</h3>
+		<ul>
+		{{ range .Messages }}
+			<li>{{ . }}</li>
 		{{ end }}
 		</ul>
-	{{ end }}
+	{{ end }}{{ end }}{{ if .Footer }}
+	<p>{{ .Footer }}</p>{{ end }}
 </body>
 </html>`
 
-// Template meant to be populated with a []linksrc.Set.
+// Template meant to be populated with an emailTemplateData.
 // Meant to satisfy the text/plain MIME type.
-const emailBodyText = `{{ range . }}
-{{.PubName}}
+const emailBodyText = `{{ .Heading }}{{ range .Notices }}
 
+{{ . }}{{ end }}{{ if .Intro }}
+
+{{ .Intro }}{{ end }}
+{{ range .Sections }}
+{{.PubName}}
+{{ if .URL }}{{.URL}}
+{{ end }}
 {{.Overview}}
 {{ range .Items }}
-- {{.Caption}}
+- {{ if and $.MarkNew (not .Seen) }}[NEW] {{ end }}{{.Caption}}{{ if .Summary }}
+  {{.Summary}}{{ end }}
   {{.LinkURL}}
 
 {{ end }}
+{{ end }}{{ if .Diagnostics }}
+Diagnostics
+{{ range .Diagnostics }}
+{{.PubName}}
+{{ range .Messages }}
+- {{.}}
+{{ end }}
+{{ end }}{{ end }}{{ if .Footer }}
+{{ .Footer }}
 {{ end }}
 `
 
@@ -78,6 +130,42 @@ const emailBodyText = `{{ range . }}
 type EmailData struct {
 	content []BodySectionContent
 	mtx     *sync.Mutex
+	// The line at the top of the email. If this is blank, we use
+	// defaultEmailHeading.
+	heading string
+	// Show the messages from every section in a single section at the
+	// bottom of the email, rather than within each section.
+	appendDiagnostics bool
+	// Optional text after the heading
+	intro string
+	// Optional text at the bottom of the email
+	footer string
+	// Optional URL of an image at the top of the HTML email
+	imageURL string
+	// Problems with the newsletter as a whole, e.g., with storage, to show
+	// prominently at the top of the email
+	notices []string
+	// Leave out sections without link items, unless they have messages
+	// to show within the section
+	hideEmptySections bool
+}
+
+// The line at the top of the email if the user doesn't configure one
+const defaultEmailHeading = "One Newsletter found the following links."
+
+// emailTemplateData is used to populate the email body templates
+type emailTemplateData struct {
+	Heading  string
+	Sections []BodySectionContent
+	// Sections with messages to show at the bottom of the email
+	Diagnostics []BodySectionContent
+	Intro       string
+	Footer      string
+	ImageURL    string
+	Notices     []string
+	// Mark link items we haven't seen before, since the email also
+	// includes link items we have
+	MarkNew bool
 }
 
 // NewEmailData safely creates an EmailData.
@@ -88,6 +176,47 @@ func NewEmailData() *EmailData {
 	}
 }
 
+// templateData returns the data for populating an email body template with
+// content. The caller is responsible for locking ed.
+func (ed *EmailData) templateData(content []BodySectionContent) emailTemplateData {
+	h := ed.heading
+	if h == "" {
+		h = defaultEmailHeading
+	}
+	d := emailTemplateData{
+		Heading:  h,
+		Sections: []BodySectionContent{},
+		Intro:    ed.intro,
+		Footer:   ed.footer,
+		ImageURL: ed.imageURL,
+		Notices:  ed.notices,
+	}
+	for _, s := range content {
+		// Messages in the diagnostics section don't need a section
+		// of their own
+		if ed.hideEmptySections && len(s.Items) == 0 &&
+			(len(s.Messages) == 0 || ed.appendDiagnostics) {
+			continue
+		}
+		d.Sections = append(d.Sections, s)
+	}
+	for _, s := range content {
+		for _, li := range s.Items {
+			if li.Seen {
+				d.MarkNew = true
+			}
+		}
+	}
+	if ed.appendDiagnostics {
+		for _, s := range content {
+			if len(s.Messages) > 0 {
+				d.Diagnostics = append(d.Diagnostics, s)
+			}
+		}
+	}
+	return d
+}
+
 // Add stores a new linksrc.Set in the EmailData in a
 // goroutine-safe way. Callers must use Add for adding
 // linksrc.Sets to the EmailData.
@@ -95,7 +224,93 @@ func (ed *EmailData) Add(s linksrc.Set) {
 	ed.mtx.Lock()
 	defer ed.mtx.Unlock()
 
-	ed.content = append(ed.content, NewBodySectionContent(s))
+	ed.content = append(ed.content, newBodySectionContent(s, !ed.appendDiagnostics))
+}
+
+// SortSections orders the sections of ed, one for each linksrc.Set, so that
+// a section comes before another if less returns true. Sections that less
+// considers equal keep their order. It's safe to call from multiple
+// goroutines.
+func (ed *EmailData) SortSections(less func(a, b BodySectionContent) bool) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	sort.SliceStable(ed.content, func(i, j int) bool {
+		return less(ed.content[i], ed.content[j])
+	})
+}
+
+// AddNotice adds a message about the newsletter as a whole, rather than a
+// single link source, e.g., a problem with storage, to show at the top of the
+// email. It's safe to call from multiple goroutines.
+func (ed *EmailData) AddNotice(msg string) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	ed.notices = append(ed.notices, msg)
+}
+
+// SetIntro sets the text to show after the heading, replacing any intro that
+// ed already has. A blank intro means the email doesn't have one.
+func (ed *EmailData) SetIntro(intro string) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	ed.intro = intro
+}
+
+// SetHeading sets the line at the top of the email. If heading is blank, we
+// use a default.
+func (ed *EmailData) SetHeading(heading string) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	ed.heading = heading
+}
+
+// SetAppendDiagnostics sets whether to show messages about each link source,
+// e.g., errors, in a single section at the bottom of the email instead of
+// within each link source's section. Call this before adding any
+// linksrc.Sets, since Add uses it to build each section.
+func (ed *EmailData) SetAppendDiagnostics(appendDiagnostics bool) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	ed.appendDiagnostics = appendDiagnostics
+}
+
+// SetHideEmptySections sets whether to leave out the sections of link sources
+// without any link items, e.g., so that one link source with news doesn't get
+// lost among many quiet ones. We still show the messages of an empty section,
+// either in the section or, if ed appends diagnostics, at the bottom of the
+// email.
+func (ed *EmailData) SetHideEmptySections(hide bool) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	ed.hideEmptySections = hide
+}
+
+// Sections returns a copy of the sections of ed, one for each linksrc.Set, in
+// the order they appear in the email. It's safe to call from multiple
+// goroutines.
+func (ed *EmailData) Sections() []BodySectionContent {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	return slices.Clone(ed.content)
+}
+
+// CountLinkItems returns the number of link items in ed across all sections
+func (ed *EmailData) CountLinkItems() int {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	var n int
+	for _, s := range ed.content {
+		n += len(s.Items)
+	}
+	return n
 }
 
 // populateEmailTemplate executes a package-local template with the provided
@@ -104,14 +319,274 @@ func populateEmailTemplate(ed *EmailData, tmp string) string {
 	ed.mtx.Lock()
 	defer ed.mtx.Unlock()
 
+	return executeTemplate(ed.templateData(ed.content), tmp)
+}
+
+// executeTemplate populates the package-local template tmp with d.
+func executeTemplate(d emailTemplateData, tmp string) string {
 	var str strings.Builder
 	// The template text is constant, so suppressing the error
-	tmpl, _ := template.New("body").Parse(tmp)
-	tmpl.Execute(&str, ed.content)
+	tmpl, _ := template.New("body").Funcs(template.FuncMap{
+		"richCaption": richCaption,
+	}).Parse(tmp)
+	tmpl.Execute(&str, d)
 
 	return str.String()
 }
 
+// richCaptionTags are the only tags we keep in rich captions
+var richCaptionTags = map[string]struct{}{
+	"em":     {},
+	"strong": {},
+}
+
+// richCaption sanitizes the rich caption of a link item so we can include it
+// in the HTML body. We keep text and the tags in richCaptionTags, without
+// attributes, and drop everything else, including the content of script and
+// style elements.
+func richCaption(c string) template.HTML {
+	ns, err := nethtml.ParseFragment(strings.NewReader(c), &nethtml.Node{
+		Type:     nethtml.ElementNode,
+		Data:     "li",
+		DataAtom: atom.Li,
+	})
+	if err != nil {
+		return template.HTML(template.HTMLEscapeString(c))
+	}
+	var b strings.Builder
+	for _, n := range ns {
+		writeSanitized(&b, n)
+	}
+	return template.HTML(b.String())
+}
+
+// writeSanitized writes n and its children to b, keeping only text and the
+// tags in richCaptionTags
+func writeSanitized(b *strings.Builder, n *nethtml.Node) {
+	switch {
+	case n.Type == nethtml.TextNode:
+		b.WriteString(nethtml.EscapeString(n.Data))
+		return
+	case n.Type != nethtml.ElementNode,
+		n.DataAtom == atom.Script,
+		n.DataAtom == atom.Style:
+		return
+	}
+
+	_, keep := richCaptionTags[n.Data]
+	if keep {
+		fmt.Fprintf(b, "<%v>", n.Data)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		writeSanitized(b, c)
+	}
+	if keep {
+		fmt.Fprintf(b, "</%v>", n.Data)
+	}
+}
+
+// emailSize returns the combined size in bytes of the HTML and text email
+// bodies generated from d.
+func emailSize(d emailTemplateData) int {
+	return len(executeTemplate(d, emailBodyHTML)) +
+		len(executeTemplate(d, emailBodyText))
+}
+
+// capItems returns a copy of content where each section with a priority of at
+// most priority includes at most max link items. Sections with items left out
+// explain this in their overviews.
+func capItems(content []BodySectionContent, max, priority int) []BodySectionContent {
+	c := make([]BodySectionContent, len(content))
+	for i, s := range content {
+		c[i] = s
+		if s.Priority > priority || len(s.Items) <= max {
+			continue
+		}
+		c[i].Items = s.Items[:max]
+		c[i].Overview = s.Overview + fmt.Sprintf(
+			"We left out %v links to keep this email within the size limit. ",
+			len(s.Items)-max,
+		)
+	}
+	return c
+}
+
+// LimitItems removes link items from ed until it includes at most max link
+// items across all sections. It keeps the newest link items, i.e., the ones
+// with the latest publication times, regardless of which section they're in.
+// Link items without a publication time count as older than any others, and
+// we leave them out starting from the end of each section.
+// Sections with link items left out explain this in their overviews.
+func (ed *EmailData) LimitItems(max int) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	// The location of a link item within ed.content
+	type itemRef struct {
+		section   int
+		item      int
+		published time.Time
+	}
+
+	var refs []itemRef
+	for i, s := range ed.content {
+		for j, li := range s.Items {
+			refs = append(refs, itemRef{
+				section:   i,
+				item:      j,
+				published: li.Published,
+			})
+		}
+	}
+
+	if len(refs) <= max {
+		return
+	}
+
+	// Sort stably so undated link items keep their order
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].published.After(refs[j].published)
+	})
+
+	keep := make(map[[2]int]struct{}, max)
+	for _, r := range refs[:max] {
+		keep[[2]int{r.section, r.item}] = struct{}{}
+	}
+
+	for i, s := range ed.content {
+		var items []linksrc.LinkItem
+		for j, li := range s.Items {
+			if _, ok := keep[[2]int{i, j}]; ok {
+				items = append(items, li)
+			}
+		}
+		if n := len(s.Items) - len(items); n > 0 {
+			ed.content[i].Items = items
+			ed.content[i].Overview = s.Overview + fmt.Sprintf(
+				"We left out %v older links to keep this email within the limit of %v links. ",
+				n,
+				max,
+			)
+		}
+	}
+}
+
+// LimitSize removes link items from ed until the combined size of the HTML
+// and text email bodies is at most maxBytes. It removes link items from
+// sections with lower priorities first, and only removes link items from a
+// higher priority if leaving out every link item with a lower priority isn't
+// enough. Within a priority, it keeps as many link items as possible while
+// including the same maximum number of link items for each section, so a
+// single link source with a misconfigured selector doesn't crowd out the
+// others. Returns an error if the email is too large even without any link
+// items.
+func (ed *EmailData) LimitSize(maxBytes int) error {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	if emailSize(ed.templateData(ed.content)) <= maxBytes {
+		return nil
+	}
+
+	var priorities []int
+	for _, s := range ed.content {
+		if !slices.Contains(priorities, s.Priority) {
+			priorities = append(priorities, s.Priority)
+		}
+	}
+	slices.Sort(priorities)
+
+	// Without any sections, there are no link items to leave out
+	if len(priorities) == 0 ||
+		emailSize(ed.templateData(capItems(ed.content, 0, priorities[len(priorities)-1]))) > maxBytes {
+		return fmt.Errorf(
+			"the email is larger than the limit of %v bytes even without any links",
+			maxBytes,
+		)
+	}
+
+	for _, p := range priorities {
+		// Leave out every link item at this priority and move on to the
+		// next one if that's not enough.
+		if emailSize(ed.templateData(capItems(ed.content, 0, p))) > maxBytes {
+			ed.content = capItems(ed.content, 0, p)
+			continue
+		}
+
+		// Find the highest per-section limit that keeps the email small
+		// enough. We know that zero items fits and that the largest
+		// section doesn't.
+		var most int
+		for _, s := range ed.content {
+			if s.Priority == p && len(s.Items) > most {
+				most = len(s.Items)
+			}
+		}
+		lo, hi := 0, most-1
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			if emailSize(ed.templateData(capItems(ed.content, mid, p))) <= maxBytes {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+
+		ed.content = capItems(ed.content, lo, p)
+		return nil
+	}
+
+	return nil
+}
+
+// Split divides the sections of ed into parts, in order, so that the combined
+// size of the HTML and text email bodies of each part is at most maxBytes.
+// This way, we can send every link item in separate emails instead of leaving
+// some out. We don't divide sections between parts, so if a single section is
+// too large for an email, we leave out link items from its part as in
+// LimitSize. Only the first part includes ed's notices. Returns an error if an
+// email is larger than maxBytes even without any link items.
+func (ed *EmailData) Split(maxBytes int) ([]*EmailData, error) {
+	ed.mtx.Lock()
+	defer ed.mtx.Unlock()
+
+	part := ed.emptyCopy()
+	part.notices = ed.notices
+	parts := []*EmailData{part}
+	for _, s := range ed.content {
+		c := append(slices.Clone(part.content), s)
+		if len(part.content) == 0 || emailSize(part.templateData(c)) <= maxBytes {
+			part.content = c
+			continue
+		}
+		part = ed.emptyCopy()
+		part.content = []BodySectionContent{s}
+		parts = append(parts, part)
+	}
+
+	for _, p := range parts {
+		if err := p.LimitSize(maxBytes); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// emptyCopy returns an EmailData with the same settings as ed but no content
+// or notices. The caller is responsible for locking ed.
+func (ed *EmailData) emptyCopy() *EmailData {
+	return &EmailData{
+		content:           []BodySectionContent{},
+		mtx:               &sync.Mutex{},
+		heading:           ed.heading,
+		appendDiagnostics: ed.appendDiagnostics,
+		intro:             ed.intro,
+		footer:            ed.footer,
+		imageURL:          ed.imageURL,
+		hideEmptySections: ed.hideEmptySections,
+	}
+}
+
 // GenerateBody produces an HTML email body to send based on the unformatted
 // content. It's meant to include multiple sources of links in the same
 // email to reduce the number of emails we send. Any scraping- or parsing-
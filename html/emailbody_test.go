@@ -2,10 +2,17 @@ package html
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	css "github.com/andybalholm/cascadia"
 	"github.com/ptgott/one-newsletter/linksrc"
 )
 
@@ -25,6 +32,7 @@ func TestGenerateBody(t *testing.T) {
 		content: []BodySectionContent{
 			{
 				PubName:  "Example Site 1",
+				URL:      "https://www.example.com",
 				Overview: "Here are the latest links:",
 				Items: []linksrc.LinkItem{
 					{
@@ -43,6 +51,7 @@ func TestGenerateBody(t *testing.T) {
 			},
 			{
 				PubName:  "Example Site 2",
+				URL:      "https://www.example.org",
 				Overview: "Here are the latest links:",
 				Items: []linksrc.LinkItem{
 					{
@@ -107,6 +116,7 @@ func TestGenerateText(t *testing.T) {
 		content: []BodySectionContent{
 			{
 				PubName:  "Example Site 1",
+				URL:      "https://www.example.com",
 				Overview: "Here are the latest links:",
 				Items: []linksrc.LinkItem{
 					{
@@ -125,6 +135,7 @@ func TestGenerateText(t *testing.T) {
 			},
 			{
 				PubName:  "Example Site 2",
+				URL:      "https://www.example.org",
 				Overview: "Here are the latest links:",
 				Items: []linksrc.LinkItem{
 					{
@@ -178,3 +189,661 @@ func TestGenerateText(t *testing.T) {
 		t.Errorf("the text generated from GenerateBody does not match the golden file at %v", relativeGoldenTextFilePath)
 	}
 }
+
+// Hiding a section without link items should leave the email as if the link
+// source weren't in the config, so the output matches the golden files.
+func TestHideEmptySections(t *testing.T) {
+	ed := EmailData{
+		mtx:               &sync.Mutex{},
+		hideEmptySections: true,
+		content: []BodySectionContent{
+			{
+				PubName:  "Quiet Site",
+				URL:      "https://quiet.example.com",
+				Overview: "We could not find any links for this site. ",
+			},
+			{
+				PubName:  "Example Site 1",
+				URL:      "https://www.example.com",
+				Overview: "Here are the latest links:",
+				Items: []linksrc.LinkItem{
+					{
+						LinkURL: "www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					{
+						LinkURL: "www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					{
+						LinkURL: "www.example.com/storiesreally-true",
+						Caption: "Is this supposition really true?",
+					},
+				},
+			},
+			{
+				PubName:  "Example Site 2",
+				URL:      "https://www.example.org",
+				Overview: "Here are the latest links:",
+				Items: []linksrc.LinkItem{
+					{
+						LinkURL: "www.example.com/stories/tragedy",
+						Caption: "This was a tragedy",
+					},
+					{
+						LinkURL: "www.example.com/stories/heartfelt",
+						Caption: "This story is heartfelt",
+					},
+				},
+			},
+		},
+	}
+
+	for _, g := range []struct {
+		path string
+		body string
+	}{
+		{path: relativeGoldenHTMLFilePath, body: ed.GenerateBody()},
+		{path: relativeGoldenTextFilePath, body: ed.GenerateText()},
+	} {
+		b, err := os.ReadFile(g.path)
+		if err != nil {
+			t.Fatalf("couldn't read the golden file: %v", err)
+		}
+		if g.body != string(b) {
+			t.Errorf("expected the email without the empty section to match the golden file at %v but got %v", g.path, g.body)
+		}
+	}
+
+	// An empty section with a message still appears
+	ed.content[0].Messages = []string{"We were rate limited."}
+	if b := ed.GenerateBody(); !strings.Contains(b, "Quiet Site") {
+		t.Errorf("expected the email to include the empty section with a message but got %v", b)
+	}
+
+	// Unless the message appears in the diagnostics section instead
+	ed.appendDiagnostics = true
+	b := ed.GenerateBody()
+	i := strings.Index(b, "Diagnostics")
+	if i == -1 {
+		t.Fatalf("expected a diagnostics section but got %v", b)
+	}
+	if strings.Contains(b[:i], "Quiet Site") {
+		t.Errorf("expected the empty section to appear only in the diagnostics section but got %v", b)
+	}
+}
+
+func TestCustomHeading(t *testing.T) {
+	ed := NewEmailData()
+	ed.SetHeading("Here is your weekly reading list.")
+	ed.Add(linksrc.Set{Name: "Example Site 1"})
+
+	for _, b := range []string{ed.GenerateBody(), ed.GenerateText()} {
+		if !strings.Contains(b, "Here is your weekly reading list.") {
+			t.Errorf("expected the email to include the custom heading but got %v", b)
+		}
+		if strings.Contains(b, defaultEmailHeading) {
+			t.Errorf("expected the email not to include the default heading but got %v", b)
+		}
+	}
+}
+
+func TestMarkNew(t *testing.T) {
+	t.Run("some link items seen before", func(t *testing.T) {
+		ed := &EmailData{
+			mtx: &sync.Mutex{},
+			content: []BodySectionContent{
+				{
+					PubName: "Example Site 1",
+					Items: []linksrc.LinkItem{
+						{LinkURL: "www.example.com/a", Caption: "Old story", Seen: true},
+						{LinkURL: "www.example.com/b", Caption: "New story"},
+					},
+				},
+			},
+		}
+
+		b := ed.GenerateBody()
+		if !strings.Contains(b, "<strong>NEW</strong> New story") {
+			t.Errorf("expected the HTML body to mark the new link item but got %v", b)
+		}
+		if strings.Contains(b, "<strong>NEW</strong> Old story") {
+			t.Errorf("expected the HTML body not to mark the seen link item but got %v", b)
+		}
+
+		tx := ed.GenerateText()
+		if !strings.Contains(tx, "- [NEW] New story") {
+			t.Errorf("expected the text body to mark the new link item but got %v", tx)
+		}
+		if !strings.Contains(tx, "- Old story") {
+			t.Errorf("expected the text body not to mark the seen link item but got %v", tx)
+		}
+	})
+
+	t.Run("no link items seen before", func(t *testing.T) {
+		ed := &EmailData{
+			mtx: &sync.Mutex{},
+			content: []BodySectionContent{
+				{
+					PubName: "Example Site 1",
+					Items: []linksrc.LinkItem{
+						{LinkURL: "www.example.com/b", Caption: "New story"},
+					},
+				},
+			},
+		}
+
+		for _, b := range []string{ed.GenerateBody(), ed.GenerateText()} {
+			if strings.Contains(b, "NEW") {
+				t.Errorf("expected no link items to be marked but got %v", b)
+			}
+		}
+	})
+}
+
+func TestRichCaptions(t *testing.T) {
+	ed := &EmailData{
+		mtx: &sync.Mutex{},
+		content: []BodySectionContent{
+			{
+				PubName: "Example Site 1",
+				Items: []linksrc.LinkItem{
+					{
+						LinkURL:     "www.example.com/a",
+						Caption:     "This is a very important story.",
+						RichCaption: `This is a <strong>very</strong> important <em onclick="alert(1)">story</em>.<script>alert(1)</script>`,
+					},
+				},
+			},
+		},
+	}
+
+	b := ed.GenerateBody()
+	if !strings.Contains(b, "This is a <strong>very</strong> important <em>story</em>.") {
+		t.Errorf("expected the HTML body to include the rich caption but got %v", b)
+	}
+	for _, s := range []string{"onclick", "script"} {
+		if strings.Contains(b, s) {
+			t.Errorf("expected the HTML body not to include %q but got %v", s, b)
+		}
+	}
+
+	tx := ed.GenerateText()
+	if !strings.Contains(tx, "- This is a very important story.") {
+		t.Errorf("expected the text body to include the plain caption but got %v", tx)
+	}
+	if strings.Contains(tx, "strong") {
+		t.Errorf("expected the text body not to include HTML but got %v", tx)
+	}
+}
+
+func TestSummaries(t *testing.T) {
+	ed := &EmailData{
+		mtx: &sync.Mutex{},
+		content: []BodySectionContent{
+			{
+				PubName: "Example Site 1",
+				Items: []linksrc.LinkItem{
+					{
+						LinkURL: "www.example.com/a",
+						Caption: "This is a story",
+						Summary: "Here is what happened & why",
+					},
+					{
+						LinkURL: "www.example.com/b",
+						Caption: "This is another story",
+					},
+				},
+			},
+		},
+	}
+
+	b := ed.GenerateBody()
+	if !strings.Contains(b, "<strong>This is a story</strong> (<a href=\"www.example.com/a\">here</a>)<br>") {
+		t.Errorf("expected the HTML body to show the caption in bold above the summary but got %v", b)
+	}
+	if !strings.Contains(b, ">Here is what happened &amp; why</span>") {
+		t.Errorf("expected the HTML body to include the escaped summary but got %v", b)
+	}
+	if !strings.Contains(b, "<li>This is another story (<a href=\"www.example.com/b\">here</a>)</li>") {
+		t.Errorf("expected the single-caption layout for a link item without a summary but got %v", b)
+	}
+
+	tx := ed.GenerateText()
+	if !strings.Contains(tx, "- This is a story\n  Here is what happened") {
+		t.Errorf("expected the text body to include the summary under the caption but got %v", tx)
+	}
+	if !strings.Contains(tx, "- This is another story\n  www.example.com/b") {
+		t.Errorf("expected the single-caption layout for a link item without a summary but got %v", tx)
+	}
+}
+
+func TestAppendDiagnostics(t *testing.T) {
+	msgs := []string{
+		"We were rate limited. You should change your configuration to check this site less frequently.",
+		"Got a 500 error sending the scrape request—check manually to see if this is temporary.",
+		"This is a note about the third site.",
+		"This is another note about the third site.",
+	}
+	sets := []linksrc.Set{
+		{Name: "Example Site 1"},
+		{Name: "Example Site 2"},
+		{Name: "Example Site 3"},
+	}
+	sets[0].AddMessage(msgs[0])
+	sets[1].AddMessage(msgs[1])
+	sets[2].AddMessage(msgs[2])
+	sets[2].AddMessage(msgs[3])
+
+	t.Run("inline messages", func(t *testing.T) {
+		ed := NewEmailData()
+		for _, s := range sets {
+			ed.Add(s)
+		}
+		for _, b := range []string{ed.GenerateBody(), ed.GenerateText()} {
+			if strings.Contains(b, "Diagnostics") {
+				t.Errorf("expected no diagnostics section but got %v", b)
+			}
+			if !strings.Contains(b, "We could not find any links for this site. "+msgs[0]) {
+				t.Errorf("expected the section to include its message but got %v", b)
+			}
+		}
+	})
+
+	t.Run("diagnostics section", func(t *testing.T) {
+		ed := NewEmailData()
+		ed.SetAppendDiagnostics(true)
+		for _, s := range sets {
+			ed.Add(s)
+		}
+
+		for _, b := range []string{ed.GenerateBody(), ed.GenerateText()} {
+			i := strings.Index(b, "Diagnostics")
+			if i == -1 {
+				t.Fatalf("expected a diagnostics section but got %v", b)
+			}
+			body, diag := b[:i], b[i:]
+			for _, m := range msgs {
+				if strings.Contains(body, m) {
+					t.Errorf("expected the message %q to appear only in the diagnostics section but got %v", m, b)
+				}
+				if !strings.Contains(diag, m) {
+					t.Errorf("expected the diagnostics section to include %q but got %v", m, diag)
+				}
+			}
+			for _, s := range sets {
+				if !strings.Contains(diag, s.Name) {
+					t.Errorf("expected the diagnostics section to name %v but got %v", s.Name, diag)
+				}
+			}
+		}
+
+		h := "<h3>Example Site 1</h3>\n\t\t<ul>\n\t\t\n\t\t\t<li>" + msgs[0] + "</li>"
+		if b := ed.GenerateBody(); !strings.Contains(b, h) {
+			t.Errorf("expected the HTML body to include %q but got %v", h, b)
+		}
+		txt := "Example Site 3\n\n- " + msgs[2] + "\n\n- " + msgs[3] + "\n"
+		if b := ed.GenerateText(); !strings.Contains(b, txt) {
+			t.Errorf("expected the text body to include %q but got %v", txt, b)
+		}
+	})
+}
+
+func TestSectionSourceURL(t *testing.T) {
+	u, err := url.Parse("https://www.example.com/news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := linksrc.NewSet(
+		context.Background(),
+		strings.NewReader("<html><body></body></html>"),
+		linksrc.Config{
+			Name: "Example Site 1",
+			URL:  *u,
+		},
+		200,
+		"text/html",
+	)
+
+	bsc := NewBodySectionContent(s)
+	if bsc.URL != "https://www.example.com/news" {
+		t.Fatalf("expected the section to include the source URL but got %q", bsc.URL)
+	}
+
+	ed := NewEmailData()
+	ed.Add(s)
+
+	h := `<h2><a href="https://www.example.com/news">Example Site 1</a></h2>`
+	if b := ed.GenerateBody(); !strings.Contains(b, h) {
+		t.Errorf("expected the HTML body to include %v but got %v", h, b)
+	}
+	if b := ed.GenerateText(); !strings.Contains(b, "Example Site 1\nhttps://www.example.com/news\n") {
+		t.Errorf("expected the text body to include the source URL but got %v", b)
+	}
+}
+
+func TestLimitItems(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2024, time.June, d, 9, 0, 0, 0, time.UTC)
+	}
+
+	newEmailData := func() *EmailData {
+		return &EmailData{
+			mtx: &sync.Mutex{},
+			content: []BodySectionContent{
+				{
+					PubName: "Example Site 1",
+					Items: []linksrc.LinkItem{
+						{LinkURL: "www.example.com/1/a", Caption: "Story A", Published: day(1)},
+						{LinkURL: "www.example.com/1/b", Caption: "Story B", Published: day(5)},
+						{LinkURL: "www.example.com/1/c", Caption: "Story C", Published: day(3)},
+					},
+				},
+				{
+					PubName: "Example Site 2",
+					Items: []linksrc.LinkItem{
+						{LinkURL: "www.example.com/2/d", Caption: "Story D", Published: day(4)},
+						{LinkURL: "www.example.com/2/e", Caption: "Story E", Published: day(2)},
+					},
+				},
+				{
+					PubName: "Example Site 3",
+					Items: []linksrc.LinkItem{
+						{LinkURL: "www.example.com/3/f", Caption: "Undated story F"},
+					},
+				},
+			},
+		}
+	}
+
+	captions := func(ed *EmailData) [][]string {
+		var c [][]string
+		for _, s := range ed.content {
+			var sc []string
+			for _, li := range s.Items {
+				sc = append(sc, li.Caption)
+			}
+			c = append(c, sc)
+		}
+		return c
+	}
+
+	t.Run("more items than the limit", func(t *testing.T) {
+		ed := newEmailData()
+		ed.LimitItems(3)
+
+		expected := [][]string{
+			{"Story B", "Story C"},
+			{"Story D"},
+			nil,
+		}
+		if c := captions(ed); !reflect.DeepEqual(c, expected) {
+			t.Fatalf("expected the newest link items %v but got %v", expected, c)
+		}
+		for i, n := range []int{1, 1, 1} {
+			if o := ed.content[i].Overview; !strings.Contains(o, fmt.Sprintf("left out %v older links", n)) {
+				t.Errorf("expected section %v to note %v missing links but got %q", i, n, o)
+			}
+		}
+	})
+
+	t.Run("undated items are kept last", func(t *testing.T) {
+		ed := newEmailData()
+		ed.LimitItems(6)
+		if c := captions(ed); len(c[2]) != 1 {
+			t.Errorf("expected to keep the undated link item but got %v", c)
+		}
+
+		ed.LimitItems(5)
+		if c := captions(ed); len(c[2]) != 0 {
+			t.Errorf("expected to leave out the undated link item first but got %v", c)
+		}
+	})
+
+	t.Run("within the limit", func(t *testing.T) {
+		ed := newEmailData()
+		ed.LimitItems(10)
+		for i, s := range ed.content {
+			if s.Overview != "" {
+				t.Errorf("expected no note in section %v but got %q", i, s.Overview)
+			}
+		}
+	})
+}
+
+func TestSplit(t *testing.T) {
+	newSection := func(name string) BodySectionContent {
+		items := make([]linksrc.LinkItem, 20)
+		for i := range items {
+			items[i] = linksrc.LinkItem{
+				LinkURL: fmt.Sprintf("www.example.com/%v/%v", name, i),
+				Caption: "This is a story that we found on the site.",
+			}
+		}
+		return BodySectionContent{
+			PubName: name,
+			Items:   items,
+		}
+	}
+
+	newEmailData := func() *EmailData {
+		ed := NewEmailData()
+		ed.content = []BodySectionContent{
+			newSection("Example Site 1"),
+			newSection("Example Site 2"),
+		}
+		ed.AddNotice("This is a notice.")
+		return ed
+	}
+
+	// Each section fits in an email on its own, but not both together
+	ed := newEmailData()
+	max := emailSize(ed.templateData(ed.content[:1])) + 100
+
+	t.Run("email larger than the limit", func(t *testing.T) {
+		parts, err := newEmailData().Split(max)
+		if err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("expected 2 parts but got %v", len(parts))
+		}
+		for i, p := range parts {
+			if s := len(p.GenerateBody()) + len(p.GenerateText()); s > max {
+				t.Errorf("expected part %v to be at most %v bytes but got %v", i, max, s)
+			}
+			if len(p.content) != 1 || len(p.content[0].Items) != 20 {
+				t.Errorf("expected part %v to include one whole section but got %+v", i, p.content)
+			}
+		}
+		if !strings.Contains(parts[0].GenerateText(), "This is a notice.") {
+			t.Error("expected the first part to include the notice")
+		}
+		if strings.Contains(parts[1].GenerateText(), "This is a notice.") {
+			t.Error("expected only the first part to include the notice")
+		}
+	})
+
+	t.Run("email within the limit", func(t *testing.T) {
+		parts, err := newEmailData().Split(10000000)
+		if err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+		if len(parts) != 1 || len(parts[0].content) != 2 {
+			t.Fatalf("expected a single part with both sections but got %v parts", len(parts))
+		}
+	})
+
+	t.Run("limit too small for any links", func(t *testing.T) {
+		if _, err := newEmailData().Split(10); err == nil {
+			t.Fatal("expected an error but got nil")
+		}
+	})
+}
+
+func TestLimitSize(t *testing.T) {
+	// A misconfigured link source that returns far too many link items
+	items := make([]linksrc.LinkItem, 1000)
+	for i := range items {
+		items[i] = linksrc.LinkItem{
+			LinkURL: fmt.Sprintf("www.example.com/stories/%v", i),
+			Caption: "This is a story that we found on the site.",
+		}
+	}
+
+	newEmailData := func() *EmailData {
+		return &EmailData{
+			mtx: &sync.Mutex{},
+			content: []BodySectionContent{
+				{
+					PubName: "Example Site 1",
+					Items:   items,
+				},
+				{
+					PubName: "Example Site 2",
+					Items: []linksrc.LinkItem{
+						{
+							LinkURL: "www.example.com/stories/tragedy",
+							Caption: "This was a tragedy",
+						},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("oversized email", func(t *testing.T) {
+		ed := newEmailData()
+		max := 10000
+		if err := ed.LimitSize(max); err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+
+		if s := len(ed.GenerateBody()) + len(ed.GenerateText()); s > max {
+			t.Fatalf("expected an email of at most %v bytes but got %v", max, s)
+		}
+		if n := len(ed.content[0].Items); n == 0 || n == len(items) {
+			t.Fatalf("expected some but not all link items to remain, but got %v", n)
+		}
+		if !strings.Contains(ed.content[0].Overview, "left out") {
+			t.Errorf("expected a note about the removed link items but got %q", ed.content[0].Overview)
+		}
+		if len(ed.content[1].Items) != 1 {
+			t.Errorf("expected the smaller section to keep its link item")
+		}
+	})
+
+	t.Run("email within the limit", func(t *testing.T) {
+		ed := newEmailData()
+		if err := ed.LimitSize(10000000); err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+		if len(ed.content[0].Items) != len(items) {
+			t.Errorf("expected to keep all link items")
+		}
+	})
+
+	t.Run("limit too small for any links", func(t *testing.T) {
+		ed := newEmailData()
+		if err := ed.LimitSize(10); err == nil {
+			t.Fatal("expected an error but got nil")
+		}
+	})
+	t.Run("no sections", func(t *testing.T) {
+		ed := NewEmailData()
+		ed.AddNotice("This notice is longer than the limit.")
+		if err := ed.LimitSize(10); err == nil {
+			t.Fatal("expected an error from LimitSize but got nil")
+		}
+		if _, err := ed.Split(10); err == nil {
+			t.Fatal("expected an error from Split but got nil")
+		}
+	})
+
+	t.Run("higher priority section", func(t *testing.T) {
+		ed := newEmailData()
+		// Give the smaller section so many link items that both sections
+		// can't fit within the limit.
+		ed.content[1].Items = items[:100]
+		ed.content[1].Priority = 1
+		max := 20000
+		if err := ed.LimitSize(max); err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+
+		if s := len(ed.GenerateBody()) + len(ed.GenerateText()); s > max {
+			t.Fatalf("expected an email of at most %v bytes but got %v", max, s)
+		}
+		if n := len(ed.content[1].Items); n != 100 {
+			t.Errorf("expected the higher-priority section to keep all 100 link items but got %v", n)
+		}
+		if n := len(ed.content[0].Items); n == len(items) {
+			t.Errorf("expected the lower-priority section to lose link items")
+		}
+	})
+
+	t.Run("higher priority section over the limit", func(t *testing.T) {
+		ed := newEmailData()
+		ed.content[1].Items = items
+		ed.content[1].Priority = 1
+		max := 10000
+		if err := ed.LimitSize(max); err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+
+		if n := len(ed.content[0].Items); n != 0 {
+			t.Errorf("expected the lower-priority section to lose all of its link items but got %v", n)
+		}
+		if n := len(ed.content[1].Items); n == 0 || n == len(items) {
+			t.Errorf("expected the higher-priority section to keep some but not all link items, but got %v", n)
+		}
+	})
+}
+
+// LimitSize should keep the first link items in document order for a section
+// built from a link source, so the same links make it into the email each time.
+func TestLimitSizeKeepsDocumentOrder(t *testing.T) {
+	u, err := url.Parse("https://www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc strings.Builder
+	doc.WriteString("<html><body><ul>")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&doc, `<li><a href="/stories/%v">This is story number %v on the site</a></li>`, i, i)
+	}
+	doc.WriteString("</ul></body></html>")
+
+	conf := linksrc.Config{
+		Name:            "Example Site 1",
+		URL:             *u,
+		ItemSelector:    css.MustCompile("li"),
+		CaptionSelector: css.MustCompile("a"),
+		LinkSelector:    css.MustCompile("a"),
+	}
+
+	for i := 0; i < 10; i++ {
+		ed := NewEmailData()
+		ed.Add(linksrc.NewSet(
+			context.Background(),
+			strings.NewReader(doc.String()),
+			conf,
+			200,
+			"text/html",
+		))
+		if err := ed.LimitSize(10000); err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+
+		items := ed.content[0].Items
+		if len(items) == 0 || len(items) == 200 {
+			t.Fatalf("expected some but not all link items to remain, but got %v", len(items))
+		}
+		for j, li := range items {
+			if want := fmt.Sprintf("https://www.example.com/stories/%v", j); li.LinkURL != want {
+				t.Fatalf("run %v: expected link item %v to be %v but got %v", i, j, want, li.LinkURL)
+			}
+		}
+	}
+}
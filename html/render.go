@@ -0,0 +1,41 @@
+package html
+
+import "github.com/ptgott/one-newsletter/linksrc"
+
+// RenderOptions customizes the email bodies that RenderNewsletter generates.
+// The zero value is valid.
+type RenderOptions struct {
+	// The line at the top of the email. If this is blank, we use a
+	// default.
+	Heading string
+	// Text to show after the heading, e.g., a greeting. Ignored if blank.
+	Intro string
+	// Text to show at the bottom of the email, e.g., how to unsubscribe.
+	// Ignored if blank.
+	Footer string
+	// URL of an image, e.g., a logo, to show at the top of the HTML body.
+	// The text body doesn't include the image. Ignored if blank.
+	ImageURL string
+	// Show messages about each Set, e.g., errors, in a single section at the
+	// bottom of the email instead of within each Set's section.
+	AppendDiagnostics bool
+}
+
+// RenderNewsletter generates the HTML and text bodies of an email with a
+// section for each Set in sets, in order. Use this to render a newsletter
+// without the rest of One Newsletter, e.g., to build an email from link items
+// that another program collects.
+func RenderNewsletter(sets []linksrc.Set, opts RenderOptions) (htmlBody, textBody string) {
+	ed := NewEmailData()
+	ed.SetHeading(opts.Heading)
+	ed.SetAppendDiagnostics(opts.AppendDiagnostics)
+	ed.intro = opts.Intro
+	ed.footer = opts.Footer
+	ed.imageURL = opts.ImageURL
+
+	for _, s := range sets {
+		ed.Add(s)
+	}
+
+	return ed.GenerateBody(), ed.GenerateText()
+}
@@ -0,0 +1,77 @@
+package html
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ptgott/one-newsletter/linksrc"
+)
+
+func TestRenderNewsletter(t *testing.T) {
+	newSets := func() []linksrc.Set {
+		s := []linksrc.Set{
+			{Name: "Example Site 1"},
+			{Name: "Example Site 2"},
+		}
+		s[1].AddMessage("This is a note about the second site.")
+		return s
+	}
+
+	t.Run("default options", func(t *testing.T) {
+		h, txt := RenderNewsletter(newSets(), RenderOptions{})
+		for _, b := range []string{h, txt} {
+			if !strings.Contains(b, defaultEmailHeading) {
+				t.Errorf("expected the default heading but got %v", b)
+			}
+			if !strings.Contains(b, "Example Site 1") || !strings.Contains(b, "Example Site 2") {
+				t.Errorf("expected a section for each Set but got %v", b)
+			}
+			if strings.Index(b, "Example Site 1") > strings.Index(b, "Example Site 2") {
+				t.Errorf("expected the sections in the order of the Sets but got %v", b)
+			}
+			if strings.Contains(b, "Diagnostics") {
+				t.Errorf("expected no diagnostics section but got %v", b)
+			}
+		}
+		if strings.Contains(h, "<img") {
+			t.Errorf("expected no image but got %v", h)
+		}
+	})
+
+	t.Run("all options", func(t *testing.T) {
+		h, txt := RenderNewsletter(newSets(), RenderOptions{
+			Heading:           "Here is your weekly reading list.",
+			Intro:             "Happy Friday!",
+			Footer:            "Reply to this email to unsubscribe.",
+			ImageURL:          "https://www.example.com/logo.png",
+			AppendDiagnostics: true,
+		})
+
+		for _, b := range []string{h, txt} {
+			for _, want := range []string{
+				"Here is your weekly reading list.",
+				"Happy Friday!",
+				"Reply to this email to unsubscribe.",
+				"Diagnostics",
+			} {
+				if !strings.Contains(b, want) {
+					t.Errorf("expected the email to include %q but got %v", want, b)
+				}
+			}
+			if strings.Index(b, "Happy Friday!") > strings.Index(b, "Example Site 1") {
+				t.Errorf("expected the intro before the sections but got %v", b)
+			}
+			if strings.Index(b, "Reply to this email") < strings.Index(b, "Diagnostics") {
+				t.Errorf("expected the footer at the bottom of the email but got %v", b)
+			}
+		}
+
+		img := `<img src="https://www.example.com/logo.png" alt="">`
+		if !strings.Contains(h, img) {
+			t.Errorf("expected the HTML body to include %v but got %v", img, h)
+		}
+		if strings.Contains(txt, "logo.png") {
+			t.Errorf("expected the text body not to include the image but got %v", txt)
+		}
+	})
+}
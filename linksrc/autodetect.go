@@ -4,22 +4,35 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"net/url"
+	"path"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/alecthomas/units"
 	"github.com/andybalholm/cascadia"
 	"github.com/mmcdole/gofeed"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
 const maxPageSize = 1 * units.Gibibyte
 
+// The number of words we keep when truncating a caption
+const maxCaptionWords = 20
+
 // These elements are not counted when scoring html.Nodes in possible
 // captions, since they are intended to modify inline text. Other html.Nodes
 // that are children of these html.Nodes, however, such as divs and images
@@ -64,7 +77,16 @@ var punctuationRe *regexp.Regexp = regexp.MustCompile(punctuationPattern + " ?$"
 // For catching erroneous spaces before punctuation
 var spaceBeforePunctuationRe *regexp.Regexp = regexp.MustCompile(`\s+(` + punctuationPattern + ")")
 
-var wordRe *regexp.Regexp = regexp.MustCompile(`[\w-]+`)
+// Matches a word. Since Chinese and Japanese text doesn't separate words
+// with spaces, we count each ideograph or kana character as a word.
+var wordRe *regexp.Regexp = regexp.MustCompile(`[\p{Han}\p{Hiragana}\p{Katakana}]|[\w-]+`)
+
+// Patterns that match a single word for each word definition
+var wordPatterns = map[string]*regexp.Regexp{
+	WordDefinitionRegex:      wordRe,
+	WordDefinitionWhitespace: regexp.MustCompile(`\S+`),
+	WordDefinitionRunes:      regexp.MustCompile(`\S`),
+}
 
 // distanceFromRootNode returns the number of edges between html.Node n and the
 // root of the HTML document tree
@@ -222,9 +244,11 @@ type textNodeInfo struct {
 //
 // Performs the following operations when extracting text from a node:
 //
-// - Replaces divisions between block-level elements with periods.
-// - Removes block-level elements that contain fewer than m words.
-func extractTextFromNode(n *html.Node, e *html.Node, c string, m int) string {
+//   - Replaces divisions between block-level elements with j, e.g., a period
+//     and a space, unless p is false.
+//   - Removes block-level elements that contain fewer than m words, where w
+//     matches each word.
+func extractTextFromNode(n *html.Node, e *html.Node, c string, m int, w *regexp.Regexp, p bool, j string) string {
 	var o *html.Node = e
 	if o == nil {
 		o = n
@@ -273,7 +297,7 @@ func extractTextFromNode(n *html.Node, e *html.Node, c string, m int) string {
 		}
 		// Add text from the element's children
 		if b.FirstChild != nil {
-			bc = extractTextFromNode(b.FirstChild, o, bc, m)
+			bc = extractTextFromNode(b.FirstChild, o, bc, m, w, p, j)
 		}
 
 		// The node is a block-level element with text.
@@ -283,19 +307,22 @@ func extractTextFromNode(n *html.Node, e *html.Node, c string, m int) string {
 
 			// The block-level element has fewer than three words,
 			// so ignore it.
-			if len(wordRe.FindAllString(bc, -1)) <= m {
+			if len(w.FindAllString(bc, -1)) <= m {
 				goto nextElement
 			}
 
 			// The text doesn't doesn't end in punctuation (but not empty
-			// space), so add a period. We have already extracted text from
-			// all of the element's children and their siblings, so we know
-			// none of the children has provided punctuation.
-			if !punctuationRe.MatchString(bc) {
+			// space), so add the joiner, a period by default. We have
+			// already extracted text from all of the element's children
+			// and their siblings, so we know none of the children has
+			// provided punctuation. An empty joiner means adding no
+			// punctuation, so we keep the space that separates the text
+			// from the next block.
+			if p && j != "" && !punctuationRe.MatchString(bc) {
 
 				// Trim the caption segment in case we have a stray space
-				// before the period.
-				bc = strings.TrimRight(bc, " ") + ". "
+				// before the joiner.
+				bc = strings.TrimRight(bc, " ") + j
 
 			}
 		}
@@ -334,16 +361,17 @@ type captionCandidate struct {
 // and returns it as a string. Within each HTML node, it performs the following
 // operations:
 //
-//   - If the node is a block-level element with fewer than m words, ignores the
-//     node's text.
-//   - Ensures that block-level text nodes end in punctuation.
+//   - If the node is a block-level element with fewer than
+//     conf.ShortElementFilter words, ignores the node's text.
+//   - Ensures that block-level text nodes end in punctuation, or in
+//     conf.JoinBlocksWith, unless conf.CaptionAddPeriods is turned off.
 //
 // After extracting text from child nodes, extractCaptionFromContainer:
 //
-// - Truncates the caption at 20 words.
 // - Ensures that there is no space before a punctuation mark.
 // - Trims whitespace on either side of the caption.
-func extractCaptionFromContainer(n *html.Node, m int) (string, error) {
+// - Truncates the caption (see truncateCaption).
+func extractCaptionFromContainer(n *html.Node, conf Config) (string, error) {
 	if n == nil {
 		return "", errors.New("cannot extract a caption from a nonexistent container")
 	}
@@ -352,13 +380,15 @@ func extractCaptionFromContainer(n *html.Node, m int) (string, error) {
 		return "", errors.New("cannot extract a caption from an HTML body element")
 	}
 
-	c := extractTextFromNode(n, nil, "", m)
-
-	// Truncate at 20 words
-	wi := wordRe.FindAllStringIndex(c, -1)
-	if len(wi) > 20 {
-		c = strings.TrimRight(c[:wi[20][0]], " ") + "..."
-	}
+	c := extractTextFromNode(
+		n,
+		nil,
+		"",
+		conf.ShortElementFilter,
+		conf.wordPattern(),
+		conf.addCaptionPeriods(),
+		conf.blockJoiner(),
+	)
 
 	// Remove spaces before punctuation. We may have added these erroneously
 	// while appending text nodes. We need to do this here because we don't
@@ -369,8 +399,67 @@ func extractCaptionFromContainer(n *html.Node, m int) (string, error) {
 	// leading/trailing whitespace.
 	c = strings.Trim(c, " \n\t")
 
-	return c, nil
+	return truncateCaption(c, conf), nil
+
+}
+
+// truncateCaption shortens the caption c and appends conf.CaptionEllipsis (or
+// defaultCaptionEllipsis if that's blank). If conf.MaxCaptionRunes is set, we
+// keep at most that many characters, which works for scripts that don't
+// separate words with spaces, e.g., Chinese. Otherwise, we keep
+// maxCaptionWords words, as defined by conf.WordDefinition.
+func truncateCaption(c string, conf Config) string {
+	e := conf.CaptionEllipsis
+	if e == "" {
+		e = defaultCaptionEllipsis
+	}
+
+	if conf.MaxCaptionRunes > 0 {
+		r := []rune(c)
+		if len(r) > conf.MaxCaptionRunes {
+			n := conf.MaxCaptionRunes
+			// Rather than cut a word in half, end at the start of the
+			// word. We only do this for words in scripts that
+			// separate words with spaces, so text in, e.g., Chinese
+			// ends at the limit, as does a single word that's longer
+			// than the limit.
+			if inSpacedWord(r[n]) && inSpacedWord(r[n-1]) {
+				i := n - 1
+				for i > 0 && inSpacedWord(r[i-1]) {
+					i--
+				}
+				if i > 0 {
+					n = i
+				}
+			}
+			c = strings.TrimRight(string(r[:n]), " ") + e
+		}
+		return c
+	}
+
+	wi := conf.wordPattern().FindAllStringIndex(c, -1)
+	if len(wi) > maxCaptionWords {
+		c = strings.TrimRight(c[:wi[maxCaptionWords][0]], " ") + e
+	}
+	return c
+}
 
+// inSpacedWord reports whether r belongs to a word in a script that
+// separates words with spaces, e.g., a Latin letter.
+func inSpacedWord(r rune) bool {
+	if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+		return false
+	}
+	return !unicode.In(
+		r,
+		unicode.Han,
+		unicode.Hiragana,
+		unicode.Katakana,
+		unicode.Thai,
+		unicode.Lao,
+		unicode.Khmer,
+		unicode.Myanmar,
+	)
 }
 
 type pageFormat int
@@ -380,19 +469,36 @@ const (
 	formatHTML
 	formatRSS
 	formatAtom
+	formatJSON
 )
 
+// String implements fmt.Stringer
+func (p pageFormat) String() string {
+	switch p {
+	case formatHTML:
+		return "HTML"
+	case formatRSS:
+		return "RSS"
+	case formatAtom:
+		return "Atom"
+	case formatJSON:
+		return "JSON"
+	default:
+		return "unknown"
+	}
+}
+
 // Used for matching the opening tag that specifies whether a document is an
-// HTML document or an RSS/Atom feed. Assumes the line has been lowercased.
+// HTML document or an RSS/Atom feed. Assumes the text has been lowercased.
 var openingTagPattern *regexp.Regexp = regexp.MustCompile(
 	`\s*(<rss[^>]*>?|<!doctype html>|<html[^>]*>?|<feed[^>]*>?)\s*`,
 )
 
-// testFormatTag returns the pageFormat associated with a line, that is, if the
-// line indicates that the page follows a particular format (HTML, RSS, or
-// Atom).
-func testFormatTag(line string) pageFormat {
-	m := openingTagPattern.FindString(strings.ToLower(line))
+// testFormatTag returns the pageFormat associated with the first opening tag
+// in text that indicates that the page follows a particular format (HTML, RSS,
+// or Atom). The text can span multiple lines.
+func testFormatTag(text string) pageFormat {
+	m := openingTagPattern.FindString(strings.ToLower(text))
 	if m == "" {
 		return formatUnknown
 	}
@@ -408,36 +514,115 @@ func testFormatTag(line string) pageFormat {
 	return formatUnknown
 }
 
+// The amount of a page to scan for the opening tag that identifies the page's
+// format. This leaves room for XML declarations, processing instructions, and
+// comments before the tag.
+const formatDetectionSize = 4 * units.Kibibyte
+
+// A UTF-8 byte order mark, which can precede the content of a page
+var byteOrderMark = []byte("\xef\xbb\xbf")
+
+// The version URL that every JSON Feed declares, e.g.,
+// "https://jsonfeed.org/version/1.1"
+var jsonFeedVersion = []byte("jsonfeed.org/version")
+
+// detectFormat returns the format of the page that begins with prefix, using
+// the first opening tag that indicates a format. The tag does not need to be
+// on the first line. A JSON Feed counts as RSS, since the feed parser handles
+// it, while other JSON documents count as JSON.
+func detectFormat(prefix []byte) pageFormat {
+	p := bytes.TrimPrefix(prefix, byteOrderMark)
+	if len(p) > int(formatDetectionSize) {
+		p = p[:formatDetectionSize]
+	}
+
+	if t := bytes.TrimSpace(p); len(t) > 0 && (t[0] == '{' || t[0] == '[') {
+		if bytes.Contains(t, jsonFeedVersion) {
+			return formatRSS
+		}
+		return formatJSON
+	}
+
+	return testFormatTag(string(p))
+}
+
+// formatFromContentType returns the pageFormat indicated by the media type in
+// ct, the value of a Content-Type response header. Generic XML and JSON media
+// types count as feeds, since the feed parser can determine the type of feed
+// on its own.
+func formatFromContentType(ct string) pageFormat {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return formatUnknown
+	}
+
+	switch mt {
+	case "text/html", "application/xhtml+xml":
+		return formatHTML
+	case "application/atom+xml":
+		return formatAtom
+	case "application/rss+xml", "application/xml", "text/xml",
+		"application/feed+json", "application/json":
+		return formatRSS
+	default:
+		return formatUnknown
+	}
+}
+
 // autoDetectLinkItems uses the configured link selector to return a map of link
 // URLs to LinkItems. Sends status messages and LinkItems to the provided
 // channels, closing the channels when it has finished.  an email. n must be the
-// root element.
-func autoDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, messages chan string) {
-	// Copy r into two buffers. One is used for checking whether r is an HTML
-	// document or RSS/Atom feed. The other is for downstream processing once we
-	// have determined the kind of document we're dealing with.
-	var testbuf bytes.Buffer
-	var downstream bytes.Buffer
-	wr := io.MultiWriter(&testbuf, &downstream)
-	io.Copy(wr, io.LimitReader(r, int64(maxPageSize)))
-
-	lines := bufio.NewScanner(&testbuf)
-	lines.Split(bufio.ScanLines)
+// root element. If we can't detect the format of the page from its content, we
+// use contentType, the value of the response's Content-Type header.
+func autoDetectLinkItems(r io.Reader, conf Config, contentType string, links chan LinkItem, messages chan string) {
+	// Peek at the beginning of r to check whether r is an HTML document or
+	// RSS/Atom feed, then stream r into the appropriate parser. This way, we
+	// don't need to hold a copy of the whole page in memory.
+	br := bufio.NewReaderSize(
+		io.LimitReader(r, int64(maxPageSize)),
+		int(formatDetectionSize)+len(byteOrderMark),
+	)
+	// Peek returns an error if the page is shorter than the buffer, but we
+	// can still detect the format from whatever it returns.
+	prefix, _ := br.Peek(int(formatDetectionSize) + len(byteOrderMark))
 	var pf pageFormat
-	for lines.Scan() {
-		f := testFormatTag(lines.Text())
-		if f == formatUnknown {
-			continue
-		}
-		pf = f
-		break
+	if conf.Mode == ModeFeed {
+		// The feed parser detects the kind of feed on its own
+		pf = formatRSS
+	} else {
+		pf = detectFormat(prefix)
+	}
+	if pf == formatUnknown {
+		pf = formatFromContentType(contentType)
+	}
+
+	// Some feeds begin with a byte order mark, which feed parsers don't
+	// always expect.
+	if bytes.HasPrefix(prefix, byteOrderMark) {
+		br.Discard(len(byteOrderMark))
 	}
+
+	log.Debug().
+		Str("linkSource", conf.Name).
+		Str("format", pf.String()).
+		Msg("detected the format of the page")
 	switch pf {
 	case formatHTML:
-		detectHTMLLinkItems(&downstream, conf, links, messages)
+		detectHTMLLinkItems(br, conf, links, messages)
 	case formatRSS, formatAtom:
-		detectRSSLinkItems(&downstream, conf, links, messages)
+		detectRSSLinkItems(br, conf, links, messages)
+	case formatJSON:
+		detectJSONLinkItems(br, conf, links, messages)
 	default:
+		// Sniff the content in case the Content-Type header was
+		// missing or wrong, so we can tell the user about a PDF or
+		// image rather than a generic problem.
+		if ct := http.DetectContentType(prefix); !isTextContentType(ct) {
+			messages <- nonTextMessage(ct)
+			close(messages)
+			close(links)
+			return
+		}
 		messages <- "could not detect a format for the page"
 		close(messages)
 		close(links)
@@ -453,11 +638,22 @@ func detectHTMLLinkItems(r io.Reader, conf Config, links chan LinkItem, messages
 		return
 	}
 
+	if !hasContent(n) {
+		messages <- jsRenderedMessage
+		close(links)
+		close(messages)
+		return
+	}
+
 	// We're entering URL-only mode. Find all links and repeating containers
 	// around those links, even if there are multiple kinds of repeating
-	// containers.
+	// containers. Since we're following every link on the page, only allow
+	// links to the link source's own domain unless the user says otherwise.
 	if conf.LinkSelector == nil {
 		conf.LinkSelector = cascadia.MustCompile("a")
+		if len(conf.AllowedDomains) == 0 {
+			conf.AllowedDomains = []string{siteDomain(conf.URL)}
+		}
 	}
 
 	if n.Parent != nil {
@@ -484,7 +680,12 @@ func detectHTMLLinkItems(r io.Reader, conf Config, links chan LinkItem, messages
 	// - Use that hash to identify groups of links
 	// - Find the highest repeating container for each group, e.g., the HtML
 	//   node that we can use to extract a caption.
+	//
+	// We keep track of the order in which we first see each group so that
+	// we process groups in a stable order, rather than in map iteration
+	// order.
 	grp := make(map[[md5.Size]byte][]*html.Node)
+	var grpOrder [][md5.Size]byte
 	for _, nd := range m {
 		ancestors := ""
 		for c := nd; c.Parent != nil && c.Parent.DataAtom != atom.Html; c = c.Parent {
@@ -493,44 +694,380 @@ func detectHTMLLinkItems(r io.Reader, conf Config, links chan LinkItem, messages
 		h := md5.Sum([]byte(ancestors))
 		if _, ok := grp[h]; !ok {
 			grp[h] = []*html.Node{}
+			grpOrder = append(grpOrder, h)
 		}
 		grp[h] = append(grp[h], nd)
 	}
 
-	for _, g := range grp {
-		h, err := highestRepeatingContainers(g)
+	// Record the position of each link in the document so we can break ties
+	// between links in the same container and emit link items in document
+	// order.
+	pos := make(map[*html.Node]int, len(m))
+	for i, nd := range m {
+		pos[nd] = i
+	}
+
+	// A container can hold more than one link, e.g., a card with a headline
+	// link and a "comments" link. The links will often belong to different
+	// groups but share the same highest repeating container, so we choose a
+	// single primary link for each container.
+	primary := make(map[*html.Node]*html.Node)
+	var containers []*html.Node
+	var sparse int
+	for _, k := range grpOrder {
+		h, err := highestRepeatingContainers(grp[k])
 
 		if err != nil {
 			messages <- err.Error()
 		}
+		if len(h) < conf.MinContainers {
+			sparse++
+			continue
+		}
 		for _, c := range h {
-			t, err := extractCaptionFromContainer(c.container, conf.ShortElementFilter)
+			p, ok := primary[c.container]
+			if !ok {
+				containers = append(containers, c.container)
+				primary[c.container] = c.link
+				continue
+			}
+			primary[c.container] = primaryLink(p, c.link, pos)
+		}
+	}
+
+	if sparse > 0 {
+		messages <- fmt.Sprintf(
+			"We left out %v groups of links with fewer than %v repeating containers.",
+			sparse,
+			conf.MinContainers,
+		)
+	}
+
+	// Containers from different groups can be interleaved within the
+	// document, so sort them by the position of their primary links. This
+	// way, if there are more link items than the configured maximum, we keep
+	// the ones that appear first on the page.
+	sort.SliceStable(containers, func(i, j int) bool {
+		return pos[primary[containers[i]]] < pos[primary[containers[j]]]
+	})
+
+	captions := containerCaptions(containers, primary, conf)
+	var nonWeb int
+	for i, c := range containers {
+		if captions[i].err != nil {
+			messages <- captions[i].err.Error()
+			continue
+		}
+		t := captions[i].caption
+		for _, a := range primary[c].Attr {
+			if a.Key != "href" {
+				continue
+			}
+			u, err := url.Parse(a.Val)
+
 			if err != nil {
-				messages <- err.Error()
+				messages <- fmt.Sprintf("Cannot parse the link URL %v", u)
 				continue
 			}
-			for _, a := range c.link.Attr {
-				if a.Key != "href" {
-					continue
-				}
-				u, err := url.Parse(a.Val)
 
-				if err != nil {
-					messages <- fmt.Sprintf("Cannot parse the link URL %v", u)
-					continue
-				}
+			if !webLink(*u) {
+				nonWeb++
+				continue
+			}
 
-				links <- LinkItem{
-					LinkURL: getDisplayURL(conf.URL, *u),
-					Caption: t,
-				}
+			if !domainAllowed(conf, *u) {
+				continue
+			}
+
+			if conf.SameOriginOnly && !sameOrigin(conf.URL, *u) {
+				continue
+			}
+
+			links <- LinkItem{
+				LinkURL:     getDisplayURL(conf.URL, *u),
+				Caption:     t,
+				RichCaption: captions[i].richCaption,
 			}
 		}
 	}
+	if nonWeb > 0 {
+		messages <- nonWebLinksMessage(nonWeb)
+	}
 	close(links)
 	close(messages)
 }
 
+// captionResult is the caption of a link container, or the error we got
+// trying to extract it
+type captionResult struct {
+	caption string
+	// The caption as HTML, if conf.RichCaptions is set and the caption
+	// includes emphasis
+	richCaption string
+	err         error
+}
+
+// containerCaption returns the caption for the link container c with the
+// primary link l.
+func containerCaption(c, l *html.Node, conf Config) captionResult {
+	var r string
+	t, ok := attributeCaption(l, conf.CaptionAttribute)
+	if !ok {
+		var err error
+		t, err = extractCaptionFromContainer(c, conf)
+		if err != nil {
+			return captionResult{err: err}
+		}
+		if conf.RichCaptions {
+			r = richCaption(t, findEmphasis(c))
+		}
+	}
+	if t == "" && conf.CaptionFallback {
+		t = fallbackCaption(l)
+	}
+	return captionResult{caption: t, richCaption: r}
+}
+
+// containerCaptions returns the caption of each link container in
+// containers, in the same order, using primary to look up each container's
+// primary link. It extracts captions in up to captionWorkers goroutines at
+// once. This is safe because extracting a caption only reads the HTML tree.
+func containerCaptions(containers []*html.Node, primary map[*html.Node]*html.Node, conf Config) []captionResult {
+	r := make([]captionResult, len(containers))
+
+	n := captionWorkers(conf, len(containers))
+	if n <= 1 {
+		for i, c := range containers {
+			r[i] = containerCaption(c, primary[c], conf)
+		}
+		return r
+	}
+
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for w := 0; w < n; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				r[i] = containerCaption(containers[i], primary[containers[i]], conf)
+			}
+		}()
+	}
+	for i := range containers {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	return r
+}
+
+// captionWorkers returns the number of goroutines to extract captions from
+// containers link containers with. We use at most conf.CaptionWorkers, but no
+// more than there are containers or CPUs to run them on, since extra
+// goroutines would only wait.
+func captionWorkers(conf Config, containers int) int {
+	return min(conf.CaptionWorkers, containers, runtime.GOMAXPROCS(0))
+}
+
+// siteDomain returns the domain that we treat as belonging to the link source
+// at u. This is the hostname of u without a leading "www.", so that links to
+// other subdomains of the site are included.
+func siteDomain(u url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// matchesDomain indicates whether host is equal to domain d or one of its
+// subdomains.
+func matchesDomain(host, d string) bool {
+	return host == d || strings.HasSuffix(host, "."+d)
+}
+
+// webLink indicates whether the link URL u is relative or uses HTTP or HTTPS.
+// Other links, e.g., javascript: and data: URLs, are useless or unsafe in an
+// email.
+func webLink(u url.URL) bool {
+	s := strings.ToLower(u.Scheme)
+	return s == "" || s == "http" || s == "https"
+}
+
+// nonWebLinksMessage explains that we left out n links that aren't web links
+// (see webLink)
+func nonWebLinksMessage(n int) string {
+	return fmt.Sprintf("We left out %v links that don't use HTTP or HTTPS, e.g., javascript: links.", n)
+}
+
+// domainAllowed indicates whether the link URL u satisfies the allowed and
+// blocked domains in conf. Relative URLs belong to the link source's host.
+func domainAllowed(conf Config, u url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		host = strings.ToLower(conf.URL.Hostname())
+	}
+
+	for _, d := range conf.BlockedDomains {
+		if matchesDomain(host, d) {
+			return false
+		}
+	}
+
+	if len(conf.AllowedDomains) == 0 {
+		return true
+	}
+
+	for _, d := range conf.AllowedDomains {
+		if matchesDomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin indicates whether the link URL u has the same host as the link
+// source URL src. Relative URLs are resolved against src first, so they
+// always have the same host.
+func sameOrigin(src, u url.URL) bool {
+	r := src.ResolveReference(&u)
+	return strings.EqualFold(r.Host, src.Host)
+}
+
+// anchorWords returns the number of words within the text nodes below the
+// link node n.
+func anchorWords(n *html.Node) int {
+	var w int
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			w += len(wordRe.FindAllString(c.Data, -1))
+			continue
+		}
+		w += anchorWords(c)
+	}
+	return w
+}
+
+// attributeCaption returns the value of the attribute of link node n named
+// attr, with whitespace normalized, for use as a caption. It returns false if
+// attr is blank or n has no such attribute with text.
+func attributeCaption(n *html.Node, attr string) (string, bool) {
+	if attr == "" || n == nil {
+		return "", false
+	}
+	for _, a := range n.Attr {
+		if a.Key != attr {
+			continue
+		}
+		t := strings.Join(strings.Fields(a.Val), " ")
+		return t, t != ""
+	}
+	return "", false
+}
+
+// jsRenderedMessage is the message we add to a Set when a page's HTML has no
+// content, which usually means that the page builds its content with
+// JavaScript.
+const jsRenderedMessage = "This page has no content in its HTML, so it may use JavaScript to display its links. " +
+	"One Newsletter doesn't run JavaScript, so try a feed or another page from this site."
+
+// hiddenTags are elements whose content isn't shown as part of the page
+var hiddenTags = map[string]struct{}{
+	"head":     {},
+	"script":   {},
+	"style":    {},
+	"noscript": {},
+	"template": {},
+}
+
+// hasContent returns whether the document or element node n includes any
+// links or visible text, ignoring the contents of elements like scripts.
+func hasContent(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return true
+			}
+		case html.ElementNode:
+			if c.Data == "a" {
+				return true
+			}
+			if _, ok := hiddenTags[c.Data]; ok {
+				continue
+			}
+			if hasContent(c) {
+				return true
+			}
+		case html.DocumentNode:
+			if hasContent(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fallbackCaption returns a caption for the link node n for when its
+// container has no text, e.g., because the link is an image. We use the alt
+// text of the first image within n, then the title attribute of n, then the
+// last segment of the link URL's path.
+func fallbackCaption(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+
+	if img := cascadia.Query(n, imageSelector); img != nil {
+		if t, ok := attributeCaption(img, "alt"); ok {
+			return t
+		}
+	}
+
+	if t, ok := attributeCaption(n, "title"); ok {
+		return t
+	}
+
+	for _, a := range n.Attr {
+		if a.Key != "href" {
+			continue
+		}
+		u, err := url.Parse(a.Val)
+		if err != nil {
+			return ""
+		}
+		seg := path.Base(strings.TrimRight(u.Path, "/"))
+		if seg == "." || seg == "/" {
+			return ""
+		}
+		if us, err := url.PathUnescape(seg); err == nil {
+			seg = us
+		}
+		return seg
+	}
+	return ""
+}
+
+// imageSelector matches images within a link for fallbackCaption
+var imageSelector = cascadia.MustCompile("img")
+
+// primaryLink chooses which of two links within the same link container
+// represents the link item. We assume that the most prominent link in a
+// container, i.e., the one with the most words in its anchor text, is the
+// headline, and that the others are secondary links like comment counts,
+// bylines, or image links. If both links have the same number of words, we
+// choose the one that appears first in the document, according to pos.
+func primaryLink(a, b *html.Node, pos map[*html.Node]int) *html.Node {
+	wa, wb := anchorWords(a), anchorWords(b)
+	switch {
+	case wa > wb:
+		return a
+	case wb > wa:
+		return b
+	case pos[b] < pos[a]:
+		return b
+	default:
+		return a
+	}
+}
+
 var feedStartTag = regexp.MustCompile(`<(rss|feed)`)
 
 // detectRSSLinkItems sends link items to the links channel and error messages
@@ -544,19 +1081,104 @@ func detectRSSLinkItems(r io.Reader, conf Config, links chan LinkItem, messages
 		return
 	}
 
+	var nonWeb int
 	for _, item := range f.Items {
-		var c string
+		if u, err := url.Parse(item.Link); err != nil || !webLink(*u) {
+			nonWeb++
+			continue
+		}
+
+		var c, sum string
 		if item.Title != "" {
 			c = item.Title
+			sum = feedSummary(item.Description, conf)
 		} else {
 			c = item.Description
 		}
 
+		var pub time.Time
+		switch {
+		case item.PublishedParsed != nil:
+			pub = item.PublishedParsed.UTC()
+		case item.UpdatedParsed != nil:
+			pub = item.UpdatedParsed.UTC()
+		}
+
 		links <- LinkItem{
-			LinkURL: item.Link,
-			Caption: c,
+			LinkURL:   item.Link,
+			Caption:   c,
+			Summary:   sum,
+			Published: pub,
 		}
 	}
+	if nonWeb > 0 {
+		messages <- nonWebLinksMessage(nonWeb)
+	}
 	close(links)
 	close(messages)
 }
+
+// feedSummary returns the description d of a feed item as a summary.
+// Descriptions are often HTML, and sometimes the whole article, so we keep
+// only the text and truncate it like a caption.
+func feedSummary(d string, conf Config) string {
+	n, err := html.Parse(strings.NewReader(d))
+	if err != nil {
+		return ""
+	}
+	t := strings.Join(strings.Fields(nodeText(n)), " ")
+	if t == "" {
+		return ""
+	}
+	return truncateCaption(t, conf)
+}
+
+// detectJSONLinkItems sends link items to the links channel and error messages
+// to the messages channel. It assumes that r is a JSON document and uses the
+// JSONPath expressions in conf to find link items in it.
+func detectJSONLinkItems(r io.Reader, conf Config, links chan LinkItem, messages chan string) {
+	defer close(messages)
+	defer close(links)
+
+	if conf.ItemJSONPath == nil {
+		messages <- "This page is JSON, but not a JSON Feed. To extract links from it, configure itemJSONPath, captionJSONPath, and linkJSONPath."
+		return
+	}
+
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		messages <- fmt.Sprintf("cannot parse the JSON document: %v", err)
+		return
+	}
+
+	items := conf.ItemJSONPath.Eval(v)
+	if len(items) == 0 {
+		messages <- fmt.Sprintf("The item JSONPath %v did not match anything.", conf.ItemJSONPath)
+		return
+	}
+
+	var nonWeb int
+	for _, item := range items {
+		l := jsonString(conf.LinkJSONPath.Eval(item))
+		if l == "" {
+			continue
+		}
+		u, err := url.Parse(l)
+		if err != nil || !webLink(*u) {
+			nonWeb++
+			continue
+		}
+		if !domainAllowed(conf, *u) {
+			continue
+		}
+		links <- LinkItem{
+			LinkURL: getDisplayURL(conf.URL, *u),
+			Caption: jsonString(conf.CaptionJSONPath.Eval(item)),
+		}
+	}
+	if nonWeb > 0 {
+		messages <- nonWebLinksMessage(nonWeb)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"runtime"
 	"strconv"
 	"strings"
 	"testing"
@@ -345,7 +346,7 @@ func BenchmarkExtractCaptionFromContainer(b *testing.B) {
 
 			b.ResetTimer()
 			for i := 0; i < b.N; i++ {
-				_, err := extractCaptionFromContainer(&n, 3)
+				_, err := extractCaptionFromContainer(&n, Config{ShortElementFilter: 3})
 				if err != nil {
 					b.Fatal(err)
 				}
@@ -555,6 +556,10 @@ func TestExtractCaptionFromContainer(t *testing.T) {
 		expectErr        bool
 		selector         string
 		minTextNodeWords int
+		ellipsis         string
+		maxRunes         int
+		wordDefinition   string
+		noPeriods        bool
 	}{
 		{
 			description: "straightforward case",
@@ -589,6 +594,27 @@ func TestExtractCaptionFromContainer(t *testing.T) {
 			expected:  "This is a hot take! Click here.",
 			expectErr: false,
 		},
+		{
+			description:      "fragment ending in a symbol",
+			selector:         "li",
+			minTextNodeWords: 1,
+			html: `<li>
+	<div class="kicker">The latest from our newsroom</div>
+	<a href="http://www.example.com/stories/latest">Read all of our coverage »</a>
+</li>`,
+			expected: "The latest from our newsroom. Read all of our coverage ».",
+		},
+		{
+			description:      "fragment ending in a symbol without periods",
+			selector:         "li",
+			minTextNodeWords: 1,
+			noPeriods:        true,
+			html: `<li>
+	<div class="kicker">The latest from our newsroom</div>
+	<a href="http://www.example.com/stories/latest">Read all of our coverage »</a>
+</li>`,
+			expected: "The latest from our newsroom Read all of our coverage »",
+		},
 		// Based on actual HTML from "aldaily.com". Original link replaced
 		// with an example.com link.
 		{
@@ -689,6 +715,88 @@ Jordan Hirsch
 				" node. This is the end.",
 			expectErr: false,
 		},
+		{
+			description: "long caption with a custom ellipsis",
+			selector:    "div",
+			html: `<div><p>One two three four five six seven eight nine ten
+eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen nineteen
+twenty twenty-one twenty-two.</p></div>`,
+			ellipsis: "…",
+			expected: "One two three four five six seven eight nine ten " +
+				"eleven twelve thirteen fourteen fifteen sixteen seventeen " +
+				"eighteen nineteen twenty…",
+		},
+		{
+			description:      "long CJK caption",
+			selector:         "div",
+			minTextNodeWords: 3,
+			html:             `<div><p>这是一个非常长的新闻标题，我们需要在适当的位置截断它以便在电子邮件中显示。</p></div>`,
+			expected:         "这是一个非常长的新闻标题，我们需要在适当的...",
+		},
+		{
+			description:      "long CJK caption truncated by character",
+			selector:         "div",
+			minTextNodeWords: 3,
+			maxRunes:         12,
+			ellipsis:         "…",
+			html:             `<div><p>这是一个非常长的新闻标题，我们需要在适当的位置截断它以便在电子邮件中显示。</p></div>`,
+			expected:         "这是一个非常长的新闻标题…",
+		},
+		{
+			description:      "caption truncated by character at a space",
+			selector:         "div",
+			minTextNodeWords: 3,
+			maxRunes:         21,
+			html:             `<div><p>Café owners in Zürich celebrate naïve résumés</p></div>`,
+			expected:         "Café owners in Zürich...",
+		},
+		{
+			description:      "caption truncated by character within a word",
+			selector:         "div",
+			minTextNodeWords: 3,
+			maxRunes:         20,
+			html:             `<div><p>Café owners in Zürich celebrate naïve résumés</p></div>`,
+			expected:         "Café owners in...",
+		},
+		{
+			description:      "caption truncated by character within a single long word",
+			selector:         "div",
+			minTextNodeWords: 0,
+			maxRunes:         8,
+			html:             `<div><p>Donaudampfschifffahrtsgesellschaft</p></div>`,
+			expected:         "Donaudam...",
+		},
+		{
+			description:      "mixed-script caption truncated by character within a CJK word",
+			selector:         "div",
+			minTextNodeWords: 0,
+			maxRunes:         10,
+			html:             `<div><p>iPhone 新モデル発表、価格は据え置き</p></div>`,
+			expected:         "iPhone 新モデ...",
+		},
+		{
+			description:      "mixed-script caption truncated by character within a Latin word",
+			selector:         "div",
+			minTextNodeWords: 0,
+			maxRunes:         7,
+			html:             `<div><p>新モデルのiPhone発表、価格は据え置き</p></div>`,
+			expected:         "新モデルの...",
+		},
+		{
+			description:      "short punctuation-heavy element with regex words",
+			selector:         "div",
+			minTextNodeWords: 3,
+			html:             `<div><p>U.S. &mdash; up!</p><p>Markets rallied again today.</p></div>`,
+			expected:         "Markets rallied again today.",
+		},
+		{
+			description:      "short punctuation-heavy element with rune words",
+			selector:         "div",
+			minTextNodeWords: 3,
+			wordDefinition:   WordDefinitionRunes,
+			html:             `<div><p>U.S. &mdash; up!</p><p>Markets rallied again today.</p></div>`,
+			expected:         "U.S. — up! Markets ralli...",
+		},
 		{
 			description: "extracting from body in straightforward case",
 			selector:    "body",
@@ -707,7 +815,16 @@ Jordan Hirsch
 			}
 			s := cascadia.MustCompile(tc.selector)
 			n := s.MatchFirst(h)
-			c, err := extractCaptionFromContainer(n, tc.minTextNodeWords)
+			c, err := extractCaptionFromContainer(n, Config{
+				ShortElementFilter: tc.minTextNodeWords,
+				CaptionEllipsis:    tc.ellipsis,
+				MaxCaptionRunes:    tc.maxRunes,
+				WordDefinition:     tc.wordDefinition,
+				CaptionAddPeriods:  !tc.noPeriods,
+				// Leave CaptionAddPeriods as-is rather than
+				// treating false as unset.
+				captionAddPeriodsSet: true,
+			})
 
 			if (err != nil) != tc.expectErr {
 				t.Fatalf("expected error status of %v but got %v with err %v", tc.expectErr, err != nil, err)
@@ -722,6 +839,181 @@ Jordan Hirsch
 
 }
 
+func TestJoinBlocksWith(t *testing.T) {
+	doc := `<div><p>May 6:</p><p>Council approves the new budget</p></div>`
+	cases := []struct {
+		description string
+		joiner      string
+		set         bool
+		noPeriods   bool
+		expected    string
+	}{
+		{
+			description: "default",
+			expected:    "May 6:. Council approves the new budget.",
+		},
+		{
+			description: "period",
+			joiner:      ". ",
+			set:         true,
+			expected:    "May 6:. Council approves the new budget.",
+		},
+		{
+			description: "space",
+			joiner:      " ",
+			set:         true,
+			expected:    "May 6: Council approves the new budget",
+		},
+		{
+			description: "empty",
+			joiner:      "",
+			set:         true,
+			expected:    "May 6: Council approves the new budget",
+		},
+		{
+			description: "periods off",
+			joiner:      ". ",
+			set:         true,
+			noPeriods:   true,
+			expected:    "May 6: Council approves the new budget",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			h, err := html.Parse(strings.NewReader(doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := cascadia.MustCompile("div").MatchFirst(h)
+			c, err := extractCaptionFromContainer(n, Config{
+				JoinBlocksWith:       tc.joiner,
+				joinBlocksWithSet:    tc.set,
+				CaptionAddPeriods:    !tc.noPeriods,
+				captionAddPeriodsSet: true,
+			})
+			if err != nil {
+				t.Fatalf("unexpected error extracting the caption: %v", err)
+			}
+			if c != tc.expected {
+				t.Errorf("expected caption %q but got %q", tc.expected, c)
+			}
+		})
+	}
+}
+
+func TestWordDefinitions(t *testing.T) {
+	caption := "U.S. stocks fell 3.5% — again!"
+	cases := []struct {
+		definition string
+		expected   int
+	}{
+		{definition: "", expected: 7},
+		{definition: WordDefinitionRegex, expected: 7},
+		{definition: WordDefinitionWhitespace, expected: 6},
+		{definition: WordDefinitionRunes, expected: 25},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.definition, func(t *testing.T) {
+			c := Config{WordDefinition: tc.definition}
+			if n := len(c.wordPattern().FindAllString(caption, -1)); n != tc.expected {
+				t.Errorf("expected %v words but got %v", tc.expected, n)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := []struct {
+		description string
+		input       []byte
+		expected    pageFormat
+	}{
+		{
+			description: "byte order mark before an RSS feed",
+			input:       []byte("\xef\xbb\xbf<?xml version=\"1.0\"?>\n<rss version=\"2.0\">"),
+			expected:    formatRSS,
+		},
+		{
+			description: "doctype after the first line",
+			input:       []byte("\n\n<!-- comment -->\n<!DOCTYPE html>\n<html>"),
+			expected:    formatHTML,
+		},
+		{
+			description: "JSON Feed",
+			input:       []byte(`{"version": "https://jsonfeed.org/version/1.1", "title": "My Feed"}`),
+			expected:    formatRSS,
+		},
+		{
+			description: "other JSON",
+			input:       []byte("\n  [{\"title\": \"<html> in a string\"}]"),
+			expected:    formatJSON,
+		},
+		{
+			description: "tag beyond the detection prefix",
+			input:       append(bytes.Repeat([]byte(" "), int(formatDetectionSize)), []byte("<rss>")...),
+			expected:    formatUnknown,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			assert.Equal(t, c.expected, detectFormat(c.input))
+		})
+	}
+}
+
+func TestFormatFromContentType(t *testing.T) {
+	cases := []struct {
+		description string
+		input       string
+		expected    pageFormat
+	}{
+		{
+			description: "RSS with a charset",
+			input:       "application/rss+xml; charset=utf-8",
+			expected:    formatRSS,
+		},
+		{
+			description: "Atom",
+			input:       "application/atom+xml",
+			expected:    formatAtom,
+		},
+		{
+			description: "generic XML",
+			input:       "text/xml",
+			expected:    formatRSS,
+		},
+		{
+			description: "JSON",
+			input:       "application/json",
+			expected:    formatRSS,
+		},
+		{
+			description: "HTML with mixed case",
+			input:       "Text/HTML; charset=UTF-8",
+			expected:    formatHTML,
+		},
+		{
+			description: "plain text",
+			input:       "text/plain",
+			expected:    formatUnknown,
+		},
+		{
+			description: "blank",
+			input:       "",
+			expected:    formatUnknown,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			assert.Equal(t, c.expected, formatFromContentType(c.input))
+		})
+	}
+}
+
 func TestTestFormatTag(t *testing.T) {
 	cases := []struct {
 		description string
@@ -763,6 +1055,14 @@ func TestTestFormatTag(t *testing.T) {
 			input:       "<html><head>",
 			expected:    formatHTML,
 		},
+		{
+			description: "RSS after a processing instruction and comment on separate lines",
+			input: `<?xml version="1.0"?>
+<?xml-stylesheet type="text/xsl" href="/feed.xsl"?>
+<!-- comment -->
+<rss version="2.0">`,
+			expected: formatRSS,
+		},
 		{
 			description: "Relevant tag after another",
 			input:       `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"`,
@@ -777,3 +1077,173 @@ func TestTestFormatTag(t *testing.T) {
 		})
 	}
 }
+
+// countingReader returns an endless stream of the byte b and records how many
+// bytes have been read from it.
+type countingReader struct {
+	b    byte
+	read int
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = c.b
+	}
+	c.read += len(p)
+	return len(p), nil
+}
+
+// Make sure that we don't read an entire page into memory just to detect its
+// format.
+func TestAutoDetectLinkItemsReadsBoundedPrefix(t *testing.T) {
+	// A huge page of an unknown format. If we tried to read all of it
+	// before detecting the format, we would read up to maxPageSize.
+	r := &countingReader{b: 'a'}
+
+	links := make(chan LinkItem)
+	messages := make(chan string)
+	go autoDetectLinkItems(r, Config{
+		Name: "huge page",
+		URL:  mustParseURL("http://www.example.com"),
+	}, "", links, messages)
+
+	var msgs []string
+	for m := range messages {
+		msgs = append(msgs, m)
+	}
+	for range links {
+	}
+
+	assert.Equal(t, []string{"could not detect a format for the page"}, msgs)
+	if r.read > int(formatDetectionSize)+len(byteOrderMark) {
+		t.Fatalf(
+			"expected to read at most %v bytes to detect the format but read %v",
+			int(formatDetectionSize)+len(byteOrderMark),
+			r.read,
+		)
+	}
+}
+
+// largeLinkPage returns an HTML page with n link items, each with a caption of
+// several paragraphs, for testing how we handle very large pages.
+func largeLinkPage(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("<!doctype html>\n<html>\n<body>\n<h1>This is my cool website</h1>\n<ul>\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "<li>\n<a href=\"/stories/%v\">This is story number %v on the page</a>\n", i, i)
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(
+				&b,
+				"<p>Paragraph %v of the summary for story %v, with a few more words to count.</p>\n",
+				j,
+				i,
+			)
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n</body>\n</html>\n")
+	return b.Bytes()
+}
+
+// collectLinkItems runs detectHTMLLinkItems on the page p and returns the link
+// items and messages it sends.
+func collectLinkItems(p []byte, conf Config) ([]LinkItem, []string) {
+	links := make(chan LinkItem)
+	messages := make(chan string)
+	go detectHTMLLinkItems(bytes.NewReader(p), conf, links, messages)
+
+	var li []LinkItem
+	var msgs []string
+	for links != nil || messages != nil {
+		select {
+		case l, ok := <-links:
+			if !ok {
+				links = nil
+				continue
+			}
+			li = append(li, l)
+		case m, ok := <-messages:
+			if !ok {
+				messages = nil
+				continue
+			}
+			msgs = append(msgs, m)
+		}
+	}
+	return li, msgs
+}
+
+func TestCaptionWorkers(t *testing.T) {
+	p := largeLinkPage(200)
+	conf := Config{
+		Name:               "My Cool Publication",
+		URL:                mustParseURL("http://www.example.com"),
+		ShortElementFilter: 3,
+	}
+
+	serial, serialMsgs := collectLinkItems(p, conf)
+	if len(serial) != 200 {
+		t.Fatalf("expected 200 link items but got %v", len(serial))
+	}
+
+	conf.CaptionWorkers = 4
+	parallel, parallelMsgs := collectLinkItems(p, conf)
+
+	// Link items must arrive in document order either way
+	assert.Equal(t, serial, parallel)
+	assert.Equal(t, serialMsgs, parallelMsgs)
+}
+
+func TestCaptionWorkerLimit(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	cases := []struct {
+		description string
+		workers     int
+		containers  int
+		expected    int
+	}{
+		{
+			description: "fewer containers than workers",
+			workers:     procs + 10,
+			containers:  1,
+			expected:    1,
+		},
+		{
+			description: "more workers than CPUs",
+			workers:     procs + 10,
+			containers:  procs + 1000,
+			expected:    procs,
+		},
+		{
+			description: "unset",
+			workers:     0,
+			containers:  1000,
+			expected:    0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			assert.Equal(t, c.expected, captionWorkers(Config{CaptionWorkers: c.workers}, c.containers))
+		})
+	}
+}
+
+func BenchmarkDetectHTMLLinkItems(b *testing.B) {
+	p := largeLinkPage(5000)
+
+	for _, w := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("5,000 link items, %v caption workers", w), func(b *testing.B) {
+			conf := Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+				CaptionWorkers:     w,
+			}
+			for i := 0; i < b.N; i++ {
+				collectLinkItems(p, conf)
+			}
+		})
+	}
+}
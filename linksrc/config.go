@@ -3,11 +3,16 @@ package linksrc
 import (
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
 	css "github.com/andybalholm/cascadia"
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v2"
 )
 
 const (
@@ -17,6 +22,47 @@ const (
 	// By default, we won't display one-word block element text, which looks
 	// unattractive in captions.
 	defaultMinElementWords = 3
+
+	// Appended to captions that we truncate
+	defaultCaptionEllipsis = "..."
+
+	// Separates the text of block-level elements in captions that we
+	// detect automatically
+	defaultBlockJoiner = ". "
+)
+
+// Ways to detect link items in a link source
+const (
+	// Use the item, caption, and link selectors
+	ModeManual = "manual"
+	// Use the link selector and detect captions automatically
+	ModeAutodetect = "autodetect"
+	// Detect links and captions automatically
+	ModeURLOnly = "url-only"
+	// Parse the link source as an RSS or Atom feed
+	ModeFeed = "feed"
+)
+
+// Ways to count words in text when filtering out short block-level elements
+// and truncating captions
+const (
+	// Runs of letters, digits, underscores, and hyphens, plus individual
+	// Chinese and Japanese characters. This is the default.
+	WordDefinitionRegex = "regex"
+	// Runs of characters separated by whitespace
+	WordDefinitionWhitespace = "whitespace"
+	// Individual characters other than whitespace
+	WordDefinitionRunes = "runes"
+)
+
+// Ways to decide whether we've already seen a link item
+const (
+	// Treat link items as the same if they have the same URL, even if a
+	// site edits the caption
+	DedupeByURL = "url"
+	// Treat link items as the same if they have the same URL and caption.
+	// This is the default.
+	DedupeByURLAndCaption = "url+caption"
 )
 
 // Config stores options for the link source container.
@@ -30,6 +76,13 @@ type Config struct {
 	Name string
 	// url of the site containing links
 	URL url.URL
+	// URLs of several similar pages to scrape with the same options, e.g.,
+	// sections of one site. Use Expand to turn a Config with URLs into one
+	// Config per URL. Can't be used with URL.
+	URLs []url.URL
+	// How to detect link items, e.g., ModeManual. If this is blank, we
+	// infer the mode from the selectors that are present.
+	Mode string
 	// CSS selector for a link within a list of links.
 	ItemSelector css.Selector
 	// CSS selector for a caption within a link item.
@@ -38,8 +91,64 @@ type Config struct {
 	// CSS selector for the actual link within a link item. Should be an
 	// "a" element. Relative to ItemSelector.
 	LinkSelector css.Selector
+	// CSS selector for a summary within a link item, e.g., a teaser
+	// paragraph to show under the caption. Relative to ItemSelector, so it
+	// requires the manual mode. Optional.
+	SummarySelector css.Selector
+	// When we detect captions manually, use the first element that
+	// LinkSelector matches within each link item instead of treating
+	// multiple matches as an ambiguous selector. This is useful for sites
+	// where each item contains a headline link plus secondary links.
+	FirstLinkMatch bool
+	// The name of an attribute of the link element to use as the caption,
+	// e.g., "aria-label" or "title". Use this for sites where the visible
+	// text of each link item isn't useful, e.g., "Read more". If a link
+	// doesn't have the attribute, we extract the caption as usual.
+	CaptionAttribute string
+	// When detecting link items automatically, only include a group of
+	// links if it has at least this many repeating containers, so we don't
+	// mistake a lone navigation link for a link item. If this is zero, we
+	// include groups with any number of containers.
+	MinContainers int
+	// The fewest link items we expect the link source to have. If a scrape
+	// finds fewer, e.g., because the site changed and a selector no longer
+	// matches most link items, we add a warning to the email and the logs.
+	// If this is zero, we don't check.
+	ExpectMinItems uint
+	// When detecting captions automatically, give link items with no
+	// caption text, e.g., image links, a caption from the alt text of the
+	// link's image, the link's title attribute, or the end of the link's
+	// URL, in that order. Otherwise, we leave these link items out.
+	CaptionFallback bool
+	// When detecting captions automatically, end the text of each
+	// block-level element with a period if it doesn't already end in
+	// punctuation. Turn this off for sites whose captions are fragments or
+	// end in symbols like "»". This is true by default.
+	CaptionAddPeriods bool
+	// When detecting captions automatically and CaptionAddPeriods is on,
+	// what to end the text of each block-level element with instead of a
+	// period: ". ", " ", or "". An empty string adds no punctuation but keeps
+	// the space between blocks. This is ". " by default and has no effect
+	// when CaptionAddPeriods is off.
+	JoinBlocksWith string
+	// When detecting captions automatically, keep the emphasis (e.g., <em>
+	// and <strong>) of each caption for the HTML body of the email. The text
+	// body is plain either way.
+	RichCaptions bool
+	// The number of link containers to extract captions from at once when
+	// detecting captions automatically. This speeds up very large pages. If
+	// this is zero or one, we extract captions one at a time. We never use
+	// more than runtime.GOMAXPROCS(0).
+	CaptionWorkers int
+	// Either DedupeByURL or DedupeByURLAndCaption. If this is blank, we use
+	// DedupeByURLAndCaption.
+	DedupeBy string
+	// Treat a link item as seen if we've already stored its caption, even
+	// under a different URL, e.g., for sites that publish the same
+	// recurring post at a new URL every day.
+	DedupeCaptionAcrossRuns bool
 	// Maximum number of Items in a Set. If a scraper returns more than this
-	// within a link site, Items will be chosen arbitrarily.
+	// within a link site, we keep the first MaxItems Items in document order.
 	MaxItems uint
 	// The minimum number of words that a block-level HTML element must
 	// contain for it to be included in a link item's caption. Used to
@@ -48,6 +157,152 @@ type Config struct {
 	//
 	// Must be greater than zero. The default is three.
 	ShortElementFilter int
+	// Domains that link items must belong to when we detect captions
+	// automatically. A domain also matches its subdomains. If this is empty
+	// and there is no link selector, we only allow links within the link
+	// source's own domain.
+	AllowedDomains []string
+	// Domains to exclude from link items when we detect captions
+	// automatically, e.g., ad networks or social media sites. A domain also
+	// matches its subdomains.
+	BlockedDomains []string
+	// Only include links with the same host as URL when we detect captions
+	// automatically. This is stricter than AllowedDomains, since it
+	// excludes other subdomains of the link source's site.
+	SameOriginOnly bool
+	// Text to remove from each caption after extraction, e.g., a section
+	// name that prefixes every headline or a site name that suffixes it.
+	// Whitespace left at either end of the caption is trimmed.
+	CaptionStripPattern *regexp.Regexp
+	// How to count words for ShortElementFilter and caption truncation.
+	// One of WordDefinitionRegex, WordDefinitionWhitespace, or
+	// WordDefinitionRunes. If this is blank, we use WordDefinitionRegex.
+	WordDefinition string
+	// Text to append to a caption that we truncate when we detect captions
+	// automatically, e.g., "…". If this is blank, we use
+	// defaultCaptionEllipsis.
+	CaptionEllipsis string
+	// If this is greater than zero, truncate automatically detected
+	// captions to at most this many characters instead of truncating them
+	// by word. We end at the start of a word in a script that separates
+	// words with spaces, so we don't cut these words in half.
+	// Use this for scripts that don't separate words with spaces.
+	MaxCaptionRunes int
+	// Value of the Accept header to send when requesting the link source,
+	// e.g., "application/rss+xml" for sites that can return either a feed or
+	// an HTML page. If this is blank, we don't send an Accept header.
+	Accept string
+	// Value of the Accept-Language header to send when requesting the link
+	// source, e.g., "en" for a multilingual site that picks a language
+	// based on the request. If this is blank, we don't send an
+	// Accept-Language header.
+	AcceptLanguage string
+	// The ISO 639-1 code of the language that captions must be in, e.g.,
+	// "en". We detect the language of each caption and leave out link
+	// items in other languages. Captions that are too short to detect a
+	// language from are kept. If this is blank, we don't filter by
+	// language.
+	Language string
+	// Value of the Cookie header to send with the first request to the link
+	// source, e.g., a session cookie for a site behind a soft paywall.
+	// Cookies that the site sets in response carry over to any requests
+	// that follow it, e.g., redirects. If this is blank, we don't send a
+	// Cookie header.
+	Cookie string
+	// Don't verify the TLS certificate of the link source, e.g., for a
+	// development server with a self-signed certificate. This makes
+	// requests to the link source vulnerable to interception, so only use
+	// it in development. To trust a private CA, use the caCertFile scraping
+	// option instead.
+	InsecureSkipVerify bool
+	// The HTTP method to use when requesting the link source, e.g., "POST"
+	// for a search API. If this is blank, we use GET.
+	Method string
+	// The body of the request to the link source, e.g., a JSON query. Only
+	// allowed with methods other than GET.
+	Body string
+	// JSONPath expression for the link items in a JSON response from the
+	// link source, e.g., "$.data.articles[*]". Use this for APIs that return
+	// JSON other than a JSON Feed.
+	ItemJSONPath *JSONPath
+	// JSONPath expression for the caption within each link item that
+	// ItemJSONPath matches, e.g., "$.title". Required with ItemJSONPath.
+	CaptionJSONPath *JSONPath
+	// JSONPath expression for the link URL within each link item that
+	// ItemJSONPath matches, e.g., "$.url". Required with ItemJSONPath.
+	LinkJSONPath *JSONPath
+	// When an email is too large and we need to leave out link items, we
+	// leave them out of link sources with lower priorities first. Link
+	// sources have equal priorities by default.
+	Priority int
+	// HTTP status codes to treat as successful responses, in addition to
+	// 2xx codes. This comes from the scraping config.
+	SuccessCodes []int
+	// Messages to show for unsuccessful responses with these HTTP status
+	// codes instead of the default messages. A blank message means we
+	// don't show a message. This comes from the scraping config.
+	StatusMessages map[int]string
+
+	// Whether the user configured minElementWords. ShortElementFilter has
+	// a default even when the user leaves it out, so we need this to tell
+	// whether to inherit a default from elsewhere.
+	minElementWordsSet bool
+	// The text of ItemSelector, CaptionSelector, and LinkSelector as the
+	// user configured them, since we can't get it back from a compiled
+	// selector. These are blank if the selectors come from elsewhere.
+	itemSelectorText    string
+	captionSelectorText string
+	linkSelectorText    string
+	summarySelectorText string
+	// Whether the user configured captionAddPeriods. CaptionAddPeriods is
+	// true by default, so we need this to tell a false value from an unset
+	// one.
+	captionAddPeriodsSet bool
+	// Whether the user configured joinBlocksWith, since a blank
+	// JoinBlocksWith is different from an unset one
+	joinBlocksWithSet bool
+}
+
+// InheritDefaults returns a copy of c that uses maxItems and minElementWords
+// for the MaxItems and ShortElementFilter options if the user didn't configure
+// these for c. Zero values of maxItems and minElementWords are ignored. Call
+// this before CheckAndSetDefaults, which applies the package defaults.
+func (c *Config) InheritDefaults(maxItems uint, minElementWords int) Config {
+	nc := *c
+
+	if c.MaxItems == 0 && maxItems > 0 {
+		nc.MaxItems = maxItems
+	}
+
+	if !c.minElementWordsSet && minElementWords > 0 {
+		nc.ShortElementFilter = minElementWords
+	}
+
+	return nc
+}
+
+// Expand returns one Config for each of c.URLs, with the rest of the options
+// copied from c. We name each Config after c plus the path of its URL, or
+// the host if the path is blank, e.g., "Reddit: r/golang". If c doesn't have
+// URLs, Expand returns c by itself.
+func (c *Config) Expand() []Config {
+	if len(c.URLs) == 0 {
+		return []Config{*c}
+	}
+
+	cs := make([]Config, len(c.URLs))
+	for i, u := range c.URLs {
+		nc := *c
+		nc.URL = u
+		nc.URLs = nil
+		sfx := strings.Trim(u.Path, "/")
+		if sfx == "" {
+			sfx = u.Host
+		}
+		nc.Name = fmt.Sprintf("%v: %v", c.Name, sfx)
+		cs[i] = nc
+	}
+	return cs
 }
 
 // CheckAndSetDefaults validates c and either returns a copy of c with default
@@ -55,6 +310,10 @@ type Config struct {
 func (c *Config) CheckAndSetDefaults() (Config, error) {
 	nc := *c
 
+	if len(c.URLs) > 0 {
+		return Config{}, errors.New("expand a link source with several URLs before validating it")
+	}
+
 	if c.URL.String() == "" {
 		return Config{}, errors.New("the link source must include a URL")
 	}
@@ -67,6 +326,20 @@ func (c *Config) CheckAndSetDefaults() (Config, error) {
 		nc.MaxItems = defaultMaxItems
 	}
 
+	if c.InsecureSkipVerify {
+		log.Warn().
+			Str("linkSource", c.Name).
+			Msg("SKIPPING TLS CERTIFICATE VERIFICATION FOR THIS LINK SOURCE. THIS SHOULD BE A DEVELOPMENT ENVIRONMENT. YOU HAVE BEEN WARNED")
+	}
+
+	if !c.captionAddPeriodsSet {
+		nc.CaptionAddPeriods = true
+	}
+
+	if !c.joinBlocksWithSet {
+		nc.JoinBlocksWith = defaultBlockJoiner
+	}
+
 	// Check for the presence of an itemSelector, captionSelector, and
 	// linkSelector. If there's only a linkSelector, we enable caption auto-
 	// detection. If there is no link selector, we auto-detect links.
@@ -80,34 +353,132 @@ func (c *Config) CheckAndSetDefaults() (Config, error) {
 		return Config{}, errors.New("if you provide an item selector, you must provide a caption selector and vice versa")
 	}
 
+	// By this point, either all three selectors are present, there's only a
+	// link selector, or there are no selectors, so make sure an explicit
+	// mode matches.
+	switch c.Mode {
+	case "":
+	case ModeManual:
+		if c.ItemSelector == nil {
+			return Config{}, errors.New("the manual mode requires a link selector, item selector, and caption selector")
+		}
+	case ModeAutodetect:
+		if c.LinkSelector == nil || c.ItemSelector != nil {
+			return Config{}, errors.New("the autodetect mode requires a link selector and no item or caption selector")
+		}
+	case ModeURLOnly, ModeFeed:
+		if c.LinkSelector != nil {
+			return Config{}, fmt.Errorf("the %v mode does not use any selectors", c.Mode)
+		}
+	default:
+		return Config{}, fmt.Errorf(
+			"the mode must be %q, %q, %q, or %q",
+			ModeManual,
+			ModeAutodetect,
+			ModeURLOnly,
+			ModeFeed,
+		)
+	}
+
+	if c.SummarySelector != nil && c.ItemSelector == nil {
+		return Config{}, errors.New("a summary selector requires a link selector, item selector, and caption selector")
+	}
+
+	if (c.ItemJSONPath != nil || c.CaptionJSONPath != nil || c.LinkJSONPath != nil) &&
+		(c.ItemJSONPath == nil || c.CaptionJSONPath == nil || c.LinkJSONPath == nil) {
+		return Config{}, errors.New("to extract link items from JSON, you must provide an item JSONPath, caption JSONPath, and link JSONPath")
+	}
+
+	if c.Body != "" && (c.Method == "" || c.Method == http.MethodGet) {
+		return Config{}, errors.New("a request body requires a method other than GET")
+	}
+
 	return nc, nil
 }
 
+// detectionMode returns the way to detect link items in the link source. This
+// is c.Mode if the user configured it. Otherwise, we infer the mode from the
+// selectors that are present.
+func (c *Config) detectionMode() string {
+	switch {
+	case c.Mode != "":
+		return c.Mode
+	case c.ItemSelector != nil && c.CaptionSelector != nil:
+		return ModeManual
+	case c.LinkSelector != nil:
+		return ModeAutodetect
+	default:
+		return ModeURLOnly
+	}
+}
+
+// configValue is the value of a single option in a link source config. Most
+// options are scalars, but urls is a list.
+type configValue struct {
+	text string
+	list []string
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (cv *configValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&cv.text); err == nil {
+		return nil
+	}
+	return unmarshal(&cv.list)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface. Validation is
 // performed here.
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	v := make(map[string]string)
-	err := unmarshal(&v)
+	cv := make(map[string]configValue)
+	err := unmarshal(&cv)
 
 	if err != nil {
 		return fmt.Errorf("can't parse the email config: %v", err)
 	}
 
+	v := make(map[string]string)
+	for k, x := range cv {
+		if k == "urls" {
+			continue
+		}
+		if x.list != nil {
+			return fmt.Errorf("invalid %v: must be a single value", k)
+		}
+		v[k] = x.text
+	}
+
 	n, ok := v["name"]
 	if !ok {
 		n = ""
 	}
 	c.Name = n
 
-	if _, ok := v["url"]; !ok {
-		v["url"] = ""
-	}
+	if us, ok := cv["urls"]; ok {
+		if _, ok := v["url"]; ok {
+			return errors.New("a link source can include a url or urls, but not both")
+		}
+		if us.list == nil && strings.TrimSpace(us.text) != "" {
+			return errors.New("invalid urls: must be a list")
+		}
+		for _, s := range us.list {
+			u, err := parseURL(s)
+			if err != nil {
+				return fmt.Errorf("can't parse the link source URL: %v", err)
+			}
+			c.URLs = append(c.URLs, u)
+		}
+	} else {
+		if _, ok := v["url"]; !ok {
+			v["url"] = ""
+		}
 
-	u, err := parseURL(v["url"])
-	if err != nil {
-		return fmt.Errorf("can't parse the link source URL: %v", err)
+		u, err := parseURL(v["url"])
+		if err != nil {
+			return fmt.Errorf("can't parse the link source URL: %v", err)
+		}
+		c.URL = u
 	}
-	c.URL = u
 
 	var mi uint
 	if _, mok := v["maxItems"]; !mok {
@@ -124,6 +495,10 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	c.MaxItems = mi
 
+	if md, ok := v["mode"]; ok {
+		c.Mode = md
+	}
+
 	if _, ok := v["itemSelector"]; ok {
 		is, err := parseCSSSelector(v["itemSelector"])
 		if err != nil {
@@ -131,6 +506,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 		if err == nil {
 			c.ItemSelector = is
+			c.itemSelectorText = v["itemSelector"]
 		}
 	}
 
@@ -141,6 +517,7 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 		if err == nil {
 			c.CaptionSelector = cs
+			c.captionSelectorText = v["captionSelector"]
 		}
 	}
 
@@ -151,7 +528,17 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 		if err == nil {
 			c.LinkSelector = ls
+			c.linkSelectorText = v["linkSelector"]
+		}
+	}
+
+	if _, ok := v["summarySelector"]; ok {
+		ss, err := parseCSSSelector(v["summarySelector"])
+		if err != nil {
+			return fmt.Errorf("cannot parse summarySelector: %v", err)
 		}
+		c.SummarySelector = ss
+		c.summarySelectorText = v["summarySelector"]
 	}
 
 	var mt int
@@ -166,13 +553,367 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		if err != nil || mt < 0 {
 			return fmt.Errorf("invalid minElementWords: must be a positive integer")
 		}
+		c.minElementWordsSet = true
 
 	}
 	c.ShortElementFilter = mt
+
+	if _, ok := v["allowedDomains"]; ok {
+		ad, err := parseDomains(v["allowedDomains"])
+		if err != nil {
+			return fmt.Errorf("cannot parse allowedDomains: %v", err)
+		}
+		c.AllowedDomains = ad
+	}
+
+	if _, ok := v["blockedDomains"]; ok {
+		bd, err := parseDomains(v["blockedDomains"])
+		if err != nil {
+			return fmt.Errorf("cannot parse blockedDomains: %v", err)
+		}
+		c.BlockedDomains = bd
+	}
+
+	if so, ok := v["sameOriginOnly"]; ok {
+		b, err := strconv.ParseBool(so)
+		if err != nil {
+			return fmt.Errorf("invalid sameOriginOnly: must be true or false")
+		}
+		c.SameOriginOnly = b
+	}
+
+	if fm, ok := v["firstLinkMatch"]; ok {
+		b, err := strconv.ParseBool(fm)
+		if err != nil {
+			return fmt.Errorf("invalid firstLinkMatch: must be true or false")
+		}
+		c.FirstLinkMatch = b
+	}
+
+	if p, ok := v["captionStripPattern"]; ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("cannot parse captionStripPattern: %v", err)
+		}
+		c.CaptionStripPattern = re
+	}
+
+	if ca, ok := v["captionAttribute"]; ok {
+		if strings.TrimSpace(ca) == "" {
+			return errors.New("captionAttribute cannot be blank")
+		}
+		c.CaptionAttribute = ca
+	}
+
+	if cw, ok := v["captionWorkers"]; ok {
+		cwi, err := strconv.Atoi(cw)
+		if err != nil || cwi < 0 {
+			return fmt.Errorf("invalid captionWorkers: must be a positive integer")
+		}
+		c.CaptionWorkers = cwi
+	}
+
+	if cf, ok := v["captionFallback"]; ok {
+		b, err := strconv.ParseBool(cf)
+		if err != nil {
+			return fmt.Errorf("invalid captionFallback: must be true or false")
+		}
+		c.CaptionFallback = b
+	}
+
+	if ap, ok := v["captionAddPeriods"]; ok {
+		b, err := strconv.ParseBool(ap)
+		if err != nil {
+			return fmt.Errorf("invalid captionAddPeriods: must be true or false")
+		}
+		c.CaptionAddPeriods = b
+		c.captionAddPeriodsSet = true
+	}
+
+	if jb, ok := v["joinBlocksWith"]; ok {
+		if jb != defaultBlockJoiner && jb != " " && jb != "" {
+			return fmt.Errorf(
+				"invalid joinBlocksWith: must be %q, %q, or %q",
+				defaultBlockJoiner,
+				" ",
+				"",
+			)
+		}
+		c.JoinBlocksWith = jb
+		c.joinBlocksWithSet = true
+	}
+
+	if rc, ok := v["richCaptions"]; ok {
+		b, err := strconv.ParseBool(rc)
+		if err != nil {
+			return fmt.Errorf("invalid richCaptions: must be true or false")
+		}
+		c.RichCaptions = b
+	}
+
+	if db, ok := v["dedupeBy"]; ok {
+		if db != DedupeByURL && db != DedupeByURLAndCaption {
+			return fmt.Errorf(
+				"invalid dedupeBy: must be %q or %q",
+				DedupeByURL,
+				DedupeByURLAndCaption,
+			)
+		}
+		c.DedupeBy = db
+	}
+
+	if dc, ok := v["dedupeCaptionAcrossRuns"]; ok {
+		b, err := strconv.ParseBool(dc)
+		if err != nil {
+			return fmt.Errorf("invalid dedupeCaptionAcrossRuns: must be true or false")
+		}
+		c.DedupeCaptionAcrossRuns = b
+	}
+
+	if mc, ok := v["minContainers"]; ok {
+		mci, err := strconv.Atoi(mc)
+		if err != nil || mci < 0 {
+			return fmt.Errorf("invalid minContainers: must be a positive integer")
+		}
+		c.MinContainers = mci
+	}
+
+	if em, ok := v["expectMinItems"]; ok {
+		emi, err := strconv.Atoi(em)
+		if err != nil || emi < 1 {
+			return fmt.Errorf("invalid expectMinItems: must be a positive integer")
+		}
+		c.ExpectMinItems = uint(emi)
+	}
+
+	if wd, ok := v["wordDefinition"]; ok {
+		if _, ok := wordPatterns[wd]; !ok {
+			return fmt.Errorf(
+				"invalid wordDefinition: must be %q, %q, or %q",
+				WordDefinitionRegex,
+				WordDefinitionWhitespace,
+				WordDefinitionRunes,
+			)
+		}
+		c.WordDefinition = wd
+	}
+
+	if ce, ok := v["captionEllipsis"]; ok {
+		c.CaptionEllipsis = ce
+	}
+
+	if mr, ok := v["maxCaptionRunes"]; ok {
+		mri, err := strconv.Atoi(mr)
+		if err != nil || mri < 0 {
+			return fmt.Errorf("invalid maxCaptionRunes: must be a positive integer")
+		}
+		c.MaxCaptionRunes = mri
+	}
+
+	if a, ok := v["accept"]; ok {
+		if strings.TrimSpace(a) == "" {
+			return errors.New("accept cannot be blank")
+		}
+		c.Accept = a
+	}
+
+	if al, ok := v["acceptLanguage"]; ok {
+		if strings.TrimSpace(al) == "" {
+			return errors.New("acceptLanguage cannot be blank")
+		}
+		c.AcceptLanguage = al
+	}
+
+	if l, ok := v["language"]; ok {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if !isSupportedLanguage(l) {
+			return fmt.Errorf(
+				"invalid language: must be one of %v",
+				strings.Join(supportedLanguages(), ", "),
+			)
+		}
+		c.Language = l
+	}
+
+	if m, ok := v["method"]; ok {
+		m = strings.ToUpper(m)
+		switch m {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return errors.New("invalid method: must be GET, POST, PUT, or PATCH")
+		}
+		c.Method = m
+	}
+
+	if b, ok := v["body"]; ok {
+		c.Body = b
+	}
+
+	for _, jp := range []struct {
+		key  string
+		dest **JSONPath
+	}{
+		{key: "itemJSONPath", dest: &c.ItemJSONPath},
+		{key: "captionJSONPath", dest: &c.CaptionJSONPath},
+		{key: "linkJSONPath", dest: &c.LinkJSONPath},
+	} {
+		e, ok := v[jp.key]
+		if !ok {
+			continue
+		}
+		p, err := CompileJSONPath(e)
+		if err != nil {
+			return fmt.Errorf("cannot parse %v: %v", jp.key, err)
+		}
+		*jp.dest = p
+	}
+
+	if pr, ok := v["priority"]; ok {
+		pri, err := strconv.Atoi(pr)
+		if err != nil {
+			return fmt.Errorf("invalid priority: must be an integer")
+		}
+		c.Priority = pri
+	}
+
+	if ck, ok := v["cookie"]; ok {
+		if strings.TrimSpace(ck) == "" {
+			return errors.New("cookie cannot be blank")
+		}
+		c.Cookie = ck
+	}
+
+	if is, ok := v["insecureSkipVerify"]; ok {
+		b, err := strconv.ParseBool(is)
+		if err != nil {
+			return fmt.Errorf("invalid insecureSkipVerify: must be true or false")
+		}
+		c.InsecureSkipVerify = b
+	}
+
 	return nil
 
 }
 
+// MarshalYAML implements the yaml.Marshaler interface. We use the same keys as
+// UnmarshalYAML and leave out options with zero values, which mean that the
+// option is off or unset. Selectors only appear if c came from
+// UnmarshalYAML.
+func (c Config) MarshalYAML() (interface{}, error) {
+	var o yaml.MapSlice
+	add := func(key string, v interface{}) {
+		if reflect.ValueOf(v).IsZero() {
+			return
+		}
+		switch t := v.(type) {
+		case url.URL:
+			v = t.String()
+		case []url.URL:
+			us := make([]string, len(t))
+			for i, u := range t {
+				us[i] = u.String()
+			}
+			v = us
+		case []string:
+			v = strings.Join(t, ", ")
+		case fmt.Stringer:
+			v = t.String()
+		}
+		o = append(o, yaml.MapItem{Key: key, Value: v})
+	}
+
+	add("name", c.Name)
+	add("url", c.URL)
+	add("urls", c.URLs)
+	add("mode", c.Mode)
+	add("itemSelector", c.itemSelectorText)
+	add("captionSelector", c.captionSelectorText)
+	add("linkSelector", c.linkSelectorText)
+	add("summarySelector", c.summarySelectorText)
+	add("firstLinkMatch", c.FirstLinkMatch)
+	add("captionAttribute", c.CaptionAttribute)
+	add("minContainers", c.MinContainers)
+	add("expectMinItems", c.ExpectMinItems)
+	add("captionFallback", c.CaptionFallback)
+	// Unlike the other options, this is true by default, so we always
+	// include it.
+	o = append(o, yaml.MapItem{Key: "captionAddPeriods", Value: c.addCaptionPeriods()})
+	// A blank joinBlocksWith means something, so include it if the user
+	// set it.
+	if c.joinBlocksWithSet {
+		o = append(o, yaml.MapItem{Key: "joinBlocksWith", Value: c.JoinBlocksWith})
+	}
+	add("richCaptions", c.RichCaptions)
+	add("captionWorkers", c.CaptionWorkers)
+	add("dedupeBy", c.DedupeBy)
+	add("dedupeCaptionAcrossRuns", c.DedupeCaptionAcrossRuns)
+	add("maxItems", c.MaxItems)
+	add("minElementWords", c.ShortElementFilter)
+	add("allowedDomains", c.AllowedDomains)
+	add("blockedDomains", c.BlockedDomains)
+	add("sameOriginOnly", c.SameOriginOnly)
+	add("captionStripPattern", c.CaptionStripPattern)
+	add("wordDefinition", c.WordDefinition)
+	add("captionEllipsis", c.CaptionEllipsis)
+	add("maxCaptionRunes", c.MaxCaptionRunes)
+	add("accept", c.Accept)
+	add("acceptLanguage", c.AcceptLanguage)
+	add("language", c.Language)
+	add("cookie", c.Cookie)
+	add("insecureSkipVerify", c.InsecureSkipVerify)
+	add("method", c.Method)
+	add("body", c.Body)
+	add("itemJSONPath", c.ItemJSONPath)
+	add("captionJSONPath", c.CaptionJSONPath)
+	add("linkJSONPath", c.LinkJSONPath)
+	add("priority", c.Priority)
+
+	return o, nil
+}
+
+// wordPattern returns the regular expression that matches a single word
+// according to c.WordDefinition.
+func (c *Config) wordPattern() *regexp.Regexp {
+	if w, ok := wordPatterns[c.WordDefinition]; ok {
+		return w
+	}
+	return wordRe
+}
+
+// addCaptionPeriods returns whether to end block-level caption text with a
+// period. This is true unless the user turned off c.CaptionAddPeriods, even if
+// we haven't applied defaults to c.
+func (c *Config) addCaptionPeriods() bool {
+	return c.CaptionAddPeriods || !c.captionAddPeriodsSet
+}
+
+// blockJoiner returns what to end block-level caption text with, which is
+// c.JoinBlocksWith if the user configured it, even if we haven't applied
+// defaults to c.
+func (c *Config) blockJoiner() string {
+	if !c.joinBlocksWithSet {
+		return defaultBlockJoiner
+	}
+	return c.JoinBlocksWith
+}
+
+// parseDomains parses a comma-separated list of domain names, e.g.,
+// "example.com, example.org", and returns the domains in lowercase.
+func parseDomains(s string) ([]string, error) {
+	var d []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			return nil, errors.New("domain names cannot be blank")
+		}
+		if strings.ContainsAny(p, "/: ") {
+			return nil, fmt.Errorf("%v must be a domain name, not a URL", p)
+		}
+		d = append(d, p)
+	}
+	return d, nil
+}
+
 // parseURL parses a URL for the purpose of defining home pages for
 // link containers. We leave it to the caller to handle the validation errors.
 func parseURL(s string) (url.URL, error) {
@@ -2,10 +2,12 @@ package linksrc
 
 import (
 	"bytes"
+	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/andybalholm/cascadia"
+	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
 )
 
@@ -183,6 +185,1273 @@ minElementWords: 3
 	}
 }
 
+func TestUnmarshalYAMLWithDomains(t *testing.T) {
+	testCases := []struct {
+		description     string
+		config          string
+		expectedAllowed []string
+		expectedBlocked []string
+		expectErr       bool
+	}{
+		{
+			description: "no domains",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "allowed and blocked domains",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+allowedDomains: example.com, Example.org
+blockedDomains: ads.example.com
+`,
+			expectedAllowed: []string{"example.com", "example.org"},
+			expectedBlocked: []string{"ads.example.com"},
+		},
+		{
+			description: "blank domain",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+allowedDomains: example.com,,example.org
+`,
+			expectErr: true,
+		},
+		{
+			description: "URL instead of domain",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+blockedDomains: https://ads.example.com
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			if tc.expectErr {
+				return
+			}
+			assert.Equal(t, tc.expectedAllowed, c.AllowedDomains)
+			assert.Equal(t, tc.expectedBlocked, c.BlockedDomains)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithSameOriginOnly(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "true",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+sameOriginOnly: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+sameOriginOnly: sometimes
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.SameOriginOnly)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithFirstLinkMatch(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "true",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+firstLinkMatch: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+firstLinkMatch: first
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.FirstLinkMatch)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionAttribute(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "aria-label",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionAttribute: aria-label
+`,
+			expected: "aria-label",
+		},
+		{
+			description: "blank",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionAttribute: " "
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.CaptionAttribute)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionAddPeriods(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: true,
+		},
+		{
+			description: "turned off",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionAddPeriods: false
+`,
+			expected: false,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionAddPeriods: sometimes
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			err := dec.Decode(&c)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			if err != nil {
+				return
+			}
+			nc, err := c.CheckAndSetDefaults()
+			if err != nil {
+				t.Fatalf("unexpected error checking the config: %v", err)
+			}
+			assert.Equal(t, tc.expected, nc.CaptionAddPeriods)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithJoinBlocksWith(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: ". ",
+		},
+		{
+			description: "space",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+joinBlocksWith: " "
+`,
+			expected: " ",
+		},
+		{
+			description: "empty",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+joinBlocksWith: ""
+`,
+			expected: "",
+		},
+		{
+			description: "unsupported joiner",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+joinBlocksWith: " | "
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			err := dec.Decode(&c)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			if err != nil {
+				return
+			}
+			nc, err := c.CheckAndSetDefaults()
+			if err != nil {
+				t.Fatalf("unexpected error checking the config: %v", err)
+			}
+			assert.Equal(t, tc.expected, nc.JoinBlocksWith)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithExpectMinItems(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    uint
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: 0,
+		},
+		{
+			description: "positive integer",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+expectMinItems: 8
+`,
+			expected: 8,
+		},
+		{
+			description: "zero",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+expectMinItems: 0
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.ExpectMinItems)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionWorkers(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    int
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: 0,
+		},
+		{
+			description: "four workers",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionWorkers: 4
+`,
+			expected: 4,
+		},
+		{
+			description: "negative",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionWorkers: -4
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.CaptionWorkers)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionFallback(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "enabled",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionFallback: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionFallback: alt
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.CaptionFallback)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithRichCaptions(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "enabled",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+richCaptions: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+richCaptions: bold
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.RichCaptions)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithDedupeCaptionAcrossRuns(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "enabled",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+dedupeCaptionAcrossRuns: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+dedupeCaptionAcrossRuns: sometimes
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.DedupeCaptionAcrossRuns)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithInsecureSkipVerify(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: false,
+		},
+		{
+			description: "enabled",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+insecureSkipVerify: true
+`,
+			expected: true,
+		},
+		{
+			description: "not a boolean",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+insecureSkipVerify: sometimes
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.InsecureSkipVerify)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithSummarySelector(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expectSet   bool
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expectSet: false,
+		},
+		{
+			description: "valid selector",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+summarySelector: "div.teaser p"
+`,
+			expectSet: true,
+		},
+		{
+			description: "invalid selector",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+summarySelector: "div.teaser[[p"
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expectSet, c.SummarySelector != nil)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithDedupeBy(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: "",
+		},
+		{
+			description: "URL only",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+dedupeBy: url
+`,
+			expected: DedupeByURL,
+		},
+		{
+			description: "URL and caption",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+dedupeBy: url+caption
+`,
+			expected: DedupeByURLAndCaption,
+		},
+		{
+			description: "unknown",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+dedupeBy: caption
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.DedupeBy)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithMinContainers(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    int
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: 0,
+		},
+		{
+			description: "three containers",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+minContainers: 3
+`,
+			expected: 3,
+		},
+		{
+			description: "negative",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+minContainers: -1
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.MinContainers)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithWordDefinition(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "whitespace",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+wordDefinition: whitespace
+`,
+			expected: WordDefinitionWhitespace,
+		},
+		{
+			description: "unknown definition",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+wordDefinition: syllables
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.WordDefinition)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionTruncation(t *testing.T) {
+	testCases := []struct {
+		description      string
+		config           string
+		expectedEllipsis string
+		expectedRunes    int
+		expectErr        bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "ellipsis and character limit",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionEllipsis: "…"
+maxCaptionRunes: 40
+`,
+			expectedEllipsis: "…",
+			expectedRunes:    40,
+		},
+		{
+			description: "negative character limit",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+maxCaptionRunes: -1
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expectedEllipsis, c.CaptionEllipsis)
+			assert.Equal(t, tc.expectedRunes, c.MaxCaptionRunes)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCaptionStripPattern(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "valid pattern",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionStripPattern: '^Opinion \| '
+`,
+			expected: `^Opinion \| `,
+		},
+		{
+			description: "invalid pattern",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+captionStripPattern: '(Opinion'
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			if tc.expectErr {
+				return
+			}
+			if tc.expected == "" {
+				assert.Nil(t, c.CaptionStripPattern)
+				return
+			}
+			assert.Equal(t, tc.expected, c.CaptionStripPattern.String())
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithAccept(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: "",
+		},
+		{
+			description: "feed media type",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+accept: application/rss+xml
+`,
+			expected: "application/rss+xml",
+		},
+		{
+			description: "blank",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+accept: " "
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.Accept)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithCookie(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: "",
+		},
+		{
+			description: "session cookie",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+cookie: session=abc123; consent=yes
+`,
+			expected: "session=abc123; consent=yes",
+		},
+		{
+			description: "blank",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+cookie: " "
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.Cookie)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithMethod(t *testing.T) {
+	testCases := []struct {
+		description    string
+		config         string
+		expectedMethod string
+		expectedBody   string
+		expectErr      bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expectedMethod: "",
+		},
+		{
+			description: "POST with a JSON body",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+method: POST
+body: '{"query": "latest"}'
+`,
+			expectedMethod: "POST",
+			expectedBody:   `{"query": "latest"}`,
+		},
+		{
+			description: "lowercase method",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+method: put
+`,
+			expectedMethod: "PUT",
+		},
+		{
+			description: "unsupported method",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+method: DELETE
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expectedMethod, c.Method)
+			assert.Equal(t, tc.expectedBody, c.Body)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithJSONPaths(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    []string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "all three paths",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+itemJSONPath: $.data.articles[*]
+captionJSONPath: $.title
+linkJSONPath: $['url']
+`,
+			expected: []string{"$.data.articles[*]", "$.title", "$['url']"},
+		},
+		{
+			description: "invalid path",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+itemJSONPath: data.articles
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			var paths []string
+			for _, p := range []*JSONPath{c.ItemJSONPath, c.CaptionJSONPath, c.LinkJSONPath} {
+				if p != nil {
+					paths = append(paths, p.String())
+				}
+			}
+			assert.Equal(t, tc.expected, paths)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithLanguage(t *testing.T) {
+	testCases := []struct {
+		description            string
+		config                 string
+		expectedAcceptLanguage string
+		expectedLanguage       string
+		expectErr              bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+		},
+		{
+			description: "accept language and language",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+acceptLanguage: en-US,en;q=0.9
+language: EN
+`,
+			expectedAcceptLanguage: "en-US,en;q=0.9",
+			expectedLanguage:       "en",
+		},
+		{
+			description: "unsupported language",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+language: english
+`,
+			expectErr: true,
+		},
+		{
+			description: "blank accept language",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+acceptLanguage: " "
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expectedAcceptLanguage, c.AcceptLanguage)
+			assert.Equal(t, tc.expectedLanguage, c.Language)
+		})
+	}
+}
+
+func TestUnmarshalYAMLWithPriority(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    int
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: 0,
+		},
+		{
+			description: "high priority",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+priority: 10
+`,
+			expected: 10,
+		},
+		{
+			description: "negative priority",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+priority: -1
+`,
+			expected: -1,
+		},
+		{
+			description: "not an integer",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+priority: high
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			assert.Equal(t, tc.expected, c.Priority)
+		})
+	}
+}
+
 func TestValidateURL(t *testing.T) {
 
 	cases := []struct {
@@ -380,6 +1649,27 @@ func TestCheckAndSetDefaults(t *testing.T) {
 			},
 			expectErrSubstring: "name",
 		},
+		{
+			description: "summary selector with manual selectors",
+			input: Config{
+				Name:            "site-38911",
+				URL:             mustParseURL("http://127.0.0.1:38911"),
+				LinkSelector:    cascadia.MustCompile("a"),
+				ItemSelector:    cascadia.MustCompile("ul li"),
+				CaptionSelector: cascadia.MustCompile("h2"),
+				SummarySelector: cascadia.MustCompile("p"),
+			},
+		},
+		{
+			description:        "summary selector without an item selector",
+			expectErrSubstring: "summary selector",
+			input: Config{
+				Name:            "site-38911",
+				URL:             mustParseURL("http://127.0.0.1:38911"),
+				LinkSelector:    cascadia.MustCompile("a"),
+				SummarySelector: cascadia.MustCompile("p"),
+			},
+		},
 		{
 			description:        "no item selector",
 			expectErrSubstring: "item selector",
@@ -390,6 +1680,140 @@ func TestCheckAndSetDefaults(t *testing.T) {
 				CaptionSelector: cascadia.MustCompile("p"),
 			},
 		},
+		{
+			description: "explicit manual mode",
+			input: Config{
+				Name:            "site-38911",
+				URL:             mustParseURL("http://127.0.0.1:38911"),
+				Mode:            ModeManual,
+				LinkSelector:    cascadia.MustCompile("a"),
+				ItemSelector:    cascadia.MustCompile("ul li"),
+				CaptionSelector: cascadia.MustCompile("p"),
+			},
+		},
+		{
+			description:        "manual mode with only a link selector",
+			expectErrSubstring: "manual mode",
+			input: Config{
+				Name:         "site-38911",
+				URL:          mustParseURL("http://127.0.0.1:38911"),
+				Mode:         ModeManual,
+				LinkSelector: cascadia.MustCompile("a"),
+			},
+		},
+		{
+			description: "explicit autodetect mode",
+			input: Config{
+				Name:         "site-38911",
+				URL:          mustParseURL("http://127.0.0.1:38911"),
+				Mode:         ModeAutodetect,
+				LinkSelector: cascadia.MustCompile("a"),
+			},
+		},
+		{
+			description:        "autodetect mode with all selectors",
+			expectErrSubstring: "autodetect mode",
+			input: Config{
+				Name:            "site-38911",
+				URL:             mustParseURL("http://127.0.0.1:38911"),
+				Mode:            ModeAutodetect,
+				LinkSelector:    cascadia.MustCompile("a"),
+				ItemSelector:    cascadia.MustCompile("ul li"),
+				CaptionSelector: cascadia.MustCompile("p"),
+			},
+		},
+		{
+			description:        "autodetect mode with no selectors",
+			expectErrSubstring: "autodetect mode",
+			input: Config{
+				Name: "site-38911",
+				URL:  mustParseURL("http://127.0.0.1:38911"),
+				Mode: ModeAutodetect,
+			},
+		},
+		{
+			description: "explicit URL-only mode",
+			input: Config{
+				Name: "site-38911",
+				URL:  mustParseURL("http://127.0.0.1:38911"),
+				Mode: ModeURLOnly,
+			},
+		},
+		{
+			description:        "URL-only mode with a link selector",
+			expectErrSubstring: "url-only mode",
+			input: Config{
+				Name:         "site-38911",
+				URL:          mustParseURL("http://127.0.0.1:38911"),
+				Mode:         ModeURLOnly,
+				LinkSelector: cascadia.MustCompile("a"),
+			},
+		},
+		{
+			description: "explicit feed mode",
+			input: Config{
+				Name: "site-38911",
+				URL:  mustParseURL("http://127.0.0.1:38911"),
+				Mode: ModeFeed,
+			},
+		},
+		{
+			description:        "feed mode with a link selector",
+			expectErrSubstring: "feed mode",
+			input: Config{
+				Name:         "site-38911",
+				URL:          mustParseURL("http://127.0.0.1:38911"),
+				Mode:         ModeFeed,
+				LinkSelector: cascadia.MustCompile("a"),
+			},
+		},
+		{
+			description:        "unknown mode",
+			expectErrSubstring: "mode must be",
+			input: Config{
+				Name: "site-38911",
+				URL:  mustParseURL("http://127.0.0.1:38911"),
+				Mode: "guess",
+			},
+		},
+		{
+			description: "all JSONPaths",
+			input: Config{
+				Name:            "site-38911",
+				URL:             mustParseURL("http://127.0.0.1:38911"),
+				ItemJSONPath:    MustCompileJSONPath("$.articles[*]"),
+				CaptionJSONPath: MustCompileJSONPath("$.title"),
+				LinkJSONPath:    MustCompileJSONPath("$.url"),
+			},
+		},
+		{
+			description:        "item JSONPath without a caption JSONPath",
+			expectErrSubstring: "caption JSONPath",
+			input: Config{
+				Name:         "site-38911",
+				URL:          mustParseURL("http://127.0.0.1:38911"),
+				ItemJSONPath: MustCompileJSONPath("$.articles[*]"),
+				LinkJSONPath: MustCompileJSONPath("$.url"),
+			},
+		},
+		{
+			description: "POST with a body",
+			input: Config{
+				Name:   "site-38911",
+				URL:    mustParseURL("http://127.0.0.1:38911"),
+				Method: "POST",
+				Body:   `{"query": "latest"}`,
+			},
+		},
+		{
+			description:        "body without a method",
+			expectErrSubstring: "request body",
+			input: Config{
+				Name: "site-38911",
+				URL:  mustParseURL("http://127.0.0.1:38911"),
+				Body: `{"query": "latest"}`,
+			},
+		},
 		{
 			description:        "no caption selector",
 			expectErrSubstring: "caption selector",
@@ -448,3 +1872,114 @@ func TestCheckAndSetDefaultsWithZeroShortElementFilter(t *testing.T) {
 		)
 	}
 }
+
+func TestUnmarshalYAMLWithURLs(t *testing.T) {
+	testCases := []struct {
+		description string
+		config      string
+		expected    []string
+		expectErr   bool
+	}{
+		{
+			description: "not set",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+`,
+			expected: nil,
+		},
+		{
+			description: "list of URLs",
+			config: `name: site-38911
+urls:
+  - http://127.0.0.1:38911/a
+  - http://127.0.0.1:38911/b
+`,
+			expected: []string{
+				"http://127.0.0.1:38911/a",
+				"http://127.0.0.1:38911/b",
+			},
+		},
+		{
+			description: "url and urls",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+urls:
+  - http://127.0.0.1:38911/a
+`,
+			expectErr: true,
+		},
+		{
+			description: "not a list",
+			config: `name: site-38911
+urls: http://127.0.0.1:38911/a
+`,
+			expectErr: true,
+		},
+		{
+			description: "URL without a scheme",
+			config: `name: site-38911
+urls:
+  - 127.0.0.1:38911/a
+`,
+			expectErr: true,
+		},
+		{
+			description: "list for another option",
+			config: `name: site-38911
+url: http://127.0.0.1:38911
+cookie:
+  - a=b
+`,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dec := yaml.NewDecoder(bytes.NewBuffer([]byte(tc.config)))
+			var c Config
+			if err := dec.Decode(&c); (err != nil) != tc.expectErr {
+				t.Fatalf(
+					"expected error status of %v but got %v with error %v",
+					tc.expectErr,
+					err != nil,
+					err,
+				)
+			}
+			if tc.expectErr {
+				return
+			}
+			var us []string
+			for _, u := range c.URLs {
+				us = append(us, u.String())
+			}
+			assert.Equal(t, tc.expected, us)
+		})
+	}
+}
+
+func TestExpand(t *testing.T) {
+	c := Config{
+		Name: "forum",
+		URLs: []url.URL{
+			{Scheme: "https", Host: "forum.example.com", Path: "/r/golang/"},
+			{Scheme: "https", Host: "news.example.com"},
+		},
+		MaxItems: 3,
+	}
+
+	cs := c.Expand()
+	if len(cs) != 2 {
+		t.Fatalf("expected two configs but got %v", len(cs))
+	}
+	assert.Equal(t, "forum: r/golang", cs[0].Name)
+	assert.Equal(t, "https://forum.example.com/r/golang/", cs[0].URL.String())
+	assert.Equal(t, "forum: news.example.com", cs[1].Name)
+	for _, e := range cs {
+		assert.Nil(t, e.URLs)
+		assert.Equal(t, uint(3), e.MaxItems)
+	}
+
+	single := Config{Name: "site", MaxItems: 3}
+	assert.Equal(t, []Config{single}, single.Expand())
+}
@@ -0,0 +1,222 @@
+package linksrc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONPath is a compiled JSONPath expression for extracting values from a JSON
+// document. We support the subset of JSONPath that we need to find link items
+// in an API response:
+//
+//   - $ (or @), the root of the document
+//   - .name and ['name'], a member of an object
+//   - [n], an element of an array, counting from the end if n is negative
+//   - .* and [*], every member of an object or element of an array
+//   - ..name, ..*, and ..[n], the same as above, but for the value and every
+//     value nested within it
+type JSONPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+// jsonPathStep is a single step of a JSONPath expression, e.g., ".name"
+type jsonPathStep struct {
+	// Apply the step to every value nested within the input, not just the
+	// input itself
+	recursive bool
+	// Match every member or element
+	wildcard bool
+	// The name of an object member to match. Used if index is nil and
+	// wildcard is false.
+	name string
+	// The index of an array element to match
+	index *int
+}
+
+// CompileJSONPath parses expr and returns a JSONPath for evaluating it, or an
+// error if expr is not a valid expression.
+func CompileJSONPath(expr string) (*JSONPath, error) {
+	s := strings.TrimSpace(expr)
+	if !strings.HasPrefix(s, "$") && !strings.HasPrefix(s, "@") {
+		return nil, errors.New("the expression must begin with $ or @")
+	}
+	s = s[1:]
+
+	var steps []jsonPathStep
+	for s != "" {
+		var st jsonPathStep
+		switch {
+		case strings.HasPrefix(s, ".."):
+			st.recursive = true
+			s = s[2:]
+			if strings.HasPrefix(s, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(s, "."):
+			s = strings.TrimPrefix(s, ".")
+			end := strings.IndexAny(s, ".[")
+			if end == -1 {
+				end = len(s)
+			}
+			n := s[:end]
+			s = s[end:]
+			switch n {
+			case "":
+				return nil, fmt.Errorf("expected a member name in %q", expr)
+			case "*":
+				st.wildcard = true
+			default:
+				st.name = n
+			}
+			steps = append(steps, st)
+			continue
+		case !strings.HasPrefix(s, "["):
+			return nil, fmt.Errorf("unexpected %q in %q", s, expr)
+		}
+
+		end := strings.Index(s, "]")
+		if end == -1 {
+			return nil, fmt.Errorf("missing ] in %q", expr)
+		}
+		b := strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+
+		switch {
+		case b == "*":
+			st.wildcard = true
+		case len(b) >= 2 && (b[0] == '\'' || b[0] == '"') && b[len(b)-1] == b[0]:
+			st.name = b[1 : len(b)-1]
+		default:
+			i, err := strconv.Atoi(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid subscript %q in %q", b, expr)
+			}
+			st.index = &i
+		}
+		steps = append(steps, st)
+	}
+
+	return &JSONPath{
+		expr:  expr,
+		steps: steps,
+	}, nil
+}
+
+// MustCompileJSONPath is like CompileJSONPath but panics if expr is not a
+// valid expression
+func MustCompileJSONPath(expr string) *JSONPath {
+	p, err := CompileJSONPath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String implements fmt.Stringer
+func (p *JSONPath) String() string {
+	return p.expr
+}
+
+// Eval returns the values in v that p matches, in document order. Members of
+// an object are in order of their names. v must be a value decoded by
+// encoding/json into an interface{}.
+func (p *JSONPath) Eval(v interface{}) []interface{} {
+	vs := []interface{}{v}
+	for _, st := range p.steps {
+		var next []interface{}
+		for _, v := range vs {
+			if st.recursive {
+				for _, d := range descendants(v) {
+					next = append(next, st.match(d)...)
+				}
+				continue
+			}
+			next = append(next, st.match(v)...)
+		}
+		vs = next
+	}
+	return vs
+}
+
+// match returns the members or elements of v that st matches
+func (st jsonPathStep) match(v interface{}) []interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if st.wildcard {
+			var vs []interface{}
+			for _, k := range sortedKeys(t) {
+				vs = append(vs, t[k])
+			}
+			return vs
+		}
+		if st.index != nil {
+			return nil
+		}
+		if m, ok := t[st.name]; ok {
+			return []interface{}{m}
+		}
+	case []interface{}:
+		if st.wildcard {
+			return t
+		}
+		if st.index == nil {
+			return nil
+		}
+		i := *st.index
+		if i < 0 {
+			i += len(t)
+		}
+		if i >= 0 && i < len(t) {
+			return []interface{}{t[i]}
+		}
+	}
+	return nil
+}
+
+// descendants returns v and every value nested within it, in document order
+func descendants(v interface{}) []interface{} {
+	vs := []interface{}{v}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(t) {
+			vs = append(vs, descendants(t[k])...)
+		}
+	case []interface{}:
+		for _, e := range t {
+			vs = append(vs, descendants(e)...)
+		}
+	}
+	return vs
+}
+
+// sortedKeys returns the names of the members of m in order
+func sortedKeys(m map[string]interface{}) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// jsonString returns the first value in vs that is a string or number as a
+// string, or an empty string if there isn't one
+func jsonString(vs []interface{}) string {
+	for _, v := range vs {
+		switch t := v.(type) {
+		case string:
+			return t
+		case json.Number:
+			return t.String()
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package linksrc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPath(t *testing.T) {
+	doc := `{
+  "a": {"b": [{"c": 1}, {"c": 2}, {"d": 3}]},
+  "e": "f",
+  "g h": true
+}`
+
+	cases := []struct {
+		description string
+		expr        string
+		expected    []interface{}
+		expectErr   bool
+	}{
+		{
+			description: "root",
+			expr:        "$.e",
+			expected:    []interface{}{"f"},
+		},
+		{
+			description: "member in brackets",
+			expr:        "$['g h']",
+			expected:    []interface{}{true},
+		},
+		{
+			description: "array wildcard",
+			expr:        "$.a.b[*].c",
+			expected:    []interface{}{json.Number("1"), json.Number("2")},
+		},
+		{
+			description: "negative index",
+			expr:        "$.a.b[-1].d",
+			expected:    []interface{}{json.Number("3")},
+		},
+		{
+			description: "recursive descent",
+			expr:        "$..c",
+			expected:    []interface{}{json.Number("1"), json.Number("2")},
+		},
+		{
+			description: "no match",
+			expr:        "$.a.x",
+			expected:    nil,
+		},
+		{
+			description: "missing root",
+			expr:        "a.b",
+			expectErr:   true,
+		},
+		{
+			description: "unclosed bracket",
+			expr:        "$.a[0",
+			expectErr:   true,
+		},
+		{
+			description: "invalid subscript",
+			expr:        "$.a[first]",
+			expectErr:   true,
+		},
+		{
+			description: "empty member name",
+			expr:        "$.a.",
+			expectErr:   true,
+		},
+	}
+
+	d := json.NewDecoder(strings.NewReader(doc))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("cannot decode the test document: %v", err)
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			p, err := CompileJSONPath(c.expr)
+			if (err != nil) != c.expectErr {
+				t.Fatalf("expected error status of %v but got %v", c.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, c.expected, p.Eval(v))
+		})
+	}
+}
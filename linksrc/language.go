@@ -0,0 +1,144 @@
+package linksrc
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// languageScripts maps the languages that we can identify from their writing
+// systems alone to the Unicode scripts they use
+var languageScripts = map[string][]*unicode.RangeTable{
+	"ar": {unicode.Arabic},
+	"el": {unicode.Greek},
+	"he": {unicode.Hebrew},
+	"hi": {unicode.Devanagari},
+	"ja": {unicode.Hiragana, unicode.Katakana},
+	"ko": {unicode.Hangul},
+	"ru": {unicode.Cyrillic},
+	"th": {unicode.Thai},
+	"zh": {unicode.Han},
+}
+
+// languageStopWords maps languages written in the Latin script to common words
+// that are rare in the other languages here. We detect the language of a
+// caption by counting these.
+var languageStopWords = map[string][]string{
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "ein", "eine", "für", "auf", "den", "dem", "sich", "auch", "wie", "über", "nach"},
+	"en": {"the", "and", "of", "to", "is", "in", "for", "with", "that", "this", "on", "are", "from", "how", "why", "what", "after", "its"},
+	"es": {"el", "la", "los", "las", "y", "es", "en", "del", "por", "con", "para", "una", "que", "se", "su", "más", "como", "sobre"},
+	"fr": {"le", "la", "les", "et", "est", "des", "du", "un", "une", "pour", "dans", "que", "qui", "sur", "avec", "pas", "au", "aux"},
+	"it": {"il", "lo", "gli", "e", "è", "della", "di", "che", "per", "con", "una", "del", "non", "sono", "alla", "nel", "come", "dopo"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "op", "met", "voor", "zijn", "dat", "die", "naar", "ook", "bij", "over", "wordt"},
+	"pt": {"o", "os", "as", "e", "é", "do", "da", "dos", "das", "em", "um", "uma", "para", "com", "não", "que", "por", "sobre"},
+}
+
+// supportedLanguages returns the ISO 639-1 codes of the languages that
+// detectLanguage can identify, in order
+func supportedLanguages() []string {
+	var ls []string
+	for l := range languageScripts {
+		ls = append(ls, l)
+	}
+	for l := range languageStopWords {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// isSupportedLanguage returns whether detectLanguage can identify the
+// language with the ISO 639-1 code l
+func isSupportedLanguage(l string) bool {
+	_, s := languageScripts[l]
+	_, w := languageStopWords[l]
+	return s || w
+}
+
+// detectLanguage returns the ISO 639-1 code of the language that text is most
+// likely written in, or an empty string if we can't tell, e.g., because text
+// is too short. We look at the script of each letter first, then count common
+// words for text in the Latin script. This is meant to be cheap rather than
+// precise.
+func detectLanguage(text string) string {
+	scripts := make(map[string]int)
+	var latin, letters int
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for l, ts := range languageScripts {
+			if unicode.In(r, ts...) {
+				scripts[l]++
+				break
+			}
+		}
+	}
+
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese text mixes kana with Han characters, so any kana means
+	// Japanese rather than Chinese.
+	if scripts["ja"] > 0 {
+		scripts["ja"] += scripts["zh"]
+		delete(scripts, "zh")
+	}
+
+	best, most := "", latin
+	for _, l := range sortedLanguages(scripts) {
+		if scripts[l] > most {
+			best, most = l, scripts[l]
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	counts := make(map[string]int)
+	for _, w := range words {
+		for l, sw := range languageStopWords {
+			for _, s := range sw {
+				if w == s {
+					counts[l]++
+					break
+				}
+			}
+		}
+	}
+
+	best, most = "", 0
+	tie := false
+	for _, l := range sortedLanguages(counts) {
+		switch {
+		case counts[l] > most:
+			best, most, tie = l, counts[l], false
+		case counts[l] == most:
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return best
+}
+
+// sortedLanguages returns the keys of m in order, so ties are broken the same
+// way each time
+func sortedLanguages(m map[string]int) []string {
+	ls := make([]string, 0, len(m))
+	for l := range m {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
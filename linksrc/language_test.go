@@ -0,0 +1,28 @@
+package linksrc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{text: "How the city is rethinking its transit plans", expected: "en"},
+		{text: "Die Stadt plant eine neue Straßenbahn für den Norden", expected: "de"},
+		{text: "Как город пересматривает свои транспортные планы", expected: "ru"},
+		{text: "城市正在重新考虑其交通计划", expected: "zh"},
+		{text: "市は交通計画を見直している", expected: "ja"},
+		{text: "Brexit", expected: ""},
+		{text: "2024", expected: ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			assert.Equal(t, c.expected, detectLanguage(c.text))
+		})
+	}
+}
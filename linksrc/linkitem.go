@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"time"
 
 	"github.com/ptgott/one-newsletter/storage"
@@ -17,24 +18,76 @@ type LinkItem struct {
 	// trust it.
 	LinkURL string
 	Caption string
+	// Caption as HTML that keeps the link source's emphasis, if the link
+	// source is configured for rich captions and the caption has any. This
+	// only ever includes escaped text and the tags in richCaptionTags.
+	RichCaption string
+	// A secondary line of text about the link item, e.g., the description
+	// of a feed item, to show under the caption. Blank if the link source
+	// doesn't have one.
+	Summary string
+	// When the link source published the link item, if it says so, e.g.,
+	// in a feed. This is the zero value otherwise.
+	Published time.Time
+	// Whether we already stored the link item after an earlier scrape,
+	// i.e., it isn't new
+	Seen bool
+	// When we first stored the link item, if Seen is true
+	FirstSeen time.Time
 }
 
 // Key returns the key to use for determining whether a LinkItem has already
 // been stored within the database
 func (li LinkItem) Key() []byte {
-	// The key is the hash of the entire serialized LinkItem. This lets us quickly
-	// determine whether a LinkItem already exists in storage.
+	return li.KeyBy(DedupeByURLAndCaption)
+}
+
+// KeyBy is like Key, but uses the fields of the LinkItem that by names, either
+// DedupeByURL or DedupeByURLAndCaption. If by is blank, we use
+// DedupeByURLAndCaption.
+func (li LinkItem) KeyBy(by string) []byte {
+	// The key is the hash of the serialized LinkItem. This lets us quickly
+	// determine whether a LinkItem already exists in storage. LinkURL is
+	// already normalized by the time we create the LinkItem.
 	k := sha256.New()
-	k.Write([]byte(li.Caption))
+	if by != DedupeByURL {
+		k.Write([]byte(li.Caption))
+	}
 	k.Write([]byte(li.LinkURL))
 	return k.Sum(nil)
 }
 
+// CaptionKey returns a key for the caption of the LinkItem alone, for
+// telling whether we've stored the same caption under a different URL. The
+// key has a prefix so it can't match a key from KeyBy.
+func (li LinkItem) CaptionKey() []byte {
+	k := sha256.New()
+	k.Write([]byte("caption:"))
+	k.Write([]byte(li.Caption))
+	return k.Sum(nil)
+}
+
 // NewKVEntry prepares the LinkItem to be saved in the KV database. Keys are
 // SHA256 hashes of the entire LinkItem. Values are timestamps in seconds since
 // the Unix epoch. Usually we'll just be checking whether newly fetched
-// LinkItems are already saved. Eventually we might want to use the timestamp.
+// LinkItems are already saved. Use FirstSeen to read the timestamp.
 func (li LinkItem) NewKVEntry() storage.KVEntry {
+	return li.NewKVEntryBy(DedupeByURLAndCaption)
+}
+
+// NewKVEntryBy is like NewKVEntry, but uses KeyBy with by to create the key.
+func (li LinkItem) NewKVEntryBy(by string) storage.KVEntry {
+	return newKVEntry(li.KeyBy(by))
+}
+
+// NewCaptionKVEntry is like NewKVEntry, but uses CaptionKey to create the
+// key.
+func (li LinkItem) NewCaptionKVEntry() storage.KVEntry {
+	return newKVEntry(li.CaptionKey())
+}
+
+// newKVEntry returns a KVEntry with key k and the current time as its value.
+func newKVEntry(k []byte) storage.KVEntry {
 
 	var buf bytes.Buffer
 
@@ -48,8 +101,18 @@ func (li LinkItem) NewKVEntry() storage.KVEntry {
 	binary.Write(&buf, binary.LittleEndian, time.Now().Unix())
 
 	return storage.KVEntry{
-		Key:   li.Key(),
+		Key:   k,
 		Value: buf.Bytes(),
 	}
 
 }
+
+// FirstSeen returns the time when we stored e, an entry created with
+// NewKVEntry or NewKVEntryBy.
+func FirstSeen(e storage.KVEntry) (time.Time, error) {
+	var ts int64
+	if err := binary.Read(bytes.NewReader(e.Value), binary.LittleEndian, &ts); err != nil {
+		return time.Time{}, fmt.Errorf("can't read the timestamp of the database entry: %v", err)
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -1,8 +1,12 @@
 package linksrc
 
 import (
+	"bytes"
 	"testing"
 	"testing/quick"
+	"time"
+
+	"github.com/ptgott/one-newsletter/storage"
 )
 
 func TestLinkItem_Key(t *testing.T) {
@@ -32,6 +36,61 @@ func TestLinkItem_Key(t *testing.T) {
 	}
 }
 
+func TestLinkItem_KeyBy(t *testing.T) {
+	original := LinkItem{
+		LinkURL: "http://www.example.com/stories/hot-take",
+		Caption: "This is a hot take!",
+	}
+	edited := LinkItem{
+		LinkURL: "http://www.example.com/stories/hot-take",
+		Caption: "This is a hot take, updated with new details",
+	}
+	other := LinkItem{
+		LinkURL: "http://www.example.com/stories/stuff-happened",
+		Caption: "This is a hot take!",
+	}
+
+	tests := []struct {
+		name         string
+		by           string
+		editedIsSeen bool
+	}{
+		{
+			name:         "default",
+			by:           "",
+			editedIsSeen: false,
+		},
+		{
+			name:         "URL and caption",
+			by:           DedupeByURLAndCaption,
+			editedIsSeen: false,
+		},
+		{
+			name:         "URL only",
+			by:           DedupeByURL,
+			editedIsSeen: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := original.KeyBy(tt.by)
+			if seen := bytes.Equal(k, edited.KeyBy(tt.by)); seen != tt.editedIsSeen {
+				t.Errorf("expected the edited link item to be seen: %v, but got %v", tt.editedIsSeen, seen)
+			}
+			if bytes.Equal(k, other.KeyBy(tt.by)) {
+				t.Error("expected link items with different URLs to have different keys")
+			}
+			if !bytes.Equal(original.NewKVEntryBy(tt.by).Key, k) {
+				t.Error("expected the KV entry to use the same key as KeyBy")
+			}
+		})
+	}
+
+	if !bytes.Equal(original.Key(), original.KeyBy(DedupeByURLAndCaption)) {
+		t.Error("expected Key to use the URL and caption")
+	}
+}
+
 func TestLinkItem_NewKVEntry(t *testing.T) {
 	// NewKVentry is really straightforward, so we'll just call the
 	// function a ton of times with arbitrary inputs and see if
@@ -53,3 +112,46 @@ func TestLinkItem_NewKVEntry(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFirstSeen(t *testing.T) {
+	before := time.Now().Truncate(time.Second)
+	li := LinkItem{
+		LinkURL: "www.example.com/stories/1",
+		Caption: "This is a story",
+	}
+
+	fs, err := FirstSeen(li.NewKVEntry())
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	if fs.Before(before) || fs.After(time.Now()) {
+		t.Errorf("expected a timestamp from when we created the entry but got %v", fs)
+	}
+
+	if _, err := FirstSeen(storage.KVEntry{Key: li.Key(), Value: []byte{1}}); err == nil {
+		t.Error("expected an error for a truncated timestamp but got nil")
+	}
+
+	// Read the timestamp back the way a scrape does, after storing the
+	// entry in the database
+	db, err := storage.NewBadgerDB(t.TempDir(), time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.Put(li.NewKVEntry()); err != nil {
+		t.Fatal(err)
+	}
+	e, err := db.Read(li.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err = FirstSeen(e)
+	if err != nil {
+		t.Fatalf("expected no error reading the stored entry but got %v", err)
+	}
+	if fs.Before(before) || fs.After(time.Now()) {
+		t.Errorf("expected the timestamp we stored but got %v", fs)
+	}
+}
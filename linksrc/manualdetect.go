@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"strings"
 
 	"golang.org/x/net/html"
 )
@@ -20,6 +21,13 @@ func manuallyDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, mess
 		return
 	}
 
+	if !hasContent(n) {
+		messages <- jsRenderedMessage
+		close(links)
+		close(messages)
+		return
+	}
+
 	if conf.ItemSelector == nil {
 		messages <- "Could not parse the link item selector."
 		close(links)
@@ -37,8 +45,14 @@ func manuallyDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, mess
 	// Get all items listing content to link to
 	ls := conf.ItemSelector.MatchAll(n)
 
+	var nonWeb int
 	for i := range ls {
 		ns := conf.LinkSelector.MatchAll(ls[i])
+		if len(ns) > 1 && conf.FirstLinkMatch {
+			// The user expects more than one match per item, so
+			// take the first one in document order.
+			ns = ns[:1]
+		}
 		if len(ns) > 1 {
 			messages <- "The link selector is ambiguous, so we couldn't parse any link items."
 			close(links)
@@ -81,6 +95,11 @@ func manuallyDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, mess
 			return
 		}
 
+		if !webLink(*u) {
+			nonWeb++
+			continue
+		}
+
 		if conf.CaptionSelector == nil {
 			messages <- "Could not parse the caption selector."
 			close(links)
@@ -88,6 +107,14 @@ func manuallyDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, mess
 			return
 		}
 
+		if caption, ok := attributeCaption(ns[0], conf.CaptionAttribute); ok {
+			links <- LinkItem{
+				LinkURL: getDisplayURL(conf.URL, *u),
+				Caption: caption,
+			}
+			continue
+		}
+
 		cs := conf.CaptionSelector.MatchAll(ls[i])
 		var caption string
 		if len(cs) == 0 {
@@ -111,11 +138,30 @@ func manuallyDetectLinkItems(r io.Reader, conf Config, links chan LinkItem, mess
 		links <- LinkItem{
 			LinkURL: getDisplayURL(conf.URL, *u),
 			Caption: caption,
+			Summary: manualSummary(ls[i], conf),
 		}
 	}
 
+	if nonWeb > 0 {
+		messages <- nonWebLinksMessage(nonWeb)
+	}
 	close(links)
 	close(messages)
 	return
 
 }
+
+// manualSummary returns the text of the element within the link item n that
+// conf.SummarySelector matches, with whitespace collapsed. If there's no
+// summary selector, or it doesn't match exactly one element, there's no
+// summary.
+func manualSummary(n *html.Node, conf Config) string {
+	if conf.SummarySelector == nil {
+		return ""
+	}
+	ss := conf.SummarySelector.MatchAll(n)
+	if len(ss) != 1 {
+		return ""
+	}
+	return strings.Join(strings.Fields(nodeText(ss[0])), " ")
+}
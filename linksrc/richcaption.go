@@ -0,0 +1,84 @@
+package linksrc
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// richCaptionTags maps the elements whose emphasis we keep in rich captions to
+// the tags we render them with. We never keep attributes, so these are the
+// only tags that can appear in a rich caption.
+var richCaptionTags = map[string]string{
+	"b":      "strong",
+	"em":     "em",
+	"i":      "em",
+	"strong": "strong",
+}
+
+// emphasis is a phrase that a link source emphasized within a caption
+type emphasis struct {
+	// The text of the phrase, with whitespace collapsed the same way as in
+	// a caption
+	text string
+	// The tag to render the phrase with, from richCaptionTags
+	tag string
+}
+
+// findEmphasis returns the phrases within n that are in elements listed in
+// richCaptionTags, in document order. If emphasis elements are nested, we
+// only use the outermost one.
+func findEmphasis(n *html.Node) []emphasis {
+	var es []emphasis
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		if t, ok := richCaptionTags[c.Data]; ok {
+			if s := strings.Join(strings.Fields(nodeText(c)), " "); s != "" {
+				es = append(es, emphasis{text: s, tag: t})
+			}
+			continue
+		}
+		es = append(es, findEmphasis(c)...)
+	}
+	return es
+}
+
+// nodeText returns the text of n and its children
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(nodeText(c))
+	}
+	return b.String()
+}
+
+// richCaption returns the caption c as HTML, wrapping the phrases in es in
+// their tags. We look for each phrase after the previous one, and skip
+// phrases that aren't in c, e.g., because we truncated it. Everything else is
+// escaped, so the result is safe to include in an HTML document as is. If
+// none of the phrases are in c, richCaption returns an empty string.
+func richCaption(c string, es []emphasis) string {
+	var b strings.Builder
+	var found bool
+	for _, e := range es {
+		i := strings.Index(c, e.text)
+		if i == -1 {
+			continue
+		}
+		found = true
+		b.WriteString(html.EscapeString(c[:i]))
+		fmt.Fprintf(&b, "<%v>%v</%v>", e.tag, html.EscapeString(e.text), e.tag)
+		c = c[i+len(e.text):]
+	}
+	if !found {
+		return ""
+	}
+	b.WriteString(html.EscapeString(c))
+	return b.String()
+}
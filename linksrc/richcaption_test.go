@@ -0,0 +1,69 @@
+package linksrc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+func TestRichCaption(t *testing.T) {
+	cases := []struct {
+		description  string
+		html         string
+		richCaptions bool
+		expected     string
+	}{
+		{
+			description:  "bold and italic text",
+			html:         `<div><a href="/1">This is a <b>very</b> important story about <em>cities</em></a></div>`,
+			richCaptions: true,
+			expected:     "This is a <strong>very</strong> important story about <em>cities</em>.",
+		},
+		{
+			description:  "nested emphasis",
+			html:         `<div><a href="/1">This is a <strong>very <i>important</i> story</strong> for you</a></div>`,
+			richCaptions: true,
+			expected:     "This is a <strong>very important story</strong> for you.",
+		},
+		{
+			description:  "attributes and other tags",
+			html:         `<div><a href="/1">This is a <em class="x" onclick="alert(1)">very</em> <span>important</span> story &lt;3</a></div>`,
+			richCaptions: true,
+			expected:     "This is a <em>very</em> important story &lt;3.",
+		},
+		{
+			description:  "no emphasis",
+			html:         `<div><a href="/1">This is a very important story</a></div>`,
+			richCaptions: true,
+			expected:     "",
+		},
+		{
+			description:  "rich captions turned off",
+			html:         `<div><a href="/1">This is a <b>very</b> important story</a></div>`,
+			richCaptions: false,
+			expected:     "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			h, err := html.Parse(strings.NewReader(tc.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := cascadia.MustCompile("div").MatchFirst(h)
+			l := cascadia.MustCompile("a").MatchFirst(h)
+			r := containerCaption(c, l, Config{
+				RichCaptions: tc.richCaptions,
+			})
+			if r.err != nil {
+				t.Fatalf("expected no error but got %v", r.err)
+			}
+			if r.richCaption != tc.expected {
+				t.Errorf("expected rich caption %q but got %q", tc.expected, r.richCaption)
+			}
+		})
+	}
+}
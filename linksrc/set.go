@@ -1,11 +1,16 @@
 package linksrc
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
@@ -27,70 +32,179 @@ func getDisplayURL(configURL, linkURL url.URL) string {
 }
 
 // NewSet initializes a new collection of listed link items for an HTML
-// document Reader, link source configuration, and HTTP status code (which
-// is treated as a 200 OK if not set)
-func NewSet(ctx context.Context, r io.Reader, conf Config, code int) Set {
+// document Reader, link source configuration, HTTP status code (which
+// is treated as a 200 OK if not set), and Content-Type header (which we use
+// to detect the format of the document if its content is ambiguous)
+func NewSet(ctx context.Context, r io.Reader, conf Config, code int, contentType string) Set {
 	s := Set{
 		items: map[string]LinkItem{},
 	}
-	items := make(map[string]LinkItem)
-
-	start := time.Now()
-	defer func() {
-		elapsed := time.Since(start)
-		log.Info().Msgf(
-			"processed %v items for link source %q in %v ms",
-			len(items),
-			conf.Name,
-			elapsed.Milliseconds(),
-		)
-	}()
 
 	if r == nil {
 		s.AddMessage("could not read the HTML document in order to parse it")
 		return s
 	}
 
+	// A zero is treated as a 200, since that's the default if the code is
+	// unset. Users can also treat other codes as successful, e.g., for sites
+	// that serve valid pages with nonstandard status codes.
+	success := code-(code%100) == 200 || code == 0 || slices.Contains(conf.SuccessCodes, code)
+
 	codesToMessages := map[int]string{
 		403: "We don't have permission to get links from this website. Check your configuration.",
 		404: "We couldn't find the website at this URL. Maybe it changed?",
 		429: "We were rate limited. You should change your configuration to check this site less frequently.",
 	}
 
-	c, ok := codesToMessages[code]
+	if !success {
+		c, ok := codesToMessages[code]
+
+		// Users can replace the default message for a status code or
+		// turn it off with a blank message.
+		if m, custom := conf.StatusMessages[code]; custom {
+			c, ok = m, true
+		}
+
+		if ok && c != "" {
+			s.AddMessage(c)
+		}
+
+		if !ok && code == 400 {
+			s.AddMessage(fmt.Sprintf("Got a %v error sending the scrape request—check your config.", code))
+		}
 
-	if ok {
-		s.AddMessage(c)
+		if !ok && code-(code%100) == 500 {
+			s.AddMessage(fmt.Sprintf("Got a %v error sending the scrape request—check manually to see if this is temporary.", code))
+		}
+
+		if !ok && code >= 600 {
+			s.AddMessage(fmt.Sprintf("Unexpected status code %v. Try visiting the site manually.", code))
+		}
+	}
+
+	s.Name = conf.Name
+	s.url = conf.URL
+	s.priority = conf.Priority
+	s.dedupeBy = conf.DedupeBy
+	s.dedupeCaptions = conf.DedupeCaptionAcrossRuns
+
+	// The rest of this function is just processing HTML, so bail early on
+	// unsuccessful responses.
+	if !success {
+		return s
 	}
 
-	if !ok && code == 400 {
-		s.AddMessage(fmt.Sprintf("Got a %v error sending the scrape request—check your config.", code))
+	// Don't try to parse a PDF or image as HTML, which would give us an
+	// empty Set with no explanation. Some sites serve HTML with a generic
+	// Content-Type like application/octet-stream, so only give up if the
+	// content doesn't look like text either.
+	if !isTextContentType(contentType) {
+		br := bufio.NewReaderSize(r, sniffSize)
+		// Peek returns an error if the page is shorter than the buffer,
+		// but we can still sniff whatever it returns.
+		prefix, _ := br.Peek(sniffSize)
+		if !isTextContentType(http.DetectContentType(prefix)) {
+			s.AddMessage(nonTextMessage(contentType))
+			return s
+		}
+		r = br
 	}
 
-	if !ok && code-(code%100) == 500 {
-		s.AddMessage(fmt.Sprintf("Got a %v error sending the scrape request—check manually to see if this is temporary.", code))
+	return parse(ctx, r, conf, contentType, s)
+}
+
+// sniffSize is the number of bytes that http.DetectContentType considers
+const sniffSize = 512
+
+// NewErrorSet returns an empty Set for the link source configured in conf
+// with the message msg, e.g., because we couldn't reach the link source. This
+// way, the email still includes a section for the link source that explains
+// the problem.
+func NewErrorSet(conf Config, msg string) Set {
+	s := Set{
+		items:          map[string]LinkItem{},
+		Name:           conf.Name,
+		url:            conf.URL,
+		priority:       conf.Priority,
+		dedupeBy:       conf.DedupeBy,
+		dedupeCaptions: conf.DedupeCaptionAcrossRuns,
 	}
+	s.AddMessage(msg)
+	return s
+}
 
-	if !ok && code >= 600 {
-		s.AddMessage(fmt.Sprintf("Unexpected status code %v. Try visiting the site manually.", code))
+// isTextContentType returns whether the Content-Type header ct indicates a
+// document we can parse for link items, i.e., HTML, a feed, or other text. We
+// treat a missing or invalid header as text, since we can still try to detect
+// the format from the content.
+func isTextContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return true
 	}
+	return strings.HasPrefix(mt, "text/") ||
+		strings.HasSuffix(mt, "+xml") ||
+		strings.HasSuffix(mt, "+json") ||
+		formatFromContentType(mt) != formatUnknown
+}
 
-	s.Name = conf.Name
+// nonTextMessage returns a message to add to a Set for a link source that
+// returned content of the media type ct, which we can't parse.
+func nonTextMessage(ct string) string {
+	return fmt.Sprintf("This URL returned non-HTML content: %v", ct)
+}
 
-	// The rest of this function is just processing HTML, so bail early on
-	// unsuccessful responses. A zero is treated as a 200, since that's the
-	// default if the code is unset.
-	if code-(code%100) != 200 && code != 0 {
+// Parse extracts link items from the document in r using the link source
+// configuration conf, without the HTTP-specific handling of NewSet. Use this
+// to test a configuration against a saved page, for example. We detect the
+// format of the document from its content. Any problems we find are included
+// in the messages of the returned Set.
+func Parse(ctx context.Context, r io.Reader, conf Config) Set {
+	s := Set{
+		items: map[string]LinkItem{},
+	}
+
+	if r == nil {
+		s.AddMessage("could not read the HTML document in order to parse it")
 		return s
 	}
 
+	s.Name = conf.Name
+	s.url = conf.URL
+	s.priority = conf.Priority
+	s.dedupeBy = conf.DedupeBy
+	s.dedupeCaptions = conf.DedupeCaptionAcrossRuns
+
+	return parse(ctx, r, conf, "", s)
+}
+
+// parse adds the link items in r to s and returns the result, using the
+// Content-Type header contentType to detect the format of the document if its
+// content is ambiguous.
+func parse(ctx context.Context, r io.Reader, conf Config, contentType string, s Set) Set {
+	items := make(map[string]LinkItem)
+	// The order in which we first received each link URL, which is used to
+	// decide which link items to keep if we exceed the item limit.
+	var order []string
+
+	start := time.Now()
+
+	// The number of link items we left out for being in a language other
+	// than conf.Language
+	var otherLanguage int
+
 	linkCh := make(chan LinkItem)
 	msg := make(chan string)
 
-	if conf.ItemSelector == nil || conf.CaptionSelector == nil {
-		go autoDetectLinkItems(r, conf, linkCh, msg)
-	} else {
+	mode := conf.detectionMode()
+	log.Debug().
+		Str("linkSource", conf.Name).
+		Str("mode", mode).
+		Msg("detecting link items")
+	if mode == ModeManual {
 		go manuallyDetectLinkItems(r, conf, linkCh, msg)
+	} else {
+		go autoDetectLinkItems(r, conf, contentType, linkCh, msg)
 	}
 
 	for {
@@ -99,6 +213,26 @@ func NewSet(ctx context.Context, r io.Reader, conf Config, code int) Set {
 			if !ok {
 				goto finish
 			}
+			if conf.CaptionStripPattern != nil {
+				c := strings.TrimSpace(
+					conf.CaptionStripPattern.ReplaceAllString(l.Caption, ""),
+				)
+				// The rich caption no longer matches, so fall back
+				// to the plain one
+				if c != l.Caption {
+					l.RichCaption = ""
+				}
+				l.Caption = c
+			}
+			if conf.Language != "" {
+				if dl := detectLanguage(l.Caption); dl != "" && dl != conf.Language {
+					otherLanguage++
+					continue
+				}
+			}
+			if _, ok := items[l.LinkURL]; !ok {
+				order = append(order, l.LinkURL)
+			}
 			items[l.LinkURL] = l
 		case g, ok := <-msg:
 			if !ok {
@@ -115,16 +249,40 @@ func NewSet(ctx context.Context, r io.Reader, conf Config, code int) Set {
 	}
 finish:
 
+	if otherLanguage > 0 {
+		s.AddMessage(fmt.Sprintf(
+			"We left out %v link items that don't seem to be in the language %v.",
+			otherLanguage,
+			conf.Language,
+		))
+	}
+
 	s.items = items
 
 	// Fix invalid data before we enforce the item limit, since removing
 	// invalid items might take us under the limit.
 	s = cleanSet(s)
 
-	// If the number of list items we scraped is over the limit, we'll
-	// arbitrarily exclude some list items from our search by making the
-	// length of our final result slice less than the length of the initial
-	// result slice.
+	// A scrape that succeeds but finds fewer link items than usual
+	// probably means that a selector stopped matching. Check before we
+	// enforce the item limit, which is about the email, not the site.
+	if n := uint(len(s.items)); n < conf.ExpectMinItems {
+		log.Warn().
+			Str("linkSource", conf.Name).
+			Uint("items", n).
+			Uint("expectMinItems", conf.ExpectMinItems).
+			Msg("the link source has fewer link items than expected")
+		s.AddMessage(fmt.Sprintf(
+			"We found %v link items, but expected at least %v. The site may have changed, so check the selectors for this link source.",
+			n,
+			conf.ExpectMinItems,
+		))
+	}
+
+	// If the number of list items we scraped is over the limit, we'll keep
+	// the link items we received first and exclude the rest. Since link items
+	// arrive in document order, this keeps the same subset between scrapes
+	// of an unchanged page.
 	var limit uint
 
 	if conf.MaxItems == 0 || len(s.items) < int(conf.MaxItems) {
@@ -134,23 +292,34 @@ finish:
 		limit = conf.MaxItems
 	}
 
-	s.items = enforceLimit(s.items, limit)
+	s.items = enforceLimit(s.items, order, limit)
+	s.order = order
+
+	s.parseDuration = time.Since(start)
+	log.Info().Msgf(
+		"processed %v items for link source %q in %v ms",
+		len(items),
+		conf.Name,
+		s.parseDuration.Milliseconds(),
+	)
 
 	return s
 
 }
 
 // enforceLimit returns a copy of v after removing enough link items to satisfy
-// limit.
-func enforceLimit(v map[string]LinkItem, limit uint) map[string]LinkItem {
+// limit. It keeps link items in the order of their keys in order, ignoring
+// keys that are no longer in v.
+func enforceLimit(v map[string]LinkItem, order []string, limit uint) map[string]LinkItem {
 	m := make(map[string]LinkItem, limit)
 
-	var i uint = 0
-	for j := range v {
-		if i < limit {
-			m[j] = v[j]
+	for _, k := range order {
+		if uint(len(m)) >= limit {
+			break
+		}
+		if li, ok := v[k]; ok {
+			m[k] = li
 		}
-		i++
 	}
 	return m
 
@@ -162,7 +331,13 @@ func enforceLimit(v map[string]LinkItem, limit uint) map[string]LinkItem {
 func cleanSet(s Set) Set {
 	p := Set{}
 	p.Name = s.Name
+	p.url = s.url
+	p.priority = s.priority
+	p.dedupeBy = s.dedupeBy
+	p.dedupeCaptions = s.dedupeCaptions
 	p.messages = s.messages
+	p.parseDuration = s.parseDuration
+	p.order = s.order
 	p.items = make(map[string]LinkItem)
 
 	for k, v := range s.items {
@@ -180,11 +355,55 @@ func cleanSet(s Set) Set {
 type Set struct {
 	// The publication that the links came from
 	Name string
+	// The URL of the link source
+	url url.URL
+	// The priority of the link source when we leave out link items
+	priority int
+	// Which fields of each LinkItem we use to tell if we've seen it before
+	dedupeBy string
+	// Whether to tell if we've seen a LinkItem by its caption alone as well
+	dedupeCaptions bool
 	// LinkItems managed by the Set. Should not get and set keys directly,
 	// but rather via the functions AddLinkItem, RemoveLinkItem, and LinkItems
 	items map[string]LinkItem
+	// The keys of items in the order that we found the link items in the
+	// document. This can include keys that are no longer in items.
+	order []string
 	// Messages to include in an email, e.g., due to errors
 	messages []string
+	// How long it took to extract link items from the link source's
+	// document
+	parseDuration time.Duration
+}
+
+// URL returns the URL of the link source that the Set came from
+func (s *Set) URL() url.URL {
+	return s.url
+}
+
+// Priority returns the priority of the link source that the Set came from
+func (s *Set) Priority() int {
+	return s.priority
+}
+
+// DedupeBy returns which fields of each LinkItem in the Set to use to tell if
+// we've already seen it, for use with LinkItem.KeyBy
+func (s *Set) DedupeBy() string {
+	return s.dedupeBy
+}
+
+// ParseDuration returns how long it took to extract the Set's link items from
+// the link source's document. This is zero if we didn't parse the document,
+// e.g., because the link source returned an error status.
+func (s *Set) ParseDuration() time.Duration {
+	return s.parseDuration
+}
+
+// DedupeCaptionAcrossRuns returns whether to treat a LinkItem in the Set as
+// seen if we've already stored its caption, for use with
+// LinkItem.CaptionKey
+func (s *Set) DedupeCaptionAcrossRuns() bool {
+	return s.dedupeCaptions
 }
 
 // RemoveLinkItem removes the LinkItem from the Set. Not to be used
@@ -193,13 +412,43 @@ func (s *Set) RemoveLinkItem(li LinkItem) {
 	delete(s.items, li.LinkURL)
 }
 
-// LinkItems returns all of the LinkItems managed by the Set
+// MarkSeen records that we stored li after an earlier scrape, at firstSeen,
+// so we can tell readers that it isn't new. Not to be used concurrently
+func (s *Set) MarkSeen(li LinkItem, firstSeen time.Time) {
+	if _, ok := s.items[li.LinkURL]; !ok {
+		return
+	}
+	li.Seen = true
+	li.FirstSeen = firstSeen
+	s.items[li.LinkURL] = li
+}
+
+// LinkItems returns all of the LinkItems managed by the Set in the order that
+// they appear in the document, so that callers that leave out link items,
+// e.g., to keep an email small, make the same choice each time. Link items
+// without a known position come last, sorted by URL.
 func (s *Set) LinkItems() []LinkItem {
-	is := make([]LinkItem, len(s.items), len(s.items))
-	var i int
-	for _, v := range s.items {
-		is[i] = v
-		i++
+	is := make([]LinkItem, 0, len(s.items))
+	placed := make(map[string]struct{}, len(s.items))
+	for _, k := range s.order {
+		if li, ok := s.items[k]; ok {
+			is = append(is, li)
+			placed[k] = struct{}{}
+		}
+	}
+	if len(is) == len(s.items) {
+		return is
+	}
+
+	var rest []string
+	for k := range s.items {
+		if _, ok := placed[k]; !ok {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		is = append(is, s.items[k])
 	}
 	return is
 }
@@ -7,7 +7,11 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -47,12 +51,13 @@ func mustParseURL(raw string) url.URL {
 
 func TestNewSet(t *testing.T) {
 	tests := []struct {
-		source  io.Reader
-		name    string
-		conf    Config
-		code    int
-		want    Set
-		wantErr bool
+		source      io.Reader
+		name        string
+		conf        Config
+		code        int
+		contentType string
+		want        Set
+		wantErr     bool
 	}{
 		{
 			name:   "canonical/intended case",
@@ -67,6 +72,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -83,6 +89,60 @@ func TestNewSet(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "caption strip pattern: fixed prefix",
+			source: mustReadFile(path.Join("testdata", "section-captions.html"), t),
+			conf: Config{
+				Name:                "My Cool Publication",
+				URL:                 mustParseURL("http://www.example.com"),
+				ItemSelector:        css.MustCompile("body div#mostRead ol li"),
+				CaptionSelector:     css.MustCompile("div a.itemName"),
+				LinkSelector:        css.MustCompile("div a.itemName"),
+				ShortElementFilter:  3,
+				CaptionStripPattern: regexp.MustCompile(`^Opinion \| `),
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take! - The Baffler",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes. - The Baffler",
+					},
+				},
+			},
+		},
+		{
+			name:   "caption strip pattern: regex suffix",
+			source: mustReadFile(path.Join("testdata", "section-captions.html"), t),
+			conf: Config{
+				Name:                "My Cool Publication",
+				URL:                 mustParseURL("http://www.example.com"),
+				ItemSelector:        css.MustCompile("body div#mostRead ol li"),
+				CaptionSelector:     css.MustCompile("div a.itemName"),
+				LinkSelector:        css.MustCompile("div a.itemName"),
+				ShortElementFilter:  3,
+				CaptionStripPattern: regexp.MustCompile(`\s+-\s+[\w ]+$`),
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "Opinion | This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Opinion | Stuff happened today, yikes.",
+					},
+				},
+			},
+		},
 		{
 			name:   "links with different hostnames: manual",
 			source: mustReadFile(path.Join("testdata", "mixed-hostnames.html"), t),
@@ -96,6 +156,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://subdomain1.example.com/stories/hot-take": {
 						LinkURL: "http://subdomain1.example.com/stories/hot-take",
@@ -122,6 +183,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://subdomain1.example.com/stories/hot-take": {
 						LinkURL: "http://subdomain1.example.com/stories/hot-take",
@@ -151,6 +213,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -178,6 +241,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -205,6 +269,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -233,12 +298,121 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{},
+				messages: []string{
+					"The link selector is ambiguous, so we couldn't parse any link items.",
+				},
+			},
+		},
+		{
+			name:   "multiple links per item",
+			source: mustReadFile(path.Join("testdata", "multi-link-cards.html"), t),
+			conf: Config{
+				Name:            "My Cool Publication",
+				URL:             mustParseURL("http://www.example.com"),
+				ItemSelector:    css.MustCompile("article.card"),
+				CaptionSelector: css.MustCompile("p"),
+				LinkSelector:    css.MustCompile("a"),
+			},
+			want: Set{
+				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"The link selector is ambiguous, so we couldn't parse any link items.",
 				},
 			},
 		},
+		{
+			name:   "multiple links per item with the first link match",
+			source: mustReadFile(path.Join("testdata", "multi-link-cards.html"), t),
+			conf: Config{
+				Name:            "My Cool Publication",
+				URL:             mustParseURL("http://www.example.com"),
+				ItemSelector:    css.MustCompile("article.card"),
+				CaptionSelector: css.MustCompile("p"),
+				LinkSelector:    css.MustCompile("a"),
+				FirstLinkMatch:  true,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "Everyone is talking about it.",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Here is what you need to know.",
+					},
+					"http://www.example.com/stories/really-true": {
+						LinkURL: "http://www.example.com/stories/really-true",
+						Caption: "We looked into it for you.",
+					},
+				},
+			},
+		},
+		{
+			name:   "caption from a link attribute: manual",
+			source: mustReadFile(path.Join("testdata", "aria-label-links.html"), t),
+			conf: Config{
+				Name:             "My Cool Publication",
+				URL:              mustParseURL("http://www.example.com"),
+				ItemSelector:     css.MustCompile("div#latest li"),
+				CaptionSelector:  css.MustCompile("p.teaser"),
+				LinkSelector:     css.MustCompile("a.more"),
+				CaptionAttribute: "aria-label",
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://www.example.com/stories/really-true": {
+						LinkURL: "http://www.example.com/stories/really-true",
+						Caption: "Is this supposition really true?",
+					},
+				},
+			},
+		},
+		{
+			name:   "caption from a link attribute: automatic",
+			source: mustReadFile(path.Join("testdata", "aria-label-links.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				LinkSelector:       css.MustCompile("a.more"),
+				CaptionAttribute:   "aria-label",
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://www.example.com/stories/really-true": {
+						LinkURL: "http://www.example.com/stories/really-true",
+						Caption: "Is this supposition really true?",
+					},
+				},
+			},
+		},
 		{
 			name:   "ambiguous caption selector",
 			source: mustReadFile(path.Join("testdata", "straightforward.html"), t),
@@ -251,6 +425,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -279,6 +454,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"There are no links in the list item. Double-check your configuration.",
@@ -297,6 +473,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"The link selector does not match a link but rather div.",
@@ -315,6 +492,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 			},
 		},
@@ -331,6 +509,7 @@ func TestNewSet(t *testing.T) {
 			code: 400,
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"Got a 400 error sending the scrape request—check your config.",
@@ -350,6 +529,7 @@ func TestNewSet(t *testing.T) {
 			code: 500,
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"Got a 500 error sending the scrape request—check manually to see if this is temporary.",
@@ -369,12 +549,45 @@ func TestNewSet(t *testing.T) {
 			code: 700,
 			want: Set{
 				Name:  "My Cool Publication",
+				url:   mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{},
 				messages: []string{
 					"Unexpected status code 700. Try visiting the site manually.",
 				},
 			},
 		},
+		{
+			name:   "custom success code",
+			source: mustReadFile(path.Join("testdata", "straightforward.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ItemSelector:       css.MustCompile("body div#mostRead ol li"),
+				CaptionSelector:    css.MustCompile("div a.itemName"),
+				LinkSelector:       css.MustCompile("div a.itemName"),
+				ShortElementFilter: 3,
+				SuccessCodes:       []int{999},
+			},
+			code: 999,
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://www.example.com/storiesreally-true": {
+						LinkURL: "http://www.example.com/storiesreally-true",
+						Caption: "Is this supposition really true?",
+					},
+				},
+			},
+		},
 		{
 			name:   "autodetect with link selector: ny magazine intelligencer",
 			source: mustReadFile(path.Join("testdata", "intelligencer-feed.html"), t),
@@ -387,6 +600,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "Intelligencer",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/intelligencer/2022/04/subway-shooting-proved-regular-new-yorkers-fight-crime-too.html": {
 						LinkURL: "http://www.example.com/intelligencer/2022/04/subway-shooting-proved-regular-new-yorkers-fight-crime-too.html",
@@ -415,6 +629,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "Arts and Letters Daily",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"https://www.example.com/2022/05/05/books/carlo-rovelli-physicist-book.html": {
 						LinkURL: "https://www.example.com/2022/05/05/books/carlo-rovelli-physicist-book.html",
@@ -442,6 +657,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "Music Review Site",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"https://www.example.com/reviews/albums/100-gecs-snake-eyes-ep/": LinkItem{
 						LinkURL: "https://www.example.com/reviews/albums/100-gecs-snake-eyes-ep/",
@@ -466,6 +682,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -492,6 +709,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/stories/hot-take": {
 						LinkURL: "http://www.example.com/stories/hot-take",
@@ -512,6 +730,141 @@ func TestNewSet(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "URL-only config with multiple links per container",
+			source: mustReadFile(path.Join("testdata", "multi-link-cards.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take! Everyone is talking about it.",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes. Here is what you need to know.",
+					},
+					"http://www.example.com/stories/really-true": {
+						LinkURL: "http://www.example.com/stories/really-true",
+						Caption: "Is this supposition really true? We looked into it for you.",
+					},
+				},
+			},
+		},
+		{
+			name:   "URL-only config with off-site links",
+			source: mustReadFile(path.Join("testdata", "offsite-links.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://blog.example.com/stories/really-true": {
+						LinkURL: "http://blog.example.com/stories/really-true",
+						Caption: "Is this supposition really true?",
+					},
+				},
+			},
+		},
+		{
+			name:   "URL-only config with allowed off-site domains",
+			source: mustReadFile(path.Join("testdata", "offsite-links.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+				AllowedDomains:     []string{"www.example.com", "facebook.com"},
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://www.facebook.com/example": {
+						LinkURL: "http://www.facebook.com/example",
+						Caption: "Like us on Facebook for updates.",
+					},
+				},
+			},
+		},
+		{
+			name:   "URL-only config with same-origin links only",
+			source: mustReadFile(path.Join("testdata", "offsite-links.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+				SameOriginOnly:     true,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+				},
+			},
+		},
+		{
+			name:   "link selector with blocked domains",
+			source: mustReadFile(path.Join("testdata", "offsite-links.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				LinkSelector:       css.MustCompile("li a"),
+				ShortElementFilter: 3,
+				BlockedDomains:     []string{"example.net", "twitter.com", "blog.example.com"},
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+					"http://www.facebook.com/example": {
+						LinkURL: "http://www.facebook.com/example",
+						Caption: "Like us on Facebook for updates.",
+					},
+				},
+			},
+		},
 		{
 			name:   "autodetect in URL-only mode: NY magazine intelligencer",
 			source: mustReadFile(path.Join("testdata", "intelligencer-feed.html"), t),
@@ -523,6 +876,7 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "Intelligencer",
+				url:  mustParseURL("http://www.example.com"),
 				items: map[string]LinkItem{
 					"http://www.example.com/intelligencer/2022/04/subway-shooting-proved-regular-new-yorkers-fight-crime-too.html": {
 						LinkURL: "http://www.example.com/intelligencer/2022/04/subway-shooting-proved-regular-new-yorkers-fight-crime-too.html",
@@ -551,72 +905,310 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "My RSS 2.0 Feed",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"https://www.example.com/press-release/louisiana-students-to-hear-from-nasa-astronauts-aboard-space-station": {
 						LinkURL: "https://www.example.com/press-release/louisiana-students-to-hear-from-nasa-astronauts-aboard-space-station",
 						Caption: "Louisiana Students to Hear from NASA Astronauts Aboard Space Station",
+						Summary: "As part of the state's first Earth-to-space call, students from Louisiana will have an opportunity soon to hear from...",
+						// The feed parser treats EDT as UTC
+						Published: time.Date(2023, time.July, 21, 9, 4, 0, 0, time.UTC),
 					},
 					"https://www.example.com/press-release/nasa-awards-integrated-mission-operations-contract-iii": {
-						LinkURL: "https://www.example.com/press-release/nasa-awards-integrated-mission-operations-contract-iii",
-						Caption: "NASA has selected KBR Wyle Services, LLC, of Fulton, Maryland, to provide mission and flight crew operations support for the International Space Station and future human space exploration.",
+						LinkURL:   "https://www.example.com/press-release/nasa-awards-integrated-mission-operations-contract-iii",
+						Caption:   "NASA has selected KBR Wyle Services, LLC, of Fulton, Maryland, to provide mission and flight crew operations support for the International Space Station and future human space exploration.",
+						Published: time.Date(2023, time.July, 20, 15, 5, 0, 0, time.UTC),
 					},
 					"https://www.example.com/press-release/nasa-expands-options-for-spacewalking-moonwalking-suits-services": {
-						LinkURL: "https://www.example.com/press-release/nasa-expands-options-for-spacewalking-moonwalking-suits-services",
-						Caption: "NASA Expands Options for Spacewalking, Moonwalking Suits",
+						LinkURL:   "https://www.example.com/press-release/nasa-expands-options-for-spacewalking-moonwalking-suits-services",
+						Caption:   "NASA Expands Options for Spacewalking, Moonwalking Suits",
+						Summary:   "NASA has awarded Axiom Space and Collins Aerospace task orders under existing contracts to advance spacewalking capabilities in low Earth...",
+						Published: time.Date(2023, time.July, 10, 14, 14, 0, 0, time.UTC),
 					},
 				},
 			},
 		},
 		{
-			name:   "atom feed",
-			source: mustReadFile(path.Join("testdata", "atom-feed.xml"), t),
+			name:        "feed with a late opening tag served as application/rss+xml",
+			source:      mustReadFile(path.Join("testdata", "rss-late-tag.xml"), t),
+			contentType: "application/rss+xml; charset=utf-8",
 			conf: Config{
-				Name:               "Atom Feed",
+				Name:               "Late Tag Feed",
 				URL:                mustParseURL("https://www.example.com"),
 				MaxItems:           3,
 				ShortElementFilter: 3,
 			},
 			want: Set{
-				Name: "Atom Feed",
+				Name: "Late Tag Feed",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
-					"http://example.com/2003/12/13/atom01": {
-						LinkURL: "http://example.com/2003/12/13/atom01",
-						Caption: "Example 1",
+					"https://www.example.com/stories/first": {
+						LinkURL: "https://www.example.com/stories/first",
+						Caption: "The first story",
 					},
-					"http://example.com/2003/12/13/atom02": {
-						LinkURL: "http://example.com/2003/12/13/atom02",
-						Caption: "Example 2",
-					},
-					"http://example.com/2003/12/13/atom03": {
-						LinkURL: "http://example.com/2003/12/13/atom03",
-						Caption: "Example 3",
+					"https://www.example.com/stories/second": {
+						LinkURL: "https://www.example.com/stories/second",
+						Caption: "The second story",
 					},
 				},
 			},
 		},
 		{
-			name:   "rss 0.91 feed",
-			source: mustReadFile(path.Join("testdata", "rss-0.91.xml"), t),
+			name:   "feed with a late opening tag and no content type",
+			source: mustReadFile(path.Join("testdata", "rss-late-tag.xml"), t),
 			conf: Config{
-				Name:               "RSS 0.91",
-				URL:                mustParseURL("https://example.com"),
+				Name:               "Late Tag Feed",
+				URL:                mustParseURL("https://www.example.com"),
 				MaxItems:           3,
 				ShortElementFilter: 3,
 			},
 			want: Set{
-				Name: "RSS 0.91",
-				items: map[string]LinkItem{
-					"http://example.com/read.php?item=24": {
-						LinkURL: "http://example.com/read.php?item=24",
-						Caption: "Giving the world a pluggable Gnutella",
-					},
-					"http://example.com/read.php?item=23": {
-						LinkURL: "http://example.com/read.php?item=23",
+				Name:     "Late Tag Feed",
+				url:      mustParseURL("https://www.example.com"),
+				items:    map[string]LinkItem{},
+				messages: []string{"could not detect a format for the page"},
+			},
+		},
+		{
+			name:   "feed mode with a late opening tag and no content type",
+			source: mustReadFile(path.Join("testdata", "rss-late-tag.xml"), t),
+			conf: Config{
+				Name:               "Late Tag Feed",
+				URL:                mustParseURL("https://www.example.com"),
+				Mode:               ModeFeed,
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "Late Tag Feed",
+				url:  mustParseURL("https://www.example.com"),
+				items: map[string]LinkItem{
+					"https://www.example.com/stories/first": {
+						LinkURL: "https://www.example.com/stories/first",
+						Caption: "The first story",
+					},
+					"https://www.example.com/stories/second": {
+						LinkURL: "https://www.example.com/stories/second",
+						Caption: "The second story",
+					},
+				},
+			},
+		},
+		{
+			name:   "JavaScript-rendered page with autodetection",
+			source: mustReadFile(path.Join("testdata", "js-rendered.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name:     "My Cool Publication",
+				url:      mustParseURL("http://www.example.com"),
+				items:    map[string]LinkItem{},
+				messages: []string{jsRenderedMessage},
+			},
+		},
+		{
+			name:   "JavaScript-rendered page with selectors",
+			source: mustReadFile(path.Join("testdata", "js-rendered.html"), t),
+			conf: Config{
+				Name:            "My Cool Publication",
+				URL:             mustParseURL("http://www.example.com"),
+				ItemSelector:    css.MustCompile("body div#mostRead ol li"),
+				CaptionSelector: css.MustCompile("div a.itemName"),
+				LinkSelector:    css.MustCompile("div a.itemName"),
+			},
+			want: Set{
+				Name:     "My Cool Publication",
+				url:      mustParseURL("http://www.example.com"),
+				items:    map[string]LinkItem{},
+				messages: []string{jsRenderedMessage},
+			},
+		},
+		{
+			name:        "PDF content type",
+			source:      strings.NewReader("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj"),
+			contentType: "application/pdf",
+			conf: Config{
+				Name:            "My Cool Publication",
+				URL:             mustParseURL("http://www.example.com/report.pdf"),
+				ItemSelector:    css.MustCompile("body div#mostRead ol li"),
+				CaptionSelector: css.MustCompile("div a.itemName"),
+				LinkSelector:    css.MustCompile("div a.itemName"),
+			},
+			want: Set{
+				Name:     "My Cool Publication",
+				url:      mustParseURL("http://www.example.com/report.pdf"),
+				items:    map[string]LinkItem{},
+				messages: []string{"This URL returned non-HTML content: application/pdf"},
+			},
+		},
+		{
+			name:        "PDF with a generic content type",
+			source:      strings.NewReader("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj"),
+			contentType: "application/octet-stream",
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com/report.pdf"),
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name:     "My Cool Publication",
+				url:      mustParseURL("http://www.example.com/report.pdf"),
+				items:    map[string]LinkItem{},
+				messages: []string{"This URL returned non-HTML content: application/octet-stream"},
+			},
+		},
+		{
+			name:        "HTML with a generic content type",
+			source:      mustReadFile(path.Join("testdata", "late-doctype.html"), t),
+			contentType: "application/octet-stream",
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+				},
+			},
+		},
+		{
+			name:   "PDF with no content type",
+			source: strings.NewReader("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj"),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com/report.pdf"),
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name:     "My Cool Publication",
+				url:      mustParseURL("http://www.example.com/report.pdf"),
+				items:    map[string]LinkItem{},
+				messages: []string{"This URL returned non-HTML content: application/pdf"},
+			},
+		},
+		{
+			name:   "RSS feed with a byte order mark",
+			source: mustReadFile(path.Join("testdata", "rss-bom.xml"), t),
+			conf: Config{
+				Name:               "BOM Feed",
+				URL:                mustParseURL("https://www.example.com"),
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "BOM Feed",
+				url:  mustParseURL("https://www.example.com"),
+				items: map[string]LinkItem{
+					"https://www.example.com/stories/first": {
+						LinkURL: "https://www.example.com/stories/first",
+						Caption: "The first story",
+					},
+					"https://www.example.com/stories/second": {
+						LinkURL: "https://www.example.com/stories/second",
+						Caption: "The second story",
+					},
+				},
+			},
+		},
+		{
+			name:   "HTML with the doctype after the first line",
+			source: mustReadFile(path.Join("testdata", "late-doctype.html"), t),
+			conf: Config{
+				Name:               "My Cool Publication",
+				URL:                mustParseURL("http://www.example.com"),
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "My Cool Publication",
+				url:  mustParseURL("http://www.example.com"),
+				items: map[string]LinkItem{
+					"http://www.example.com/stories/hot-take": {
+						LinkURL: "http://www.example.com/stories/hot-take",
+						Caption: "This is a hot take!",
+					},
+					"http://www.example.com/stories/stuff-happened": {
+						LinkURL: "http://www.example.com/stories/stuff-happened",
+						Caption: "Stuff happened today, yikes.",
+					},
+				},
+			},
+		},
+		{
+			name:   "atom feed",
+			source: mustReadFile(path.Join("testdata", "atom-feed.xml"), t),
+			conf: Config{
+				Name:               "Atom Feed",
+				URL:                mustParseURL("https://www.example.com"),
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "Atom Feed",
+				url:  mustParseURL("https://www.example.com"),
+				items: map[string]LinkItem{
+					"http://example.com/2003/12/13/atom01": {
+						LinkURL:   "http://example.com/2003/12/13/atom01",
+						Caption:   "Example 1",
+						Summary:   "Some text.",
+						Published: time.Date(2003, time.December, 13, 18, 30, 2, 0, time.UTC),
+					},
+					"http://example.com/2003/12/13/atom02": {
+						LinkURL:   "http://example.com/2003/12/13/atom02",
+						Caption:   "Example 2",
+						Summary:   "Some text.",
+						Published: time.Date(2003, time.December, 13, 18, 30, 2, 0, time.UTC),
+					},
+					"http://example.com/2003/12/13/atom03": {
+						LinkURL:   "http://example.com/2003/12/13/atom03",
+						Caption:   "Example 3",
+						Summary:   "Some text.",
+						Published: time.Date(2003, time.December, 13, 18, 30, 2, 0, time.UTC),
+					},
+				},
+			},
+		},
+		{
+			name:   "rss 0.91 feed",
+			source: mustReadFile(path.Join("testdata", "rss-0.91.xml"), t),
+			conf: Config{
+				Name:               "RSS 0.91",
+				URL:                mustParseURL("https://example.com"),
+				MaxItems:           3,
+				ShortElementFilter: 3,
+			},
+			want: Set{
+				Name: "RSS 0.91",
+				url:  mustParseURL("https://example.com"),
+				items: map[string]LinkItem{
+					"http://example.com/read.php?item=24": {
+						LinkURL: "http://example.com/read.php?item=24",
+						Caption: "Giving the world a pluggable Gnutella",
+						Summary: "WorldOS is a framework on which to build programs that work like Freenet or Gnutella -allowing distributed applications using peer-to-peer...",
+					},
+					"http://example.com/read.php?item=23": {
+						LinkURL: "http://example.com/read.php?item=23",
 						Caption: "Syndication discussions hot up",
+						Summary: "After a period of dormancy, the Syndication mailing list has become active again, with contributions from leaders in traditional media...",
 					},
 					"http://example.com/read.php?item=22": {
 						LinkURL: "http://example.com/read.php?item=22",
 						Caption: "Personal web server integrates file sharing and messaging",
+						Summary: "The Magi Project is an innovative project to create a combined personal web server and messaging system that enables the...",
 					},
 				},
 			},
@@ -632,18 +1224,22 @@ func TestNewSet(t *testing.T) {
 			},
 			want: Set{
 				Name: "RSS 0.92",
+				url:  mustParseURL("https://winnemac.example.com"),
 				items: map[string]LinkItem{
 					"https://winnemac.example.com/story/151": {
 						LinkURL: "https://winnemac.example.com/story/151",
 						Caption: "Cats and Dogs Form Unlikely Friendship",
+						Summary: "In a heartwarming turn of events, a cat and a dog were spotted playing together in the park, proving that...",
 					},
 					"https://winnemac.example.com/story/150": {
 						LinkURL: "https://winnemac.example.com/story/150",
 						Caption: "Local Artist's Painting Sells for Record Price",
+						Summary: "A painting by a local artist recently sold at an auction for a staggering amount, setting a new record in...",
 					},
 					"https://winnemac.example.com/story/149": {
 						LinkURL: "https://winnemac.example.com/story/149",
 						Caption: "New Movie Breaks Box Office Records",
+						Summary: "The latest blockbuster movie has shattered box office records, becoming the highest-grossing film of all time. Moviegoers can't get...",
 					},
 				},
 			},
@@ -652,12 +1248,125 @@ func TestNewSet(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := context.Background()
-			got := NewSet(ctx, tt.source, tt.conf, tt.code)
+			got := NewSet(ctx, tt.source, tt.conf, tt.code, tt.contentType)
+			// The time it takes to parse the document varies
+			// between runs
+			got.parseDuration = 0
+			// The order of link items has its own test
+			got.order = nil
 			assert.Equal(t, tt.want, got)
 		})
 	}
 }
 
+func TestNewSetWithExpectMinItems(t *testing.T) {
+	testCases := []struct {
+		description    string
+		expectMinItems uint
+		expected       []string
+	}{
+		{
+			description: "not set",
+			expected:    nil,
+		},
+		{
+			description:    "at the minimum",
+			expectMinItems: 3,
+			expected:       nil,
+		},
+		{
+			description:    "below the minimum",
+			expectMinItems: 10,
+			expected: []string{
+				"We found 3 link items, but expected at least 10. The site may have changed, so check the selectors for this link source.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				mustReadFile(path.Join("testdata", "straightforward.html"), t),
+				Config{
+					Name:               "My Cool Publication",
+					URL:                mustParseURL("http://www.example.com"),
+					ItemSelector:       css.MustCompile("body div#mostRead ol li"),
+					CaptionSelector:    css.MustCompile("div a.itemName"),
+					LinkSelector:       css.MustCompile("div a.itemName"),
+					ShortElementFilter: 3,
+					ExpectMinItems:     tc.expectMinItems,
+				},
+				200,
+				"text/html",
+			)
+			assert.Equal(t, tc.expected, s.Messages())
+		})
+	}
+}
+
+func TestNewSetWithStatusMessages(t *testing.T) {
+	testCases := []struct {
+		description    string
+		code           int
+		statusMessages map[int]string
+		expected       []string
+	}{
+		{
+			description: "default 429 message",
+			code:        429,
+			expected: []string{
+				"We were rate limited. You should change your configuration to check this site less frequently.",
+			},
+		},
+		{
+			description: "custom 429 message",
+			code:        429,
+			statusMessages: map[int]string{
+				429: "Nous avons été limités. Vérifiez ce site moins souvent.",
+			},
+			expected: []string{
+				"Nous avons été limités. Vérifiez ce site moins souvent.",
+			},
+		},
+		{
+			description: "suppressed 429 message",
+			code:        429,
+			statusMessages: map[int]string{
+				429: "",
+			},
+			expected: nil,
+		},
+		{
+			description: "custom message for a code without a default",
+			code:        503,
+			statusMessages: map[int]string{
+				503: "This site is often down for maintenance.",
+			},
+			expected: []string{
+				"This site is often down for maintenance.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				strings.NewReader("<html><body></body></html>"),
+				Config{
+					Name:           "My Cool Publication",
+					URL:            mustParseURL("http://www.example.com"),
+					StatusMessages: tc.statusMessages,
+				},
+				tc.code,
+				"",
+			)
+			assert.Equal(t, tc.expected, s.Messages())
+		})
+	}
+}
+
 func TestNewSetWithMaxLinks(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -708,7 +1417,7 @@ func TestNewSetWithMaxLinks(t *testing.T) {
 				context.Background(),
 				mustReadFile(path.Join("testdata", "straightforward.html"),
 					t,
-				), tt.conf, tt.code)
+				), tt.conf, tt.code, "")
 			if len(got.items) != tt.wantSetLength {
 				t.Errorf("wanted a Set with %v links but got %v", tt.wantSetLength, got)
 			}
@@ -716,6 +1425,100 @@ func TestNewSetWithMaxLinks(t *testing.T) {
 	}
 }
 
+// Make sure that, in URL-only mode, we keep the same link items between runs
+// when we have to enforce the item limit.
+func TestNewSetWithMaxLinksIsStable(t *testing.T) {
+	conf := Config{
+		Name:               "Intelligencer",
+		URL:                mustParseURL("http://www.example.com"),
+		MaxItems:           2,
+		ShortElementFilter: 3,
+	}
+
+	// The first link items in document order
+	want := []string{
+		"http://www.example.com/intelligencer/2022/04/subway-shooting-proved-regular-new-yorkers-fight-crime-too.html",
+		"http://www.example.com/intelligencer/2022/04/what-happened-to-paxlovid-the-covid-19-wonder-drug.html",
+	}
+
+	for i := 0; i < 20; i++ {
+		got := NewSet(
+			context.Background(),
+			mustReadFile(path.Join("testdata", "intelligencer-feed.html"), t),
+			conf,
+			0,
+			"",
+		)
+		var urls []string
+		for _, li := range got.LinkItems() {
+			urls = append(urls, li.LinkURL)
+		}
+		assert.Equal(t, want, urls, "run %v", i)
+	}
+}
+
+// Parse should extract the same link items as NewSet for a successful
+// response.
+func TestParse(t *testing.T) {
+	conf := Config{
+		Name:               "My Cool Publication",
+		URL:                mustParseURL("http://www.example.com"),
+		ItemSelector:       css.MustCompile("body div#mostRead ol li"),
+		CaptionSelector:    css.MustCompile("div a.itemName"),
+		LinkSelector:       css.MustCompile("div a.itemName"),
+		ShortElementFilter: 3,
+	}
+	p := path.Join("testdata", "straightforward.html")
+
+	want := NewSet(context.Background(), mustReadFile(p, t), conf, 200, "text/html")
+	got := Parse(context.Background(), mustReadFile(p, t), conf)
+
+	if got.ParseDuration() <= 0 {
+		t.Errorf("expected a positive parse duration but got %v", got.ParseDuration())
+	}
+	// The time it takes to parse the document varies between runs
+	want.parseDuration = 0
+	got.parseDuration = 0
+	assert.Equal(t, want, got)
+	assert.Equal(t, 3, got.CountLinkItems())
+}
+
+func TestParseDurationWithErrorStatus(t *testing.T) {
+	conf := Config{
+		Name:         "My Cool Publication",
+		URL:          mustParseURL("http://www.example.com"),
+		LinkSelector: css.MustCompile("div a.itemName"),
+	}
+
+	s := NewSet(
+		context.Background(),
+		mustReadFile(path.Join("testdata", "straightforward.html"), t),
+		conf,
+		500,
+		"text/html",
+	)
+	assert.Equal(t, time.Duration(0), s.ParseDuration())
+}
+
+func TestSetURL(t *testing.T) {
+	conf := Config{
+		Name:            "My Cool Publication",
+		URL:             mustParseURL("http://www.example.com/news"),
+		ItemSelector:    css.MustCompile("body div#mostRead ol li"),
+		CaptionSelector: css.MustCompile("div a.itemName"),
+		LinkSelector:    css.MustCompile("div a.itemName"),
+	}
+
+	s := NewSet(
+		context.Background(),
+		mustReadFile(path.Join("testdata", "straightforward.html"), t),
+		conf,
+		200,
+		"",
+	)
+	assert.Equal(t, conf.URL, s.URL())
+}
+
 func TestSetClean(t *testing.T) {
 	testCases := []struct {
 		description string
@@ -726,6 +1529,7 @@ func TestSetClean(t *testing.T) {
 			description: "already clean set",
 			input: Set{
 				Name: "My Site 1",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"item1": LinkItem{
 						LinkURL: "https://www.example.com/article1",
@@ -736,6 +1540,7 @@ func TestSetClean(t *testing.T) {
 			},
 			expected: Set{
 				Name: "My Site 1",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"item1": LinkItem{
 						LinkURL: "https://www.example.com/article1",
@@ -749,6 +1554,7 @@ func TestSetClean(t *testing.T) {
 			description: "whitespace-only caption",
 			input: Set{
 				Name: "My Site 1",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{
 					"item1": LinkItem{
 						LinkURL: "https://www.example.com/article1",
@@ -763,6 +1569,7 @@ func TestSetClean(t *testing.T) {
 			},
 			expected: Set{
 				Name: "My Site 1",
+				url:  mustParseURL("https://www.example.com"),
 				items: map[string]LinkItem{"item2": LinkItem{
 					LinkURL: "https://www.example.com/article2",
 					Caption: "Something happened today.",
@@ -844,3 +1651,394 @@ func TestRemoveItem(t *testing.T) {
 		})
 	}
 }
+
+func TestMinContainers(t *testing.T) {
+	stories := []string{
+		"http://www.example.com/stories/hot-take",
+		"http://www.example.com/stories/stuff-happened",
+		"http://www.example.com/storiesreally-true",
+	}
+
+	testCases := []struct {
+		description   string
+		minContainers int
+		expectedURLs  []string
+		expectedMsgs  []string
+	}{
+		{
+			// The lone link's container is the body, so we can't
+			// extract a caption for it
+			description:   "no minimum",
+			minContainers: 0,
+			expectedURLs:  stories,
+			expectedMsgs: []string{
+				"cannot extract a caption from an HTML body element",
+			},
+		},
+		{
+			description:   "minimum excludes the lone navigation link",
+			minContainers: 3,
+			expectedURLs:  stories,
+			expectedMsgs: []string{
+				"We left out 1 groups of links with fewer than 3 repeating containers.",
+			},
+		},
+		{
+			description:   "minimum excludes every group",
+			minContainers: 4,
+			expectedURLs:  []string{},
+			expectedMsgs: []string{
+				"We left out 2 groups of links with fewer than 4 repeating containers.",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				mustReadFile(path.Join("testdata", "sparse-links.html"), t),
+				Config{
+					Name:               "My Cool Publication",
+					URL:                mustParseURL("http://www.example.com"),
+					ShortElementFilter: 3,
+					MinContainers:      tc.minContainers,
+				},
+				200,
+				"",
+			)
+
+			var u []string
+			for _, li := range s.LinkItems() {
+				u = append(u, li.LinkURL)
+			}
+			assert.ElementsMatch(t, tc.expectedURLs, u)
+			assert.Equal(t, tc.expectedMsgs, s.Messages())
+		})
+	}
+}
+
+func TestNonWebLinks(t *testing.T) {
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>My Cool Publication</title>
+<link>http://www.example.com</link>
+<item><title>This is a hot take!</title><link>http://www.example.com/stories/hot-take</link></item>
+<item><title>Stuff happened today, yikes.</title><link>http://www.example.com/stories/stuff-happened</link></item>
+<item><title>Load more stories like these</title><link>javascript:void(0)</link></item>
+<item><title>Read this story in your browser</title><link>data:text/html;base64,PHA+aGk8L3A+</link></item>
+</channel>
+</rss>`
+
+	testCases := []struct {
+		description  string
+		source       func(t *testing.T) io.Reader
+		linkSelector css.Selector
+	}{
+		{
+			description: "autodetected links",
+			source: func(t *testing.T) io.Reader {
+				return mustReadFile(path.Join("testdata", "javascript-links.html"), t)
+			},
+		},
+		{
+			description: "link selector",
+			source: func(t *testing.T) io.Reader {
+				return mustReadFile(path.Join("testdata", "javascript-links.html"), t)
+			},
+			linkSelector: css.MustCompile("li a"),
+		},
+		{
+			description: "feed",
+			source: func(t *testing.T) io.Reader {
+				return strings.NewReader(feed)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				tc.source(t),
+				Config{
+					Name:               "My Cool Publication",
+					URL:                mustParseURL("http://www.example.com"),
+					ShortElementFilter: 3,
+					LinkSelector:       tc.linkSelector,
+				},
+				200,
+				"",
+			)
+
+			var u []string
+			for _, li := range s.LinkItems() {
+				u = append(u, li.LinkURL)
+			}
+			assert.ElementsMatch(t, []string{
+				"http://www.example.com/stories/hot-take",
+				"http://www.example.com/stories/stuff-happened",
+			}, u)
+			assert.Equal(t, []string{
+				"We left out 2 links that don't use HTTP or HTTPS, e.g., javascript: links.",
+			}, s.Messages())
+		})
+	}
+}
+
+func TestSummaries(t *testing.T) {
+	page := `<!DOCTYPE html>
+<html>
+<body>
+<ul>
+  <li><h2>The first story</h2><p>What happened   first</p><a href="/stories/first">Read more</a></li>
+  <li><h2>The second story</h2><a href="/stories/second">Read more</a></li>
+</ul>
+</body>
+</html>`
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0">
+<channel>
+<title>My Cool Publication</title>
+<link>http://www.example.com</link>
+<item><title>The first story</title><link>http://www.example.com/stories/first</link><description><![CDATA[<p>What happened <em>first</em></p>]]></description></item>
+<item><title>The second story</title><link>http://www.example.com/stories/second</link></item>
+</channel>
+</rss>`
+
+	testCases := []struct {
+		description string
+		source      string
+		conf        Config
+	}{
+		{
+			description: "summary selector",
+			source:      page,
+			conf: Config{
+				ItemSelector:    css.MustCompile("ul li"),
+				CaptionSelector: css.MustCompile("h2"),
+				LinkSelector:    css.MustCompile("a"),
+				SummarySelector: css.MustCompile("p"),
+			},
+		},
+		{
+			description: "feed descriptions",
+			source:      feed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			tc.conf.Name = "My Cool Publication"
+			tc.conf.URL = mustParseURL("http://www.example.com")
+			tc.conf.ShortElementFilter = 3
+			s := NewSet(context.Background(), strings.NewReader(tc.source), tc.conf, 200, "")
+
+			summaries := make(map[string]string)
+			for _, li := range s.LinkItems() {
+				summaries[li.Caption] = li.Summary
+			}
+			assert.Equal(t, map[string]string{
+				"The first story":  "What happened first",
+				"The second story": "",
+			}, summaries)
+		})
+	}
+}
+
+func TestCaptionFallback(t *testing.T) {
+	testCases := []struct {
+		description     string
+		captionFallback bool
+		expected        map[string]string
+	}{
+		{
+			description:     "no fallback",
+			captionFallback: false,
+			expected:        map[string]string{},
+		},
+		{
+			description:     "fallback",
+			captionFallback: true,
+			expected: map[string]string{
+				"http://www.example.com/stories/hot-take":       "A hot take in pictures",
+				"http://www.example.com/stories/stuff-happened": "Stuff happened today",
+				"http://www.example.com/stories/really-true/":   "really-true",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				mustReadFile(path.Join("testdata", "image-links.html"), t),
+				Config{
+					Name:               "My Cool Publication",
+					URL:                mustParseURL("http://www.example.com"),
+					ShortElementFilter: 3,
+					CaptionFallback:    tc.captionFallback,
+				},
+				200,
+				"",
+			)
+
+			c := make(map[string]string)
+			for _, li := range s.LinkItems() {
+				c[li.LinkURL] = li.Caption
+			}
+			assert.Equal(t, tc.expected, c)
+		})
+	}
+}
+
+func TestJSONLinkItems(t *testing.T) {
+	testCases := []struct {
+		description      string
+		itemJSONPath     *JSONPath
+		blockedDomains   []string
+		expected         map[string]string
+		expectedMessages int
+	}{
+		{
+			description:  "nested items",
+			itemJSONPath: MustCompileJSONPath("$.data.sections[*].articles[*]"),
+			expected: map[string]string{
+				"https://www.example.com/politics/budget":  "The senate passed the budget after a late-night vote",
+				"https://www.example.com/politics/transit": "Governors meet to discuss regional transit funding",
+				"https://ads.example.net/offers/mortgage":  "Sponsored: refinance your mortgage today",
+				"https://www.example.com/science/comet":    "Astronomers spot a comet that last passed by in 1850",
+				"https://www.example.com/science/rover":    "A rover finds ice near the lunar south pole",
+			},
+		},
+		{
+			description:    "blocked domain",
+			itemJSONPath:   MustCompileJSONPath("$.data.sections[*].articles[*]"),
+			blockedDomains: []string{"example.net"},
+			expected: map[string]string{
+				"https://www.example.com/politics/budget":  "The senate passed the budget after a late-night vote",
+				"https://www.example.com/politics/transit": "Governors meet to discuss regional transit funding",
+				"https://www.example.com/science/comet":    "Astronomers spot a comet that last passed by in 1850",
+				"https://www.example.com/science/rover":    "A rover finds ice near the lunar south pole",
+			},
+		},
+		{
+			description:  "recursive descent",
+			itemJSONPath: MustCompileJSONPath("$..articles[0]"),
+			expected: map[string]string{
+				"https://www.example.com/politics/budget": "The senate passed the budget after a late-night vote",
+				"https://www.example.com/science/comet":   "Astronomers spot a comet that last passed by in 1850",
+			},
+		},
+		{
+			description:      "no matches",
+			itemJSONPath:     MustCompileJSONPath("$.data.stories[*]"),
+			expected:         map[string]string{},
+			expectedMessages: 1,
+		},
+		{
+			description:      "no item JSONPath",
+			expected:         map[string]string{},
+			expectedMessages: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				mustReadFile(path.Join("testdata", "api-response.json"), t),
+				Config{
+					Name:            "My Cool Publication",
+					URL:             mustParseURL("https://www.example.com"),
+					MaxItems:        10,
+					BlockedDomains:  tc.blockedDomains,
+					ItemJSONPath:    tc.itemJSONPath,
+					CaptionJSONPath: MustCompileJSONPath("$.headline.text"),
+					LinkJSONPath:    MustCompileJSONPath("@.links.canonical"),
+				},
+				200,
+				"application/json",
+			)
+
+			c := make(map[string]string)
+			for _, li := range s.LinkItems() {
+				c[li.LinkURL] = li.Caption
+			}
+			assert.Equal(t, tc.expected, c)
+			assert.Len(t, s.Messages(), tc.expectedMessages)
+		})
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	testCases := []struct {
+		description      string
+		language         string
+		expected         []string
+		expectedMessages int
+	}{
+		{
+			description: "no language filter",
+			expected: []string{
+				"http://www.example.com/en/budget",
+				"http://www.example.com/en/transit",
+				"http://www.example.com/es/presupuesto",
+				"http://www.example.com/fr/budget",
+				"http://www.example.com/ja/yosan",
+			},
+		},
+		{
+			description: "English",
+			language:    "en",
+			expected: []string{
+				"http://www.example.com/en/budget",
+				"http://www.example.com/en/transit",
+			},
+			expectedMessages: 1,
+		},
+		{
+			description: "Spanish",
+			language:    "es",
+			expected: []string{
+				"http://www.example.com/es/presupuesto",
+			},
+			expectedMessages: 1,
+		},
+		{
+			description: "Japanese",
+			language:    "ja",
+			expected: []string{
+				"http://www.example.com/ja/yosan",
+			},
+			expectedMessages: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			s := NewSet(
+				context.Background(),
+				mustReadFile(path.Join("testdata", "mixed-languages.html"), t),
+				Config{
+					Name:               "My Cool Publication",
+					URL:                mustParseURL("http://www.example.com"),
+					MaxItems:           10,
+					ShortElementFilter: 3,
+					Language:           tc.language,
+				},
+				200,
+				"",
+			)
+
+			var u []string
+			for _, li := range s.LinkItems() {
+				u = append(u, li.LinkURL)
+			}
+			sort.Strings(u)
+			assert.Equal(t, tc.expected, u)
+			assert.Len(t, s.Messages(), tc.expectedMessages)
+		})
+	}
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/ptgott/one-newsletter/scrape"
+	"github.com/ptgott/one-newsletter/serve"
 	"github.com/ptgott/one-newsletter/userconfig"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func main() {
@@ -23,9 +28,16 @@ func main() {
 	// One goroutine listens exclusively for interrupts so we can
 	// handle them before the main application loop in case of
 	// setup issues.
+	//
+	// The first interrupt stops the scraper after it finishes its current
+	// scrape cycle. A second interrupt exits right away.
+	ctx, cancel := context.WithCancel(context.Background())
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt)
 	go func(c chan os.Signal) {
+		<-sigCh
+		log.Info().Msg("interrupt: stopping after the current scrape")
+		cancel()
 		<-sigCh
 		log.Info().Msg("interrupt: exiting")
 		os.Exit(0)
@@ -34,18 +46,68 @@ func main() {
 	configPath := flag.String(
 		"config",
 		"./config.yaml",
-		"Path to a JSON or YAML file containing your configuration.",
+		"Path to a JSON or YAML file containing your configuration, or to a directory of YAML files to combine.",
 	)
 	testMode := flag.Bool(
 		"test",
 		false,
 		"Print the HTML body of a single email to stdout and exit without sending it to test a configuration locally. Does not require an SMTP configuration or database.",
 	)
+	preview := flag.Bool(
+		"preview",
+		false,
+		"With -test, write the HTML body of the email to a temporary file and print its path instead of printing the HTML.",
+	)
 	oneOff := flag.Bool(
 		"oneoff",
 		false,
 		"Run the scrapers and send a single email. Used for testing a live One Newsletter deployment. Does not touch the database.",
 	)
+	readOnly := flag.Bool(
+		"readonly",
+		false,
+		"Check link items against the database without writing to it, e.g., to see which items would be new in a staging environment.",
+	)
+	testTo := flag.String(
+		"testto",
+		"",
+		"Send a single email to this address instead of the configured toAddress and exit, e.g., to check a real send through your SMTP server before going live. Implies -oneoff, so it doesn't touch the database.",
+	)
+	replay := flag.Bool(
+		"replay",
+		false,
+		"Read each link source's page from the pageCacheDir configured in the scraping section instead of the network, e.g., to reproduce an extraction problem offline.",
+	)
+	extractFile := flag.String(
+		"extractfile",
+		"",
+		"Path to a saved HTML document or feed. Print the link items extracted from it and exit. Use with -url and, optionally, -selector. Does not require a configuration file.",
+	)
+	extractURL := flag.String(
+		"url",
+		"",
+		"With -extractfile, the URL of the saved page, used to resolve relative links.",
+	)
+	extractSelector := flag.String(
+		"selector",
+		"",
+		"With -extractfile, a CSS selector for the links on the page. If this is blank, links are detected automatically.",
+	)
+	debug := flag.Bool(
+		"debug",
+		false,
+		"Expose debugging endpoints, such as /preview, on the address configured in scraping.serveAddr.",
+	)
+	printConfig := flag.Bool(
+		"printconfig",
+		false,
+		"Print the configuration as One Newsletter sees it, with defaults applied and secrets redacted, then exit.",
+	)
+	output := flag.String(
+		"output",
+		"text",
+		`With -test or -printconfig, how to report the result: "text" or "json". With "json", print only {"ok": true|false, "errors": [...]} to stdout, e.g., for a CI pipeline, and exit with status 1 if there are errors. -printconfig then checks the config without printing it.`,
+	)
 	level := flag.String(
 		"level",
 		"",
@@ -53,6 +115,12 @@ func main() {
 	)
 	flag.Parse()
 
+	jsonOutput := *output == "json"
+	if *output != "text" && !jsonOutput {
+		log.Error().Str("output", *output).Msg(`the -output flag must be "text" or "json"`)
+		os.Exit(1)
+	}
+
 	switch *level {
 	case "debug":
 		log.Logger = log.Logger.Level(zerolog.DebugLevel)
@@ -65,55 +133,145 @@ func main() {
 	default:
 		// Disable logging in test mode unless the user provides the
 		// "level" flag.
-		if *testMode {
+		if *testMode || jsonOutput {
 			log.Logger = log.Logger.Level(zerolog.Disabled)
+		} else if *extractFile != "" || *printConfig {
+			// Keep the output readable but still show problems
+			// with the flags.
+			log.Logger = log.Logger.Level(zerolog.ErrorLevel)
 		} else {
 			log.Logger = log.Logger.Level(zerolog.InfoLevel)
 		}
 	}
 
+	if *extractFile != "" {
+		c, err := scrape.NewExtractConfig(*extractURL, *extractSelector)
+		if err != nil {
+			log.Error().Err(err).Msg("Problem with the -url or -selector flag")
+			os.Exit(1)
+		}
+		if err := scrape.ExtractFile(os.Stdout, *extractFile, c); err != nil {
+			log.Error().Err(err).Msg("Problem extracting link items")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if jsonOutput && !*testMode && !*printConfig {
+		log.Warn().Msg("the -output flag has no effect without the -test or -printconfig flag")
+	}
+
+	// With -output json, report a problem as a CheckResult on stdout
+	// instead of in the logs so that pipelines can parse it.
+	fail := func(err error, msg string) {
+		if jsonOutput {
+			scrape.NewCheckResult(fmt.Errorf("%v: %v", msg, err)).WriteJSON(os.Stdout)
+		} else {
+			log.Error().Err(err).Msg(msg)
+		}
+		os.Exit(1)
+	}
+
 	log.Info().
 		Str("configPath", *configPath).
 		Msg("starting the application")
 
-	f, err := os.Open(*configPath)
+	fi, err := os.Stat(*configPath)
 
 	if err != nil {
-		log.Error().
-			Str("config-path", *configPath).
-			Err(err).
-			Msg("We can't open the application config file")
-		os.Exit(1)
+		fail(err, "We can't open the application config file")
 	}
 
-	config, err := userconfig.Parse(f)
+	var config *userconfig.Meta
+	if fi.IsDir() {
+		config, err = userconfig.ParseDir(*configPath)
+	} else {
+		var f *os.File
+		f, err = os.Open(*configPath)
+		if err != nil {
+			fail(err, "We can't open the application config file")
+		}
+		config, err = userconfig.Parse(f)
+		f.Close()
+	}
 
 	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("Problem parsing your config")
-		os.Exit(1)
+		fail(err, "Problem parsing your config")
 	}
 	config.Scraping.OneOff = *oneOff
 	config.Scraping.TestMode = *testMode
+	config.Scraping.Preview = *preview
+	config.Scraping.ReadOnly = *readOnly
+	config.Scraping.Replay = *replay
+	config.EmailSettings.TestToAddress = *testTo
+	config.Scraping.Debug = *debug
+
+	if *preview && !*testMode {
+		log.Warn().Msg("the -preview flag has no effect without the -test flag")
+	}
 
 	checkedConfig, err := config.CheckAndSetDefaults()
 	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("Problem validating your config")
-		os.Exit(1)
+		fail(err, "Problem validating your config")
 	}
 
 	log.Info().Str("configPath", *configPath).Msg("successfully validated the config")
 
+	if *printConfig && jsonOutput {
+		scrape.NewCheckResult().WriteJSON(os.Stdout)
+		return
+	}
+
+	if *printConfig {
+		if err := yaml.NewEncoder(os.Stdout).Encode(checkedConfig.Redacted()); err != nil {
+			log.Error().Err(err).Msg("Problem printing your config")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if checkedConfig.Scraping.ServeAddr != "" {
+		go func(c serve.Config) {
+			log.Info().Str("address", c.Address).Msg("starting the HTTP server")
+			if err := serve.ListenAndServe(c); err != nil {
+				log.Error().Err(err).Msg("the HTTP server stopped")
+			}
+		}(serve.Config{
+			Address:  checkedConfig.Scraping.ServeAddr,
+			Debug:    checkedConfig.Scraping.Debug,
+			CertFile: checkedConfig.Scraping.ServeCertFile,
+			KeyFile:  checkedConfig.Scraping.ServeKeyFile,
+			Username: checkedConfig.Scraping.ServeUsername,
+			Password: checkedConfig.Scraping.ServePassword,
+		})
+	} else if checkedConfig.Scraping.Debug {
+		log.Warn().Msg("the -debug flag has no effect unless scraping.serveAddr is set")
+	}
+
 	scrapeCadence := time.NewTicker(config.Scraping.Interval)
 	scrapeConfig := scrape.Config{
 		TickCh:   scrapeCadence.C,
 		OutputWr: os.Stdout, // write to stdout if the -no-email flag is given
 	}
+	// Keep stdout parseable
+	if jsonOutput && *testMode {
+		scrapeConfig.OutputWr = io.Discard
+	}
 
-	if err := scrape.StartLoop(&scrapeConfig, &checkedConfig); err != nil {
+	err = scrape.StartLoop(ctx, &scrapeConfig, &checkedConfig)
+	if jsonOutput && *testMode {
+		if err != nil {
+			fail(err, "error gathering links to email")
+		}
+		st, _ := scrapeConfig.Status()
+		r := scrape.NewRunCheckResult(st)
+		r.WriteJSON(os.Stdout)
+		if !r.OK {
+			os.Exit(1)
+		}
+		return
+	}
+	if err != nil {
 		log.Error().Err(err).Msg("error gathering links to email")
 	}
 }
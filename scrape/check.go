@@ -0,0 +1,65 @@
+package scrape
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CheckResult is the outcome of checking a configuration with the -test or
+// -printconfig flag, for tools like CI pipelines that need to parse it
+type CheckResult struct {
+	// Whether the check passed, i.e., there are no errors
+	OK bool `json:"ok"`
+	// A description of each problem we found. This is empty, not nil, if
+	// the check passed so that the JSON always includes a list.
+	Errors []string `json:"errors"`
+}
+
+// NewCheckResult returns a CheckResult for errs, ignoring nil errors
+func NewCheckResult(errs ...error) CheckResult {
+	r := CheckResult{
+		Errors: []string{},
+	}
+	for _, err := range errs {
+		if err != nil {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	}
+	r.OK = len(r.Errors) == 0
+	return r
+}
+
+// NewRunCheckResult returns a CheckResult for the scrape cycle with the
+// outcome st, e.g., from the -test flag. Besides an error from the cycle
+// itself, the check fails if every link source failed, i.e., returned no link
+// items along with messages about problems, since the config can't produce a
+// useful newsletter.
+func NewRunCheckResult(st RunStatus) CheckResult {
+	errs := []error{st.Err}
+	var failed int
+	for _, s := range st.Sources {
+		if s.Items == 0 && len(s.Messages) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 && failed == len(st.Sources) {
+		for _, s := range st.Sources {
+			errs = append(errs, fmt.Errorf("link source %v: %v", s.Name, strings.Join(s.Messages, " ")))
+		}
+	}
+	return NewCheckResult(errs...)
+}
+
+// WriteJSON writes r to w as a single line of JSON
+func (r CheckResult) WriteJSON(w io.Writer) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot encode the check result: %v", err)
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("cannot write the check result: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package scrape
+
+import (
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// downloadReader wraps a response body to measure how much of it we read
+// and how long it took, so we can tell a large page from a slow server.
+type downloadReader struct {
+	r io.Reader
+	// When we sent the request
+	start time.Time
+	// When the last read from r returned
+	end time.Time
+	// The number of bytes we've read from r
+	n int64
+}
+
+// Read implements io.Reader
+func (d *downloadReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.n += int64(n)
+	d.end = time.Now()
+	return n, err
+}
+
+// duration returns the time between sending the request and the last read
+// from the response body
+func (d *downloadReader) duration() time.Duration {
+	if d.end.IsZero() {
+		return 0
+	}
+	return d.end.Sub(d.start)
+}
+
+// logDownload logs the size and download time of the response body in d for
+// the link source called name. If warnBytes is greater than zero and we read
+// more than warnBytes bytes, it also logs a warning.
+func logDownload(name string, d *downloadReader, warnBytes uint) {
+	log.Debug().
+		Str("linkSource", name).
+		Int64("responseBytes", d.n).
+		Int64("downloadMs", d.duration().Milliseconds()).
+		Msg("downloaded the link source")
+
+	if warnBytes > 0 && d.n > int64(warnBytes) {
+		log.Warn().
+			Str("linkSource", name).
+			Int64("responseBytes", d.n).
+			Uint("warnBytes", warnBytes).
+			Msg("the link source's response is larger than expected")
+	}
+}
@@ -0,0 +1,80 @@
+package scrape
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ptgott/one-newsletter/linksrc"
+	"gopkg.in/yaml.v2"
+)
+
+// NewExtractConfig builds a link source configuration for extracting link
+// items from a saved page. u is the URL of the page, which we use to resolve
+// relative links and filter off-site links. sel is a link selector. If sel
+// is blank, we detect links automatically.
+func NewExtractConfig(u, sel string) (linksrc.Config, error) {
+	if u == "" {
+		return linksrc.Config{}, fmt.Errorf("a URL is required to extract link items")
+	}
+
+	v := map[string]string{
+		"name": "extract",
+		"url":  u,
+	}
+	if sel != "" {
+		v["linkSelector"] = sel
+	}
+
+	// Round-trip the options through YAML so they're validated the same
+	// way as a link source within the config file. Marshaling a
+	// map[string]string won't return an error.
+	b, _ := yaml.Marshal(v)
+
+	var c linksrc.Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return linksrc.Config{}, err
+	}
+
+	return c.CheckAndSetDefaults()
+}
+
+// ExtractFile extracts link items from the HTML document or feed at path p
+// using conf and writes the link items and any messages to w. It doesn't touch
+// the network, database, or SMTP server.
+func ExtractFile(w io.Writer, p string, conf linksrc.Config) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("cannot open the file to extract link items from: %v", err)
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(1)*time.Minute,
+	)
+	defer cancel()
+	s := linksrc.Parse(ctx, f, conf)
+
+	if _, err := fmt.Fprintf(w, "Link items: %v\n", s.CountLinkItems()); err != nil {
+		return err
+	}
+	for _, li := range s.LinkItems() {
+		if _, err := fmt.Fprintf(w, "- %v\n  %v\n", li.Caption, li.LinkURL); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Messages: %v\n", len(s.Messages())); err != nil {
+		return err
+	}
+	for _, m := range s.Messages() {
+		if _, err := fmt.Fprintf(w, "- %v\n", m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package scrape
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ptgott/one-newsletter/linksrc"
+)
+
+// pageMeta is what we need to know about the response for a cached page in
+// order to replay it as if it came from the network
+type pageMeta struct {
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+}
+
+// pageCachePath returns the path of the file in the page cache directory dir
+// where we store the page for the link source lc. Pages are grouped by host,
+// and the file name is a hash of the rest of the request so it's always a
+// valid file name. Requests with a method other than GET or a body include
+// these in the hash so that, e.g., two POST requests to the same URL don't
+// share a page.
+func pageCachePath(dir string, lc linksrc.Config) string {
+	k := lc.URL.RequestURI()
+	m := strings.ToUpper(lc.Method)
+	if m == "" {
+		m = http.MethodGet
+	}
+	if m != http.MethodGet || lc.Body != "" {
+		k = m + " " + k + "\n" + lc.Body
+	}
+	h := sha256.Sum256([]byte(k))
+	return filepath.Join(dir, lc.URL.Host, hex.EncodeToString(h[:])+".html")
+}
+
+// pageMetaPath returns the path of the file that stores the pageMeta for the
+// cached page at p
+func pageMetaPath(p string) string {
+	return strings.TrimSuffix(p, ".html") + ".json"
+}
+
+// cachePage reads the response body r and writes it to the page cache
+// directory dir for the link source lc, along with the response's status code
+// and Content-Type in m. It returns a Reader for the body, which the caller can
+// use in place of r. If we read the body but can't write it to the cache, we
+// return the Reader along with the error.
+func cachePage(dir string, lc linksrc.Config, m pageMeta, r io.Reader) (io.Reader, error) {
+	u := lc.URL
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the page at %v: %v", u.String(), err)
+	}
+
+	p := pageCachePath(dir, lc)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return bytes.NewReader(b), fmt.Errorf("cannot create the page cache directory: %v", err)
+	}
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		return bytes.NewReader(b), fmt.Errorf("cannot write the page at %v to the cache: %v", u.String(), err)
+	}
+	mb, err := json.Marshal(m)
+	if err != nil {
+		return bytes.NewReader(b), fmt.Errorf("cannot encode the response details for the page at %v: %v", u.String(), err)
+	}
+	if err := os.WriteFile(pageMetaPath(p), mb, 0o644); err != nil {
+		return bytes.NewReader(b), fmt.Errorf("cannot write the response details for the page at %v to the cache: %v", u.String(), err)
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// readCachedPage opens the page for the link source lc from the page cache
+// directory dir and returns it along with the details of the response we
+// cached it from. Pages cached before we stored these details get a zero
+// pageMeta, which NewSet treats as a successful response. The caller must
+// close the returned file.
+func readCachedPage(dir string, lc linksrc.Config) (*os.File, pageMeta, error) {
+	u := lc.URL
+	p := pageCachePath(dir, lc)
+	var m pageMeta
+	mb, err := os.ReadFile(pageMetaPath(p))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, m, fmt.Errorf("cannot read the response details for the page at %v from the cache: %v", u.String(), err)
+	default:
+		if err := json.Unmarshal(mb, &m); err != nil {
+			return nil, m, fmt.Errorf("cannot parse the response details for the page at %v from the cache: %v", u.String(), err)
+		}
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, m, fmt.Errorf("cannot read the page at %v from the cache: %v", u.String(), err)
+	}
+	return f, m, nil
+}
@@ -0,0 +1,149 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ptgott/one-newsletter/userconfig"
+	"github.com/rs/zerolog/log"
+)
+
+// Possible values of the send status in a run report
+const (
+	sendStatusSent    = "sent"
+	sendStatusFailed  = "failed"
+	sendStatusNotSent = "not sent"
+)
+
+// jsonSourceReport is the representation of a SourceResult in a run report
+type jsonSourceReport struct {
+	Name     string   `json:"name"`
+	Items    int      `json:"items"`
+	NewItems int      `json:"newItems"`
+	Messages []string `json:"messages"`
+	ParseMS  int64    `json:"parseMs"`
+}
+
+// jsonRunReport is the representation of a RunResult in a run report
+type jsonRunReport struct {
+	Timestamp    string             `json:"timestamp"`
+	DurationMS   int64              `json:"durationMs"`
+	Sources      []jsonSourceReport `json:"sources"`
+	SendStatus   string             `json:"sendStatus"`
+	SendError    string             `json:"sendError,omitempty"`
+	StorageError string             `json:"storageError,omitempty"`
+	Error        string             `json:"error,omitempty"`
+}
+
+// newJSONRunReport converts res, along with the error runErr returned from
+// the run, into a run report
+func newJSONRunReport(res RunResult, runErr error) jsonRunReport {
+	r := jsonRunReport{
+		Timestamp:  res.Start.UTC().Format(time.RFC3339),
+		DurationMS: res.Duration.Milliseconds(),
+		Sources:    make([]jsonSourceReport, len(res.Sources)),
+		SendStatus: sendStatusNotSent,
+	}
+
+	for i, s := range res.Sources {
+		m := s.Messages
+		if m == nil {
+			m = []string{}
+		}
+		r.Sources[i] = jsonSourceReport{
+			Name:     s.Name,
+			Items:    s.Items,
+			NewItems: s.NewItems,
+			Messages: m,
+			ParseMS:  s.ParseDuration.Milliseconds(),
+		}
+	}
+
+	switch {
+	case res.Sent:
+		r.SendStatus = sendStatusSent
+	case res.SendErr != nil:
+		r.SendStatus = sendStatusFailed
+		r.SendError = res.SendErr.Error()
+	}
+
+	if res.StorageErr != nil {
+		r.StorageError = res.StorageErr.Error()
+	}
+
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+
+	return r
+}
+
+// appendReport appends a run report for res and runErr to the file at p as
+// a single JSON line. We open the file for each report, so it's safe to rotate
+// the file between runs.
+func appendReport(p string, res RunResult, runErr error) error {
+	b, err := json.Marshal(newJSONRunReport(res, runErr))
+	if err != nil {
+		return fmt.Errorf("cannot encode the run report: %v", err)
+	}
+
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("cannot open the run report file: %v", err)
+	}
+	defer f.Close()
+
+	// Write the line in a single call so concurrent readers, e.g., tail,
+	// don't see partial lines.
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("cannot write to the run report file: %v", err)
+	}
+	return nil
+}
+
+// warnOnThresholds logs a warning if res crosses any of the alerting
+// thresholds in s, e.g., because the run didn't find any new link items.
+func warnOnThresholds(res RunResult, s userconfig.Scraping) {
+	if s.WarnIfZeroItems {
+		var n int
+		var empty []string
+		for _, r := range res.Sources {
+			n += r.NewItems
+			if r.Items == 0 {
+				empty = append(empty, r.Name)
+			}
+		}
+		if n == 0 {
+			log.Warn().
+				Strs("emptyLinkSources", empty).
+				Msg("the scrape found no new link items")
+		}
+	}
+
+	if s.WarnIfSlowerThan > 0 && res.Duration > s.WarnIfSlowerThan {
+		log.Warn().
+			Int64("durationMs", res.Duration.Milliseconds()).
+			Int64("warnMs", s.WarnIfSlowerThan.Milliseconds()).
+			Msg("the scrape took longer than expected")
+	}
+}
+
+// runAndReport calls runWithRetries with ctx, s, and c and, if the config
+// includes a report path, appends a run report to it. It also logs any
+// warnings about the run (see warnOnThresholds). Problems writing the report
+// don't stop the scraper.
+func runAndReport(ctx context.Context, s *Config, c *userconfig.Meta) error {
+	res, err := runWithRetries(ctx, s, c)
+	if err == nil {
+		warnOnThresholds(res, c.Scraping)
+	}
+	if p := c.Scraping.ReportPath; p != "" {
+		if rerr := appendReport(p, res, err); rerr != nil {
+			log.Error().Err(rerr).Msg("error writing the run report")
+		}
+	}
+	return err
+}
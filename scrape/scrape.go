@@ -1,12 +1,24 @@
 package scrape
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
+	"github.com/ptgott/one-newsletter/email"
 	"github.com/ptgott/one-newsletter/html"
 	"github.com/ptgott/one-newsletter/linksrc"
 	"github.com/ptgott/one-newsletter/storage"
@@ -24,82 +36,372 @@ type Config struct {
 	// Number of rounds of scraping and emailing to perform before stopping
 	// the scraper. Used for testing.
 	IterationLimit uint
+	// Client for sending scrape requests. It's reused across scrape cycles
+	// so we can keep connections alive between them. If this is nil, we use
+	// NewHTTPClient with the scraping config. Tests can provide a client
+	// with a custom http.RoundTripper.
+	HTTPClient *http.Client
+	// The number of requests we've chosen a User-Agent for, so we can
+	// rotate through the configured User-Agents across scrape cycles
+	userAgentCount atomic.Uint64
+	// Guards status, which RunWithResult updates after each cycle
+	statusMu sync.Mutex
+	// The outcome of the latest scrape cycle, or nil if there hasn't been
+	// one yet
+	status *RunStatus
 }
 
-// Run conducts a single scrape and email cycle and returns the first error
-// encountered. It reads the user config anew at the beginning of each cycle. At
-// the end of a scrape cycle, it sends an email or, depending on the config,
-// writes a plaintext version of the email message to outwr.
-func Run(outwr io.Writer, config *userconfig.Meta) error {
-	httpClient := http.Client{
+// RunStatus is the outcome of the latest scrape and email cycle, for programs
+// that embed the scraper and need to report on it, e.g., in a health check
+type RunStatus struct {
+	RunResult
+	// The error that the cycle returned, if any
+	Err error
+}
+
+// Status returns the outcome of the latest scrape and email cycle run with s.
+// The second return value is false if there hasn't been a cycle yet. It's
+// safe to call Status while a cycle is running.
+func (s *Config) Status() (RunStatus, bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.status == nil {
+		return RunStatus{}, false
+	}
+	return *s.status, true
+}
+
+// setStatus records the outcome of a scrape cycle for Status
+func (s *Config) setStatus(res RunResult, err error) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status = &RunStatus{RunResult: res, Err: err}
+}
+
+// nextUserAgent returns the next of uas to send to a link source, or a blank
+// string if uas is empty. We use each User-Agent in turn, in order, so the
+// choice is predictable.
+func (s *Config) nextUserAgent(uas []string) string {
+	if len(uas) == 0 {
+		return ""
+	}
+	i := s.userAgentCount.Add(1) - 1
+	return uas[i%uint64(len(uas))]
+}
+
+// NewHTTPClient returns the HTTP client that Run uses for link sources if the
+// caller doesn't provide one, with the connection settings in sc. Callers that
+// need their own client, e.g., with a custom http.RoundTripper, can start from
+// this one.
+func NewHTTPClient(sc userconfig.Scraping) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if sc.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = int(sc.MaxIdleConnsPerHost)
+		// The limit across all hosts would otherwise cap the limit for
+		// each host
+		if t.MaxIdleConns < t.MaxIdleConnsPerHost {
+			t.MaxIdleConns = t.MaxIdleConnsPerHost
+		}
+	}
+	if sc.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = sc.IdleConnTimeout
+	}
+	if sc.RootCAs != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.RootCAs = sc.RootCAs
+	}
+
+	return &http.Client{
+		Transport: t,
 		// Determined arbitrarily. We don't want to wait forever for a
 		// request to complete, but the cadence of the newsletter means
 		// that a minute of extra waiting is probably okay.
 		Timeout: time.Duration(60) * time.Second,
 	}
+}
+
+// newInsecureClient returns a copy of c that doesn't verify the TLS
+// certificates of link sources. If c uses a custom http.RoundTripper other
+// than an *http.Transport, we can't change how it verifies certificates, so
+// the copy uses it as-is.
+func newInsecureClient(c http.Client) *http.Client {
+	rt := c.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return &c
+	}
+	t = t.Clone()
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	t.TLSClientConfig.InsecureSkipVerify = true
+	c.Transport = t
+	return &c
+}
+
+// SourceResult summarizes the outcome of scraping a single link source
+type SourceResult struct {
+	// The name of the link source
+	Name string
+	// The number of link items we extracted from the link source
+	Items int
+	// The number of link items that weren't already in the database
+	NewItems int
+	// Messages about problems with the link source, e.g., error statuses
+	Messages []string
+	// How long it took to extract link items from the link source's
+	// document, not counting the download
+	ParseDuration time.Duration
+}
+
+// RunResult summarizes a single scrape and email cycle
+type RunResult struct {
+	// When the cycle began
+	Start time.Time
+	// How long the cycle took
+	Duration time.Duration
+	// One SourceResult per link source, in no particular order
+	Sources []SourceResult
+	// Whether we sent an email. This is false in test mode.
+	Sent bool
+	// The number of emails we sent. If we split the newsletter into
+	// several emails and some failed to send, this is less than the number
+	// of parts, but not zero.
+	SentParts int
+	// The error we encountered sending the email, if any
+	SendErr error
+	// The error we encountered opening the database, if we sent the email
+	// without it
+	StorageErr error
+}
+
+// retryableError is an error from a scrape cycle that might clear up if we
+// run the cycle again, e.g., because the database was briefly locked
+type retryableError struct {
+	err error
+}
+
+// Error implements error
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e retryableError) Unwrap() error {
+	return e.err
+}
+
+// Run conducts a single scrape and email cycle and returns the first error
+// encountered. It reads the user config anew at the beginning of each cycle. At
+// the end of a scrape cycle, it sends an email or, depending on the config,
+// writes a plaintext version of the email message to s.OutputWr.
+func Run(s *Config, config *userconfig.Meta) error {
+	_, err := RunWithResult(s, config)
+	return err
+}
+
+// RunWithResult is like Run, but also returns a summary of the cycle. If
+// RunWithResult returns an error, the RunResult includes whatever we
+// finished before the error.
+func RunWithResult(s *Config, config *userconfig.Meta) (res RunResult, err error) {
+	res.Start = time.Now()
+	// Deferred first so it runs last, after we know the duration
+	defer func() {
+		s.setStatus(res, err)
+	}()
+	defer func() {
+		res.Duration = time.Since(res.Start)
+	}()
+
+	hc := s.HTTPClient
+	if hc == nil {
+		hc = NewHTTPClient(config.Scraping)
+	}
+	// Give each run its own cookie jar so that cookies a link source sets,
+	// e.g., for a session, carry over to the requests that follow within
+	// this run but not to later runs. Copying the client keeps its
+	// transport, so we still reuse connections between runs.
+	httpClient := *hc
+	if httpClient.Jar == nil {
+		// cookiejar.New doesn't return an error
+		httpClient.Jar, _ = cookiejar.New(nil)
+	}
+	// Link sources that skip certificate verification need a transport of
+	// their own, so only create one if we need it.
+	insecureClient := &httpClient
+	for _, ls := range config.LinkSources {
+		if ls.InsecureSkipVerify {
+			insecureClient = newInsecureClient(httpClient)
+			break
+		}
+	}
+	outwr := s.OutputWr
 
 	var db storage.KeyValue
-	if config.Scraping.TestMode || config.Scraping.OneOff {
+	switch {
+	case config.Scraping.ReadOnly:
+		db, err = storage.NewReadOnlyDB(config.Scraping.StorageDirPath)
+		if err != nil {
+			return res, retryableError{err}
+		}
+	// Link items sent to a test address haven't reached the recipient,
+	// so don't store them
+	case config.Scraping.TestMode || config.Scraping.OneOff ||
+		config.EmailSettings.TestToAddress != "":
 		db = &storage.NoOpDB{}
-	} else {
-		var err error
+	default:
 		db, err = storage.NewBadgerDB(
 			config.Scraping.StorageDirPath,
 			time.Duration(config.Scraping.LinkExpiryDays*24)*time.Hour,
 		)
+		if err != nil && !config.Scraping.DegradeOnStorageError {
+			return res, retryableError{err}
+		}
+		// Send the email anyway, treating every link item as new,
+		// rather than skipping it until someone fixes the storage
+		// directory.
 		if err != nil {
-			return err
+			log.Error().
+				Err(err).
+				Msg("cannot open the database, so every link item in this run counts as new")
+			res.StorageErr = err
+			db = &storage.NoOpDB{}
+			err = nil
 		}
 	}
 
 	log.Info().Msg("set up the database connection successfully")
+	defer func() {
+		// Get rid of old keys just before we close
+		if err := db.Cleanup(); err != nil {
+			log.Error().Err(err).Msg("error cleaning up the database")
+		}
+		// Close the connection here so BadgerDB can flush to disk.
+		// Otherwise, BadgerDB has to reach its MaxTableSize before it
+		// flushes--we want to write the results of each scraping round
+		// to disk, and there's no need to keep the DB connection open
+		// while waiting for the next scrape.
+		//
+		// https://pkg.go.dev/github.com/dgraph-io/badger#readme-i-don-t-see-any-disk-writes-why
+		db.Close()
+		log.Info().Msg("closed the database to flush data to disk")
+	}()
+
 	log.Info().
 		Int("count", len(config.LinkSources)).
 		Msg("launching scrapers")
 	var wg sync.WaitGroup
 	d := html.NewEmailData()
+	d.SetHeading(config.Scraping.EmailHeading)
+	d.SetAppendDiagnostics(config.Scraping.AppendDiagnostics)
+	d.SetHideEmptySections(config.Scraping.HideEmptySections)
+	if res.StorageErr != nil {
+		d.AddNotice(fmt.Sprintf(
+			"One Newsletter could not open its database, so this email includes every link it found, even links it has sent before. Check the storage directory. The error was: %v",
+			res.StorageErr,
+		))
+	}
 
 	// buffer the results of the latest scrape so we can perform a diff
 	// with the previous scrape and build an email body
 	emailBuildCh := make(chan linksrc.Set, len(config.LinkSources))
 	wg.Add(len(config.LinkSources))
-	var ec chan error
+	// A link source that fails before we can parse its page, e.g.,
+	// because the site is down, gets a Set with a message about the
+	// problem. This way, one flaky site doesn't stop the newsletter.
 	for _, ls := range config.LinkSources {
 		go func(
 			lc linksrc.Config,
 			g *sync.WaitGroup,
 			bc chan linksrc.Set,
-			ech chan error,
 		) {
 			defer g.Done()
+			ctx, cancel := context.WithTimeout(
+				context.Background(),
+				time.Duration(1)*time.Minute,
+			)
+			defer cancel()
+
+			// Read the page from the cache instead of the network so
+			// users can iterate on their config offline.
+			if config.Scraping.Replay {
+				f, m, err := readCachedPage(config.Scraping.PageCacheDir, lc)
+				if err != nil {
+					log.Error().Err(err).Str("linkSource", lc.Name).Msg("error replaying a page")
+					bc <- linksrc.NewErrorSet(lc, fmt.Sprintf("Could not read this site's page from the page cache: %v", err))
+					return
+				}
+				defer f.Close()
+				bc <- linksrc.NewSet(ctx, f, lc, m.StatusCode, m.ContentType)
+				return
+			}
+
 			// Try the scrape request only once. If we get a non-2xx
 			// response, it's probably not something we can expect to
 			// clear up after retrying.
-			r, err := httpClient.Get(lc.URL.String())
+			req, err := newLinkSourceRequest(lc)
 			if err != nil {
-				ech <- err
+				log.Error().Err(err).Str("linkSource", lc.Name).Msg("error creating the request for a link source")
+				bc <- linksrc.NewErrorSet(lc, fmt.Sprintf("Could not create the request for this site—check your config: %v", err))
+				return
+			}
+			if lc.Accept != "" {
+				req.Header.Set("Accept", lc.Accept)
+			}
+			if lc.AcceptLanguage != "" {
+				req.Header.Set("Accept-Language", lc.AcceptLanguage)
+			}
+			if ua := s.nextUserAgent(config.Scraping.UserAgents); ua != "" {
+				req.Header.Set("User-Agent", ua)
+			}
+			if lc.Cookie != "" {
+				req.Header.Set("Cookie", lc.Cookie)
+			}
+			hc := &httpClient
+			if lc.InsecureSkipVerify {
+				hc = insecureClient
+			}
+			start := time.Now()
+			r, err := hc.Do(req)
+			if err != nil {
+				log.Error().Err(err).Str("linkSource", lc.Name).Msg("error requesting a link source")
+				bc <- linksrc.NewErrorSet(lc, fmt.Sprintf("Could not reach this site: %v", err))
 				return
 			}
 			defer r.Body.Close()
-			ctx, cancel := context.WithTimeout(
-				context.Background(),
-				time.Duration(1)*time.Minute,
-			)
-			defer cancel()
-			s := linksrc.NewSet(ctx, r.Body, lc, r.StatusCode)
+
+			dr := &downloadReader{r: r.Body, start: start}
+			var body io.Reader = dr
+			if config.Scraping.PageCacheDir != "" {
+				// A page we can't cache is still worth scraping
+				cb, err := cachePage(config.Scraping.PageCacheDir, lc, pageMeta{
+					StatusCode:  r.StatusCode,
+					ContentType: r.Header.Get("Content-Type"),
+				}, dr)
+				if err != nil {
+					log.Error().
+						Err(err).
+						Str("linkSource", lc.Name).
+						Msg("error caching a page")
+				}
+				if cb != nil {
+					body = cb
+				}
+			}
+			s := linksrc.NewSet(ctx, body, lc, r.StatusCode, r.Header.Get("Content-Type"))
+			logDownload(lc.Name, dr, config.Scraping.SlowSourceWarnBytes)
 
 			bc <- s
 
-		}(ls, &wg, emailBuildCh, ec)
+		}(ls, &wg, emailBuildCh)
 	}
 	wg.Wait()
 
-	// Return the first error sent to the channel
-	select {
-	case err := <-ec:
-		return err
-	default:
-	}
 	// TODO: Having the receiver close the channel is not how close()
 	// was intended to be used, but senders have no way of knowing
 	// when to close the channel, and we need to use close() in order
@@ -107,88 +409,453 @@ func Run(outwr io.Writer, config *userconfig.Meta) error {
 	close(emailBuildCh)
 	log.Info().
 		Msg("done with one round of scraping")
+	var sets []linksrc.Set
+	// New link items to store once we've sent the email, so that if
+	// sending fails, they're still new in the next run
+	pending := pendingItems{}
 	for set := range emailBuildCh {
-		// See if any items are missing in the db. If so, store them
-		// and add them to a new email body.
+		found := set.CountLinkItems()
+		var newItems int
+		// See if any items are missing in the db. If so, add them to a
+		// new email body and store them after sending it.
 		for _, item := range set.LinkItems() {
 			// Read returns a "key not found" error if a key is not found.
 			// https://pkg.go.dev/github.com/dgraph-io/badger#Txn.Get
-			_, err := db.Read(item.Key())
+			e, err := db.Read(item.KeyBy(set.DedupeBy()))
+			// A link item at a new URL isn't new if we've
+			// already sent its caption.
+			if err != nil && set.DedupeCaptionAcrossRuns() {
+				e, err = db.Read(item.CaptionKey())
+			}
 			// If the Item already exists in the database,
 			if err == nil {
-				set.RemoveLinkItem(item)
-			} else {
-				log.Info().Msg("storing a link item in the database")
-				err = db.Put(item.NewKVEntry())
-				if err != nil {
-					log.Error().
-						Err(err).
-						Msg("error saving a link item")
+				if !config.Scraping.IncludeSeenItems {
+					set.RemoveLinkItem(item)
 					continue
 				}
+				fs, err := linksrc.FirstSeen(e)
+				if err != nil {
+					log.Error().Err(err).Msg("error reading when we first saw a link item")
+				}
+				set.MarkSeen(item, fs)
+			} else {
+				newItems++
+				pending.add(set.Name, item.LinkURL, item.NewKVEntryBy(set.DedupeBy()))
+				if set.DedupeCaptionAcrossRuns() {
+					pending.add(set.Name, item.LinkURL, item.NewCaptionKVEntry())
+				}
 			}
 		}
 		d.Add(set)
+		sets = append(sets, set)
+		res.Sources = append(res.Sources, SourceResult{
+			Name:          set.Name,
+			Items:         found,
+			NewItems:      newItems,
+			Messages:      set.Messages(),
+			ParseDuration: set.ParseDuration(),
+		})
 		log.Info().
 			Int("itemCount", set.CountLinkItems()).
 			Str("setName", set.Name).
 			Msg("added items to the email")
+		if config.Scraping.HideEmptySections && set.CountLinkItems() == 0 {
+			log.Info().
+				Str("setName", set.Name).
+				Msg("hiding the section for a link source with no link items")
+		}
 	}
 
-	// Get rid of old keys just before we close
-	err := db.Cleanup()
+	if m := config.Scraping.MaxTotalItems; m > 0 {
+		d.LimitItems(int(m))
+	}
+
+	// Sort after limiting the number of link items so that the order
+	// reflects what's in the email
+	sortSections(d, config)
+
+	// Execute the subject and intro templates before limiting the size of
+	// the email, since the intro counts toward the size.
+	td := newsletterTemplateData(res, d, config)
+	subject, err := executeTemplate(config.Scraping.SubjectTemplate, td)
+	if err != nil {
+		return res, fmt.Errorf("can't execute the subject template: %v", err)
+	}
+	intro, err := executeTemplate(config.Scraping.IntroTemplate, td)
 	if err != nil {
-		log.Error().Err(err).Msg("error cleaning up the database")
-	}
-	// Close the connection here so BadgerDB can flush to disk.
-	// Otherwise, BadgerDB has to reach its MaxTableSize before it
-	// flushes--we want to write the results of each scraping round to
-	// disk, and there's no need to keep the DB connection open while
-	// waiting for the next scrape.
-	//
-	// https://pkg.go.dev/github.com/dgraph-io/badger#readme-i-don-t-see-any-disk-writes-why
-	db.Close()
-	log.Info().Msg("closed the database to flush data to disk")
-	bod := d.GenerateBody()
-	txt := d.GenerateText()
-	log.Info().Msg("attempting to send an email")
+		return res, fmt.Errorf("can't execute the intro template: %v", err)
+	}
+	if intro != "" {
+		d.SetIntro(intro)
+	}
+
+	parts := []*html.EmailData{d}
+	if m := config.Scraping.MaxEmailBytes; m > 0 {
+		if config.Scraping.SplitLargeEmails {
+			parts, err = d.Split(int(m))
+			if err != nil {
+				return res, err
+			}
+		} else if err := d.LimitSize(int(m)); err != nil {
+			return res, err
+		}
+	}
+
+	emails := make([]outgoingEmail, len(parts))
+	for i, p := range parts {
+		emails[i] = outgoingEmail{
+			subject: subject,
+			text:    []byte(p.GenerateText()),
+			body:    []byte(p.GenerateBody()),
+			part:    i + 1,
+			parts:   len(parts),
+		}
+	}
+	log.Info().Int("count", len(emails)).Msg("attempting to send the email")
 
 	if config.Scraping.TestMode {
+		bods := make([]string, len(emails))
+		for i, e := range emails {
+			bods[i] = string(e.body)
+		}
+		bod := strings.Join(bods, "\n")
+		out := bod
+		switch {
+		case config.Scraping.OutputFormat == userconfig.OutputFormatJSONLines:
+			var buf bytes.Buffer
+			if err := writeJSONLines(&buf, sets); err != nil {
+				return res, err
+			}
+			out = buf.String()
+		case config.Scraping.Preview:
+			p, err := writePreview(bod)
+			if err != nil {
+				return res, err
+			}
+			log.Info().Str("path", p).Msg("wrote the email preview")
+			out = "file://" + p + "\n"
+		}
+
 		if outwr == nil {
 			log.Warn().Msg(
 				"a writer is unavailable for receiving the output message",
 			)
 
 		} else {
-			if _, err := outwr.Write([]byte(bod)); err != nil {
+			if _, err := outwr.Write([]byte(out)); err != nil {
 				log.Error().Err(err).Msg("cannot write the message output")
 			}
 		}
 	} else {
-		err = config.EmailSettings.SendNewsletter([]byte(txt), []byte(bod))
+		var sent []bool
+		sent, res.SendErr = sendEmails(config.EmailSettings, emails)
+		res.Sent = res.SendErr == nil
+		for i, ok := range sent {
+			if !ok {
+				continue
+			}
+			res.SentParts++
+			// Only mark link items as seen once the reader has
+			// them. If an email failed to send, or we left a
+			// link item out to keep the email small enough,
+			// we'd rather include it in the next run than lose
+			// it.
+			storeLinkItems(db, pending.in(parts[i]))
+		}
+	}
+
+	return res, nil
+}
+
+// pendingItems holds the database entries for new link items until we know
+// that the reader has them. It maps the name of each link source to the URL
+// of each of its new link items, and each link item to its entries.
+type pendingItems map[string]map[string][]storage.KVEntry
+
+// add stores entries for the link item at linkURL from the link source named
+// source
+func (p pendingItems) add(source, linkURL string, entries ...storage.KVEntry) {
+	if p[source] == nil {
+		p[source] = make(map[string][]storage.KVEntry)
+	}
+	p[source][linkURL] = append(p[source][linkURL], entries...)
+}
+
+// in returns the entries in p for the link items in the email d
+func (p pendingItems) in(d *html.EmailData) []storage.KVEntry {
+	var es []storage.KVEntry
+	for _, s := range d.Sections() {
+		for _, li := range s.Items {
+			es = append(es, p[s.PubName][li.LinkURL]...)
+		}
+	}
+	return es
+}
+
+// storeLinkItems saves each of entries in db, logging any errors
+func storeLinkItems(db storage.KeyValue, entries []storage.KVEntry) {
+	for _, e := range entries {
+		log.Info().Msg("storing a link item in the database")
+		if err := db.Put(e); err != nil {
+			log.Error().
+				Err(err).
+				Msg("error saving a link item")
+		}
+	}
+}
+
+// outgoingEmail is an email that we've built but might not have sent yet
+type outgoingEmail struct {
+	// The subject line, or blank for the default
+	subject    string
+	text, body []byte
+	// The 1-based number of this email out of parts emails, if we split
+	// the newsletter into several emails
+	part, parts int
+}
+
+// sendEmails sends each of emails using the settings in uc. It returns whether
+// we sent each email, in the same order, and the first error we got, if any.
+func sendEmails(uc email.UserConfig, emails []outgoingEmail) ([]bool, error) {
+	var firstErr error
+	sent := make([]bool, len(emails))
+	for i, e := range emails {
+		var err error
+		if e.parts > 1 {
+			err = uc.SendNewsletterPart(e.subject, e.text, e.body, e.part, e.parts)
+		} else {
+			err = uc.SendNewsletterWithSubject(e.subject, e.text, e.body)
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("error sending an email")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
 		}
+		sent[i] = true
+	}
+	return sent, firstErr
+}
+
+// sortSections orders the sections of d according to the section order in
+// config. Sections arrive in the order that their link sources finish, so we
+// always start from the order of the config to keep emails consistent from
+// one run to the next.
+func sortSections(d *html.EmailData, config *userconfig.Meta) {
+	pos := make(map[string]int, len(config.LinkSources))
+	for i, ls := range config.LinkSources {
+		pos[ls.Name] = i
+	}
+	d.SortSections(func(a, b html.BodySectionContent) bool {
+		return pos[a.PubName] < pos[b.PubName]
+	})
+
+	switch config.Scraping.SectionOrder {
+	case userconfig.SectionOrderName:
+		d.SortSections(func(a, b html.BodySectionContent) bool {
+			return strings.ToLower(a.PubName) < strings.ToLower(b.PubName)
+		})
+	case userconfig.SectionOrderCountDesc:
+		d.SortSections(func(a, b html.BodySectionContent) bool {
+			return len(a.Items) > len(b.Items)
+		})
+	case userconfig.SectionOrderCountAsc:
+		d.SortSections(func(a, b html.BodySectionContent) bool {
+			return len(a.Items) < len(b.Items)
+		})
+	}
+}
+
+// newsletterTemplateData returns the data for executing the subject and
+// intro templates for the run summarized in res, with the email in d
+func newsletterTemplateData(res RunResult, d *html.EmailData, config *userconfig.Meta) userconfig.NewsletterTemplateData {
+	td := userconfig.NewsletterTemplateData{
+		Date:    res.Start,
+		Count:   d.CountLinkItems(),
+		Sources: make([]string, len(config.LinkSources)),
+	}
+	for _, s := range res.Sources {
+		td.NewCount += s.NewItems
+	}
+	for i, lc := range config.LinkSources {
+		td.Sources[i] = lc.Name
+	}
+	return td
+}
+
+// executeTemplate returns the result of executing t with td, or a blank
+// string if t is nil
+func executeTemplate(t *template.Template, td userconfig.NewsletterTemplateData) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, td); err != nil {
+		return "", err
 	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// newLinkSourceRequest returns the request to send to the link source
+// configured in lc. If the request has a body that's JSON, we set the
+// Content-Type header accordingly.
+func newLinkSourceRequest(lc linksrc.Config) (*http.Request, error) {
+	m := lc.Method
+	if m == "" {
+		m = http.MethodGet
+	}
+
+	var b io.Reader
+	if lc.Body != "" {
+		b = strings.NewReader(lc.Body)
+	}
+
+	req, err := http.NewRequest(m, lc.URL.String(), b)
+	if err != nil {
+		return nil, err
+	}
+
+	if lc.Body != "" && json.Valid([]byte(lc.Body)) {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// jsonLinkItem is the representation of a link item in JSON Lines output
+type jsonLinkItem struct {
+	Publication string `json:"publication"`
+	Caption     string `json:"caption"`
+	Summary     string `json:"summary,omitempty"`
+	URL         string `json:"url"`
+}
 
+// writeJSONLines writes each link item in sets to w as a JSON object on its
+// own line.
+func writeJSONLines(w io.Writer, sets []linksrc.Set) error {
+	enc := json.NewEncoder(w)
+	for _, s := range sets {
+		for _, li := range s.LinkItems() {
+			if err := enc.Encode(jsonLinkItem{
+				Publication: s.Name,
+				Caption:     li.Caption,
+				Summary:     li.Summary,
+				URL:         li.LinkURL,
+			}); err != nil {
+				return fmt.Errorf("cannot write a link item as JSON: %v", err)
+			}
+		}
+	}
 	return nil
 }
 
-// StartLoop begins the main sequence of scraping websites for links every
-// interval (defined by tc) with the provided config. If an s.ErrCh is provided,
-// sends any errors to it. Send a struct{} to sc to stop the scraper.
-func StartLoop(s *Config, c *userconfig.Meta) error {
-	// Run the first scrape immediately
-	err := Run(s.OutputWr, c)
+// writePreview writes the HTML email body bod to a new file in the default
+// temporary directory and returns the file's absolute path.
+func writePreview(bod string) (string, error) {
+	f, err := os.CreateTemp("", "one-newsletter-preview-*.html")
 	if err != nil {
-		return err
+		return "", fmt.Errorf("cannot create the email preview file: %v", err)
 	}
+	defer f.Close()
+
+	if _, err := f.WriteString(bod); err != nil {
+		return "", fmt.Errorf("cannot write the email preview file: %v", err)
+	}
+
+	return filepath.Abs(f.Name())
+}
+
+// runWithRetries calls RunWithResult with s and c. If the run fails in a way
+// that might clear up, e.g., because the database was locked or the SMTP
+// relay was down, we try again up to c.Scraping.RunRetries times, waiting
+// c.Scraping.RunRetryBackoff before the first retry and twice as long before
+// each retry after that. Since we only store link items after sending the
+// email, a retry after a failed send scrapes again and finds the same new link
+// items. If we split the newsletter into several emails and sent some of them,
+// we don't retry, since the reader would get those emails twice. Other errors,
+// e.g., from an invalid configuration, end the run right away. We stop
+// retrying if ctx is cancelled.
+func runWithRetries(ctx context.Context, s *Config, c *userconfig.Meta) (RunResult, error) {
+	res, err := RunWithResult(s, c)
+	wait := c.Scraping.RunRetryBackoff
+	for i := uint(1); i <= c.Scraping.RunRetries; i++ {
+		var re retryableError
+		var rerr error
+		switch {
+		case err == nil && res.SendErr == nil:
+			return res, nil
+		case err == nil && res.SentParts > 0:
+			log.Warn().
+				Err(res.SendErr).
+				Int("sentParts", res.SentParts).
+				Msg("not retrying the scrape cycle, since some emails were already sent. The link items in the other emails are still new in the next scrape cycle.")
+			return res, nil
+		case err == nil:
+			rerr = res.SendErr
+		case errors.As(err, &re):
+			rerr = err
+		default:
+			return res, err
+		}
 
-	// Only running the loop once
-	if c.Scraping.OneOff || c.Scraping.TestMode {
+		log.Warn().
+			Err(rerr).
+			Uint("attempt", i).
+			Uint("maxAttempts", c.Scraping.RunRetries).
+			Dur("wait", wait).
+			Msg("retrying the scrape cycle")
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+
+		res, err = RunWithResult(s, c)
+	}
+	return res, err
+}
+
+// runUnlessPaused calls runAndReport with ctx, s, and c unless the config pauses
+// scheduled scrapes at the current time.
+func runUnlessPaused(ctx context.Context, s *Config, c *userconfig.Meta) error {
+	if c.Scraping.Paused(time.Now()) {
+		log.Info().
+			Time("pauseUntil", c.Scraping.PauseUntil).
+			Msg("scraping is paused, so skipping this scrape")
+		return nil
+	}
+	return runAndReport(ctx, s, c)
+}
+
+// StartLoop begins the main sequence of scraping websites for links every
+// interval (defined by s.TickCh) with the provided config. Cancel ctx to stop
+// the scraper. If a scrape cycle is in progress, StartLoop finishes it before
+// returning.
+func StartLoop(ctx context.Context, s *Config, c *userconfig.Meta) error {
+	// Create the HTTP client once so we can reuse it for every scrape
+	if s.HTTPClient == nil {
+		s.HTTPClient = NewHTTPClient(c.Scraping)
+	}
+
+	// The caller has already stopped the scraper
+	if ctx.Err() != nil {
 		return nil
 	}
 
+	// Only running the loop once. Pauses don't apply here, since the user
+	// asked for this run explicitly. Sending to a test address is always
+	// a one-off run so the tester doesn't get an email every interval.
+	if c.Scraping.OneOff || c.Scraping.TestMode || c.EmailSettings.TestToAddress != "" {
+		return runAndReport(ctx, s, c)
+	}
+
+	// Run the first scrape immediately
+	if err := runUnlessPaused(ctx, s, c); err != nil {
+		return err
+	}
+
 	// Implement the iteration limit by replacing the tick channel with a
 	// buffered channel pre-loaded with ticks.
 	if s.IterationLimit > 0 {
@@ -200,20 +867,20 @@ func StartLoop(s *Config, c *userconfig.Meta) error {
 	}
 
 	for {
+		// If we run out of ticks while there's an iteration limit,
+		// we've run through all the iterations.
+		if s.IterationLimit > 0 && len(s.TickCh) == 0 {
+			return nil
+		}
+
 		select {
+		case <-ctx.Done():
+			log.Info().Msg("stopping the scraper")
+			return nil
 		case <-s.TickCh:
-			err := Run(s.OutputWr, c)
-			if err != nil {
+			if err := runUnlessPaused(ctx, s, c); err != nil {
 				return err
 			}
-		default:
-			// If we run out of ticks, it's either because we're waiting
-			// for more ticks or there's an iteration limit and we've
-			// run through all the iterations.
-			if s.IterationLimit > 0 {
-				return nil
-			}
-			continue
 		}
 	}
 }
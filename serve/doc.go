@@ -0,0 +1,5 @@
+package serve
+
+// serve exposes One Newsletter over HTTP. At the moment this is limited to
+// debugging endpoints that help users tune their link source configurations
+// against live websites.
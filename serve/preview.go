@@ -0,0 +1,135 @@
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ptgott/one-newsletter/linksrc"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// previewParams are the query parameters that the preview endpoint accepts.
+// Each one has the same name as a field within a link source configuration.
+var previewParams = []string{
+	"url",
+	"linkSelector",
+	"itemSelector",
+	"captionSelector",
+	"maxItems",
+	"minElementWords",
+	"captionStripPattern",
+	"accept",
+}
+
+// previewItem is the JSON representation of a linksrc.LinkItem
+type previewItem struct {
+	LinkURL string `json:"linkURL"`
+	Caption string `json:"caption"`
+}
+
+// previewResponse is the JSON body returned by the preview endpoint
+type previewResponse struct {
+	Items    []previewItem `json:"items"`
+	Messages []string      `json:"messages"`
+}
+
+// previewHandler fetches a link source on behalf of the user and returns the
+// link items that One Newsletter would extract from it, without touching the
+// database or sending an email.
+type previewHandler struct {
+	client *http.Client
+}
+
+// parsePreviewConfig builds a link source configuration from the query
+// parameters in r. We round-trip the parameters through YAML so they're
+// validated the same way as a link source within the config file.
+func parsePreviewConfig(r *http.Request) (linksrc.Config, error) {
+	q := r.URL.Query()
+	v := map[string]string{
+		"name": "preview",
+	}
+	for _, p := range previewParams {
+		if q.Has(p) {
+			v[p] = q.Get(p)
+		}
+	}
+
+	if v["url"] == "" {
+		return linksrc.Config{}, fmt.Errorf("the url parameter is required")
+	}
+
+	// Marshaling a map[string]string won't return an error
+	b, _ := yaml.Marshal(v)
+
+	var c linksrc.Config
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return linksrc.Config{}, err
+	}
+
+	return c.CheckAndSetDefaults()
+}
+
+// ServeHTTP implements http.Handler
+func (ph previewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "only GET requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, err := parsePreviewConfig(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Debug().
+		Str("url", c.URL.String()).
+		Msg("previewing a link source")
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, c.URL.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if c.Accept != "" {
+		req.Header.Set("Accept", c.Accept)
+	}
+	resp, err := ph.client.Do(req)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("could not fetch the link source: %v", err),
+			http.StatusBadGateway,
+		)
+		return
+	}
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(1)*time.Minute)
+	defer cancel()
+	s := linksrc.NewSet(ctx, resp.Body, c, resp.StatusCode, resp.Header.Get("Content-Type"))
+
+	pr := previewResponse{
+		Items:    []previewItem{},
+		Messages: s.Messages(),
+	}
+	if pr.Messages == nil {
+		pr.Messages = []string{}
+	}
+	for _, li := range s.LinkItems() {
+		pr.Items = append(pr.Items, previewItem{
+			LinkURL: li.LinkURL,
+			Caption: li.Caption,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(pr); err != nil {
+		log.Error().Err(err).Msg("could not write the preview response")
+	}
+}
@@ -0,0 +1,128 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const previewSourceHTML = `<!doctype html>
+<html>
+<body>
+	<ul>
+		<li>
+			<p>This is the first story on the page.</p>
+			<a href="/stories/1">Read more</a>
+		</li>
+		<li>
+			<p>This is the second story on the page.</p>
+			<a href="/stories/2">Read more</a>
+		</li>
+	</ul>
+</body>
+</html>`
+
+func TestPreviewHandler(t *testing.T) {
+	src := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(previewSourceHTML))
+	}))
+	defer src.Close()
+
+	srv := httptest.NewServer(NewHandler(Config{Debug: true}))
+	defer srv.Close()
+
+	cases := []struct {
+		description   string
+		params        url.Values
+		expectedCode  int
+		expectedItems int
+	}{
+		{
+			description: "URL only",
+			params: url.Values{
+				"url": {src.URL},
+			},
+			expectedCode:  http.StatusOK,
+			expectedItems: 2,
+		},
+		{
+			description: "manual selectors",
+			params: url.Values{
+				"url":             {src.URL},
+				"itemSelector":    {"ul li"},
+				"captionSelector": {"p"},
+				"linkSelector":    {"a"},
+			},
+			expectedCode:  http.StatusOK,
+			expectedItems: 2,
+		},
+		{
+			description: "max items",
+			params: url.Values{
+				"url":      {src.URL},
+				"maxItems": {"1"},
+			},
+			expectedCode:  http.StatusOK,
+			expectedItems: 1,
+		},
+		{
+			description:  "no URL",
+			params:       url.Values{},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description: "unparseable link selector",
+			params: url.Values{
+				"url":          {src.URL},
+				"linkSelector": {"123"},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			description: "item selector without a caption selector",
+			params: url.Values{
+				"url":          {src.URL},
+				"itemSelector": {"ul li"},
+				"linkSelector": {"a"},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/preview?" + c.params.Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, c.expectedCode, resp.StatusCode)
+			if c.expectedCode != http.StatusOK {
+				return
+			}
+
+			var pr previewResponse
+			if err := json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+				t.Fatalf("could not decode the preview response: %v", err)
+			}
+			assert.Len(t, pr.Items, c.expectedItems)
+		})
+	}
+}
+
+func TestPreviewHandlerRequiresDebug(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Config{Debug: false}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/preview?url=http://www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Config determines which endpoints the HTTP server exposes
+type Config struct {
+	// Address to listen on in host:port format
+	Address string
+	// Whether to register debugging endpoints, e.g., /preview
+	Debug bool
+	// Paths to a PEM-encoded certificate and private key. If both are set,
+	// we serve HTTPS instead of HTTP.
+	CertFile string
+	KeyFile  string
+	// Credentials for HTTP basic authentication. If both are set, every
+	// request must include them.
+	Username string
+	Password string
+}
+
+// NewHandler returns an http.Handler that routes requests to One Newsletter's
+// endpoints based on c.
+func NewHandler(c Config) http.Handler {
+	mux := http.NewServeMux()
+
+	if c.Debug {
+		mux.Handle("/preview", previewHandler{
+			client: &http.Client{
+				// Same as the scraper's timeout
+				Timeout: time.Duration(60) * time.Second,
+			},
+		})
+	}
+
+	if c.Username != "" && c.Password != "" {
+		return basicAuthHandler{
+			next:     mux,
+			username: c.Username,
+			password: c.Password,
+		}
+	}
+
+	return mux
+}
+
+// basicAuthHandler passes requests with the expected basic authentication
+// credentials to next and responds to other requests with a 401
+type basicAuthHandler struct {
+	next     http.Handler
+	username string
+	password string
+}
+
+// ServeHTTP implements http.Handler
+func (bh basicAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, p, ok := r.BasicAuth()
+	// Evaluate both comparisons so the response time doesn't reveal
+	// which credential is wrong
+	um := equalConstantTime(u, bh.username)
+	pm := equalConstantTime(p, bh.password)
+	if !ok || !um || !pm {
+		w.Header().Set("WWW-Authenticate", `Basic realm="One Newsletter", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	bh.next.ServeHTTP(w, r)
+}
+
+// equalConstantTime returns whether a and b are equal in an amount of time
+// that doesn't depend on their contents or lengths. We compare hashes since
+// subtle.ConstantTimeCompare returns early for inputs of different lengths.
+func equalConstantTime(a, b string) bool {
+	ah := sha256.Sum256([]byte(a))
+	bh := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ah[:], bh[:]) == 1
+}
+
+// ListenAndServe starts an HTTP server for One Newsletter's endpoints. If c
+// includes a certificate and key, the server uses HTTPS. Blocking.
+func ListenAndServe(c Config) error {
+	l, err := net.Listen("tcp", c.Address)
+	if err != nil {
+		return err
+	}
+	return serve(l, c)
+}
+
+// serve is like ListenAndServe, but accepts connections on l
+func serve(l net.Listener, c Config) error {
+	srv := &http.Server{
+		Handler: NewHandler(c),
+	}
+	if c.CertFile != "" && c.KeyFile != "" {
+		return srv.ServeTLS(l, c.CertFile, c.KeyFile)
+	}
+	return srv.Serve(l)
+}
@@ -0,0 +1,136 @@
+package serve
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ptgott/one-newsletter/smtptest"
+)
+
+func TestServeTLS(t *testing.T) {
+	key, cert, err := smtptest.GenerateTLSFiles(t)
+	if err != nil {
+		t.Fatalf("can't generate the TLS files: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen for connections: %v", err)
+	}
+	defer l.Close()
+
+	go serve(l, Config{
+		Debug:    true,
+		CertFile: cert,
+		KeyFile:  key,
+	})
+
+	pem, err := os.ReadFile(cert)
+	if err != nil {
+		t.Fatalf("can't read the certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		t.Fatal("can't add the certificate to the pool")
+	}
+	client := &http.Client{
+		Timeout: time.Duration(5) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	// The preview endpoint rejects requests without a URL, which is
+	// enough to show that the server is handling requests over TLS.
+	resp, err := client.Get("https://" + l.Addr().String() + "/preview")
+	if err != nil {
+		t.Fatalf("can't send a request to the TLS endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS == nil {
+		t.Fatal("expected a TLS connection")
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a %v status but got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+
+}
+
+func TestBasicAuth(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(Config{
+		Debug:    true,
+		Username: "reader",
+		Password: "s3cret",
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		description    string
+		username       string
+		password       string
+		setAuth        bool
+		expectedStatus int
+	}{
+		{
+			description:    "no credentials",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			description:    "wrong password",
+			username:       "reader",
+			password:       "guess",
+			setAuth:        true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			description:    "wrong username",
+			username:       "writer",
+			password:       "s3cret",
+			setAuth:        true,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			// The preview endpoint rejects requests without a URL
+			description:    "correct credentials",
+			username:       "reader",
+			password:       "s3cret",
+			setAuth:        true,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+"/preview", nil)
+			if err != nil {
+				t.Fatalf("can't create the request: %v", err)
+			}
+			if c.setAuth {
+				req.SetBasicAuth(c.username, c.password)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("can't send the request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != c.expectedStatus {
+				t.Errorf("expected a %v status but got %v", c.expectedStatus, resp.StatusCode)
+			}
+			wa := resp.Header.Get("WWW-Authenticate")
+			if c.expectedStatus == http.StatusUnauthorized && wa == "" {
+				t.Error("expected a WWW-Authenticate header")
+			}
+			if c.expectedStatus != http.StatusUnauthorized && wa != "" {
+				t.Errorf("expected no WWW-Authenticate header but got %q", wa)
+			}
+		})
+	}
+}
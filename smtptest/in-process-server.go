@@ -3,7 +3,9 @@ package smtptest
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -24,6 +26,8 @@ type messageData struct {
 // for an InMemoryEmailStore.
 type Backend struct {
 	*InMemoryEmailStore
+	// Accept mail from clients that haven't logged in
+	allowAnonymous bool
 }
 
 // Login implements smtp.Backend. Any username/password is fine, since we
@@ -35,9 +39,12 @@ func (be *Backend) Login(_ *smtp.ConnectionState, username string, password stri
 	return nil, errors.New("no username or password provided")
 }
 
-// AnonymouseLogin implements smtp.Backend. Not supported since we want to
-// enforce AUTH.
+// AnonymouseLogin implements smtp.Backend. Not supported unless AUTH is
+// disabled, since we want to enforce AUTH by default.
 func (be *Backend) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error) {
+	if be.allowAnonymous {
+		return be.InMemoryEmailStore, nil
+	}
 	return nil, smtp.ErrAuthUnsupported
 }
 
@@ -48,6 +55,11 @@ func (be *Backend) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error)
 type InMemoryEmailStore struct {
 	mu       *sync.Mutex
 	messages []messageData
+	// The number of messages to reject before accepting messages again
+	rejectNext int
+	// The number of messages to accept before rejecting the next
+	// rejectNext messages
+	acceptFirst int
 }
 
 // Reset implements smtp.Session. No-op here.
@@ -72,6 +84,14 @@ func (es *InMemoryEmailStore) Data(r io.Reader) error {
 		return err
 	}
 
+	if es.reject() {
+		return &smtp.SMTPError{
+			Code:         451,
+			EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+			Message:      "Temporarily unable to accept messages",
+		}
+	}
+
 	str := &strings.Builder{}
 	if _, err := str.Write(buf); err != nil {
 		return err
@@ -103,7 +123,7 @@ func NewInProcessServer(keypath string, certpath string) *InProcessServer {
 	}
 
 	srv := smtp.NewServer(&Backend{
-		is,
+		InMemoryEmailStore: is,
 	})
 
 	srv.Addr = ":2526" // arbitrary
@@ -135,6 +155,46 @@ func NewInProcessServer(keypath string, certpath string) *InProcessServer {
 	return ip
 }
 
+// RejectNext makes the server reject the next n messages with a temporary
+// error, e.g., to test how clients retry. Messages after those are accepted
+// as usual.
+func (es *InMemoryEmailStore) RejectNext(n int) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.acceptFirst = 0
+	es.rejectNext = n
+}
+
+// RejectAfter makes the server accept the next accepted messages, then
+// reject n messages with a temporary error, e.g., to test a client that fails
+// partway through sending several messages. Messages after those are
+// accepted as usual.
+func (es *InMemoryEmailStore) RejectAfter(accepted, n int) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	es.acceptFirst = accepted
+	es.rejectNext = n
+}
+
+// reject returns whether to reject the current message because of
+// RejectNext or RejectAfter
+func (es *InMemoryEmailStore) reject() bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.rejectNext == 0 {
+		return false
+	}
+	if es.acceptFirst > 0 {
+		es.acceptFirst--
+		return false
+	}
+	es.rejectNext--
+	return true
+}
+
 // saveEmail stores the email body in memory along with a timestamp created
 // just prior to saving
 func (es *InMemoryEmailStore) saveEmail(bod string) {
@@ -155,6 +215,31 @@ func (is *InProcessServer) Start() error {
 	return is.Server.ListenAndServe()
 }
 
+// DisableAuth configures the server to accept mail without AUTH and to stop
+// advertising the AUTH extension. Must be called before Start.
+func (is *InProcessServer) DisableAuth() {
+	is.Server.AuthDisabled = true
+	is.Server.Backend.(*Backend).allowAnonymous = true
+}
+
+// WaitUntilReady blocks until the server accepts connections, returning an
+// error if this takes longer than timeout. Since Start blocks, tests should
+// call this after starting the server in a separate goroutine.
+func (is *InProcessServer) WaitUntilReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := net.Dial("tcp", is.Address())
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the SMTP server at %v is not accepting connections: %v", is.Address(), err)
+		}
+		time.Sleep(time.Duration(10) * time.Millisecond)
+	}
+}
+
 // Close shuts down the test server daemon. You must initialize a new
 // InProcessServer instead of restarting this one.
 func (is *InProcessServer) Close() {
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -68,15 +69,18 @@ func NewBadgerDB(sd string, ttl time.Duration) (*BadgerDB, error) {
 	}, nil
 }
 
-// Put upserts an entry
+// Put upserts an entry. If the transaction conflicts with another one, Put
+// retries it a limited number of times.
 func (db *BadgerDB) Put(entry KVEntry) error {
-	err := db.connection.Update(func(txn *badger.Txn) error {
-		e := badger.NewEntry(entry.Key, entry.Value).WithTTL(db.keyTTL)
-		err := txn.SetEntry(e)
-		if err != nil {
-			return fmt.Errorf("could not set the KV pair: %v", err)
-		}
-		return nil
+	err := retryOnConflict(func() error {
+		return db.connection.Update(func(txn *badger.Txn) error {
+			e := badger.NewEntry(entry.Key, entry.Value).WithTTL(db.keyTTL)
+			err := txn.SetEntry(e)
+			if err != nil {
+				return fmt.Errorf("could not set the KV pair: %v", err)
+			}
+			return nil
+		})
 	})
 	if err != nil {
 		return fmt.Errorf("transaction failed: %v", err)
@@ -84,6 +88,34 @@ func (db *BadgerDB) Put(entry KVEntry) error {
 	return nil
 }
 
+// The number of times to try a transaction that conflicts with another one,
+// and the delay before the first retry. The delay doubles with each retry.
+// These are variables so tests can shorten the delay.
+var (
+	maxConflictAttempts = 5
+	conflictBackoff     = time.Duration(10) * time.Millisecond
+)
+
+// retryOnConflict calls f until it returns an error other than
+// badger.ErrConflict or we have made maxConflictAttempts attempts. It returns
+// the last error from f.
+func retryOnConflict(f func() error) error {
+	var err error
+	d := conflictBackoff
+	for i := 0; i < maxConflictAttempts; i++ {
+		err = f()
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+		log.Debug().
+			Int("attempt", i+1).
+			Msg("retrying a database transaction after a conflict")
+		time.Sleep(d)
+		d *= 2
+	}
+	return err
+}
+
 // Read returns an entry by key.
 func (db *BadgerDB) Read(key []byte) (KVEntry, error) {
 	// Based on:
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 	"time"
+
+	badger "github.com/dgraph-io/badger/v3"
 )
 
 // We test all BadgerDB read/write utility functions here for a simple case. While
@@ -49,3 +52,56 @@ func TestSimpleBadgerDBReadWrite(t *testing.T) {
 	}
 
 }
+
+func TestRetryOnConflict(t *testing.T) {
+	conflictBackoff = time.Millisecond
+
+	testCases := []struct {
+		description  string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			description:  "conflicts, then success",
+			errs:         []error{badger.ErrConflict, badger.ErrConflict, nil},
+			wantErr:      nil,
+			wantAttempts: 3,
+		},
+		{
+			description:  "non-conflict error",
+			errs:         []error{badger.ErrConflict, badger.ErrTxnTooBig},
+			wantErr:      badger.ErrTxnTooBig,
+			wantAttempts: 2,
+		},
+		{
+			description: "conflicts on every attempt",
+			errs: []error{
+				badger.ErrConflict,
+				badger.ErrConflict,
+				badger.ErrConflict,
+				badger.ErrConflict,
+				badger.ErrConflict,
+			},
+			wantErr:      badger.ErrConflict,
+			wantAttempts: maxConflictAttempts,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			var n int
+			err := retryOnConflict(func() error {
+				e := tc.errs[n]
+				n++
+				return e
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected error %v but got %v", tc.wantErr, err)
+			}
+			if n != tc.wantAttempts {
+				t.Errorf("expected %v attempts but got %v", tc.wantAttempts, n)
+			}
+		})
+	}
+}
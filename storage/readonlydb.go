@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// ReadOnlyDB wraps a BadgerDB so that callers can check an existing database
+// for keys without changing it. This lets users see which link items would
+// be new without recording them.
+//
+// Unlike NoOpDB, write operations return a nil error. Callers go about their
+// business as if they had written to the database, but nothing changes on
+// disk.
+type ReadOnlyDB struct {
+	db *BadgerDB
+}
+
+// NewReadOnlyDB opens the BadgerDB database in the storage directory sd in
+// read-only mode. It is up to the caller to close the database with Close().
+func NewReadOnlyDB(sd string) (*ReadOnlyDB, error) {
+	db, err := badger.Open(
+		badger.DefaultOptions(sd).
+			WithLogger(badgerLogger{log.Logger}).
+			WithReadOnly(true),
+	)
+
+	if err != nil {
+		return &ReadOnlyDB{}, fmt.Errorf("can't open the db connection: %v", err)
+	}
+
+	return &ReadOnlyDB{
+		db: &BadgerDB{
+			connection: db,
+		},
+	}, nil
+}
+
+// Put is a no-op that returns nil so callers behave as if the entry had been
+// written.
+func (r *ReadOnlyDB) Put(KVEntry) error {
+	return nil
+}
+
+// Read returns an entry by key from the underlying database.
+func (r *ReadOnlyDB) Read(key []byte) (KVEntry, error) {
+	return r.db.Read(key)
+}
+
+// Cleanup is a no-op that returns nil, since garbage collection would
+// rewrite the database.
+func (r *ReadOnlyDB) Cleanup() error {
+	return nil
+}
+
+// Close tears down the connection to the underlying database.
+func (r *ReadOnlyDB) Close() {
+	r.db.Close()
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyDBReadsWithoutWriting(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewBadgerDB(dir, time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := KVEntry{
+		Key:   []byte("Hello"),
+		Value: []byte("World"),
+	}
+
+	if err := db.Put(kv); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	ro, err := NewReadOnlyDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv2, err := ro.Read(kv.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(kv, kv2) {
+		t.Fatal("the read-only database returned a different KV entry")
+	}
+
+	nkv := KVEntry{
+		Key:   []byte("Goodbye"),
+		Value: []byte("World"),
+	}
+
+	if err := ro.Put(nkv); err != nil {
+		t.Fatalf("expected no error writing to the read-only database but got %v", err)
+	}
+
+	if err := ro.Cleanup(); err != nil {
+		t.Fatalf("expected no error cleaning up the read-only database but got %v", err)
+	}
+	ro.Close()
+
+	db, err = NewBadgerDB(dir, time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Read(nkv.Key); err == nil {
+		t.Fatal("expected the key written to the read-only database to be missing")
+	}
+}
@@ -1,10 +1,18 @@
 package userconfig
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"github.com/ptgott/one-newsletter/linksrc"
@@ -15,6 +23,36 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Formats for the output of test mode
+const (
+	// The HTML body of the email. This is the default.
+	OutputFormatHTML = "html"
+	// One JSON object per line for each link item
+	OutputFormatJSONLines = "jsonl"
+)
+
+// Ways to order the sections of an email, one for each link source
+const (
+	// The order of the link sources in the config. This is the default.
+	SectionOrderConfig = "config"
+	// Alphabetical order by link source name
+	SectionOrderName = "name"
+	// Link sources with the most link items first
+	SectionOrderCountDesc = "count-desc"
+	// Link sources with the fewest link items first
+	SectionOrderCountAsc = "count-asc"
+)
+
+// The wait before the first retry of a scrape cycle if the user configures
+// retries without a backoff
+const defaultRunRetryBackoff = 30 * time.Second
+
+// The most link sources we scrape in one cycle if the user doesn't configure a
+// limit. Every link source gets its own goroutine, connection, and buffered
+// page during a scrape, so thousands of them can exhaust memory or file
+// descriptors.
+const defaultMaxSourcesPerRun = 500
+
 // Scrapes must take place at a minimum every 5s. We'll probably use a much
 // larger interval for a daily newsletter, but 5s is a failsafe to make
 // sure we're not accidentally DOSing our link sources.
@@ -38,9 +76,220 @@ type Scraping struct {
 	// Print the HTML body of a single email to stdout and exit to help test
 	// configuration.
 	TestMode bool
+	// In test mode, write the HTML body to a temporary file and print its
+	// path instead of printing the HTML, so users can open the email in a
+	// browser.
+	Preview bool
+	// Check link items against the database without writing new ones, so
+	// users can see which items would be new without changing any state.
+	ReadOnly bool
 	// Number of days we keep a link in the database before marking it
 	// expired.
 	LinkExpiryDays uint
+	// Address in host:port format for One Newsletter's HTTP endpoints. The
+	// HTTP server is disabled if this is blank.
+	ServeAddr string
+	// Paths to a PEM-encoded certificate and private key for serving HTTPS
+	// at ServeAddr. The server uses plain HTTP if these are blank.
+	ServeCertFile string
+	ServeKeyFile  string
+	// Credentials that requests to ServeAddr must include for HTTP basic
+	// authentication. Requests don't need credentials if these are blank.
+	ServeUsername string
+	ServePassword string
+	// Register debugging endpoints on the HTTP server, e.g., for previewing
+	// the link items extracted from a link source.
+	Debug bool
+	// Maximum combined size in bytes of the HTML and text email bodies. If
+	// an email is larger than this, we leave out link items until it fits.
+	// No limit if zero.
+	MaxEmailBytes uint
+	// If an email is larger than MaxEmailBytes, split it into several
+	// emails by section instead of leaving out link items.
+	SplitLargeEmails bool
+	// Include link items that we've already sent in each email, e.g., for a
+	// digest of everything a link source lists, instead of only new ones.
+	// New link items are marked as new.
+	IncludeSeenItems bool
+	// Maximum number of link items in each email across all link sources.
+	// If there are more than this, we keep the newest link items. No limit
+	// if zero.
+	MaxTotalItems uint
+	// Default maxItems for link sources that don't configure their own.
+	// Ignored if zero.
+	DefaultMaxItems uint
+	// Default minElementWords for link sources that don't configure their
+	// own. Ignored if zero.
+	DefaultMinElementWords int
+	// HTTP status codes to treat as successful responses from link sources,
+	// in addition to 2xx codes. Used for sites that serve valid pages with
+	// nonstandard status codes.
+	SuccessCodes []int
+	// The line at the top of each email. If this is blank, we use a
+	// default.
+	EmailHeading string
+	// How to order the sections of each email, one of SectionOrderConfig,
+	// SectionOrderName, SectionOrderCountDesc, or SectionOrderCountAsc. If
+	// this is blank, we use SectionOrderConfig.
+	SectionOrder string
+	// Leave link sources without any link items out of each email, unless
+	// they have messages, e.g., errors, to show in their sections
+	HideEmptySections bool
+	// Paths to text/template files for the subject line and the intro of
+	// each email. We execute them with a NewsletterTemplateData for each
+	// run. If these are blank, we use the default subject and no intro.
+	SubjectTemplateFile string
+	IntroTemplateFile   string
+	// The parsed contents of SubjectTemplateFile and IntroTemplateFile.
+	// CheckAndSetDefaults sets these, and they're nil if the paths are
+	// blank.
+	SubjectTemplate *template.Template
+	IntroTemplate   *template.Template
+	// The format of the output in test mode, either OutputFormatHTML or
+	// OutputFormatJSONLines. If this is blank, we use OutputFormatHTML.
+	OutputFormat string
+	// Skip scheduled scrapes until this time, e.g., during a maintenance
+	// window. Scrapes are not paused if this is the zero value.
+	PauseUntil time.Time
+	// Directory where we write each page we fetch from a link source, so
+	// users can reproduce extraction problems later. Pages aren't cached if
+	// this is blank.
+	PageCacheDir string
+	// Read each link source's page from PageCacheDir instead of fetching it
+	// over the network.
+	Replay bool
+	// File where we append a JSON line summarizing each scrape cycle, e.g.,
+	// for monitoring. We don't write reports if this is blank.
+	ReportPath string
+	// Values of the User-Agent header to send to link sources. We use each
+	// in turn, one request at a time, e.g., for sites that block a
+	// User-Agent that shows up too often. If this is empty, we use the Go
+	// default.
+	UserAgents []string
+	// Maximum number of idle connections to keep open to each link source
+	// host between requests, e.g., to reuse connections to a host with many
+	// link sources. If this is zero, we use the Go default of two.
+	MaxIdleConnsPerHost uint
+	// How long to keep an idle connection to a link source host open. If
+	// this is zero, we use the Go default of 90 seconds. Set this longer
+	// than the polling interval to reuse connections across scrapes.
+	IdleConnTimeout time.Duration
+	// Path to a file of PEM-encoded certificates to trust, in addition to
+	// the system's root CAs, when requesting link sources over HTTPS, e.g.,
+	// for internal sites signed by a private CA
+	CACertFile string
+	// The system's root CAs plus the certificates in CACertFile. This is
+	// nil unless CheckAndSetDefaults loads a CACertFile.
+	RootCAs *x509.CertPool
+	// The most link sources, after expanding link sources with several URLs,
+	// that the config can include. This guards against configs that would
+	// exhaust memory or file descriptors during a scrape. If this is zero,
+	// we use defaultMaxSourcesPerRun.
+	MaxSourcesPerRun uint
+	// Log a warning for any link source whose response is larger than this
+	// many bytes, e.g., to find pages that are slow to scrape. No warning if
+	// zero.
+	SlowSourceWarnBytes uint
+	// Log a warning if a scrape cycle finds no new link items across all
+	// link sources, e.g., because a site changed and its selectors no
+	// longer match.
+	WarnIfZeroItems bool
+	// Log a warning if a scrape cycle takes longer than this. No warning if
+	// zero.
+	WarnIfSlowerThan time.Duration
+	// Show messages about link sources, e.g., errors, in a single section at
+	// the bottom of each email instead of within each link source's section.
+	AppendDiagnostics bool
+	// If we can't open the database, e.g., because the storage directory
+	// isn't writable, send the email anyway with every link item treated as
+	// new, plus a notice about the problem, instead of failing the run.
+	DegradeOnStorageError bool
+	// Messages to show for link sources that return these HTTP status
+	// codes, e.g., a translation of the default message for a 429. These
+	// replace the default messages. A blank message means we don't show a
+	// message for the status code.
+	StatusMessages map[int]string
+	// Number of times to retry a scrape cycle that fails in a way that
+	// might clear up, e.g., because the database was locked or the SMTP
+	// relay was down. No retries if zero.
+	RunRetries uint
+	// How long to wait before the first retry of a scrape cycle. We double
+	// the wait for each retry after that.
+	RunRetryBackoff time.Duration
+}
+
+// scrapingValue is the value of a single option in the scraping section of a
+// config file. Most options are scalars, but statusMessages is a map and
+// userAgents is a list.
+type scrapingValue struct {
+	text     string
+	messages map[int]string
+	list     []string
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (sv *scrapingValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&sv.text); err == nil {
+		return nil
+	}
+	if err := unmarshal(&sv.list); err == nil {
+		return nil
+	}
+	return unmarshal(&sv.messages)
+}
+
+// NewsletterTemplateData is the data we execute SubjectTemplate and
+// IntroTemplate with for each run
+type NewsletterTemplateData struct {
+	// When the run began
+	Date time.Time
+	// The number of link items in the email
+	Count int
+	// The number of new link items that the run found, including any we
+	// left out of the email to keep it within maxTotalItems
+	NewCount int
+	// The names of the link sources, in the order of the config
+	Sources []string
+}
+
+// parseTemplateFile reads and parses the text/template at path p. To catch
+// references to data that doesn't exist before the first run, we also execute
+// the template with an empty NewsletterTemplateData.
+func parseTemplateFile(p string) (*template.Template, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	t, err := template.New(filepath.Base(p)).Option("missingkey=error").Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, NewsletterTemplateData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// loadCACertFile returns a pool of the system's root CAs plus the PEM-encoded
+// certificates in the file at path p
+func loadCACertFile(p string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := x509.SystemCertPool()
+	if err != nil {
+		cp = x509.NewCertPool()
+	}
+	if !cp.AppendCertsFromPEM(b) {
+		return nil, errors.New("the file doesn't include any PEM-encoded certificates")
+	}
+	return cp, nil
+}
+
+// Paused returns whether scheduled scrapes are paused at time t
+func (s *Scraping) Paused(t time.Time) bool {
+	return t.Before(s.PauseUntil)
 }
 
 // CheckAndSetDefaults validates s and either returns a copy of s with default
@@ -63,9 +312,84 @@ func (s *Scraping) CheckAndSetDefaults() (Scraping, error) {
 			"user-provided config does not include a storage path",
 		)
 	}
+	switch s.OutputFormat {
+	case "", OutputFormatHTML, OutputFormatJSONLines:
+	default:
+		return Scraping{}, fmt.Errorf(
+			"the output format must be %q or %q",
+			OutputFormatHTML,
+			OutputFormatJSONLines,
+		)
+	}
+	switch s.SectionOrder {
+	case "", SectionOrderConfig, SectionOrderName, SectionOrderCountDesc, SectionOrderCountAsc:
+	default:
+		return Scraping{}, fmt.Errorf(
+			"the section order must be %q, %q, %q, or %q",
+			SectionOrderConfig,
+			SectionOrderName,
+			SectionOrderCountDesc,
+			SectionOrderCountAsc,
+		)
+	}
+	if s.Replay && s.PageCacheDir == "" {
+		return Scraping{}, errors.New(
+			"replaying cached pages requires a page cache directory",
+		)
+	}
+	if (s.ServeCertFile == "") != (s.ServeKeyFile == "") {
+		return Scraping{}, errors.New(
+			"serving HTTPS requires both a certificate file and a key file",
+		)
+	}
+	if (s.ServeUsername == "") != (s.ServePassword == "") {
+		return Scraping{}, errors.New(
+			"basic authentication for the HTTP server requires both a username and a password",
+		)
+	}
+	if s.ServeCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(s.ServeCertFile, s.ServeKeyFile); err != nil {
+			return Scraping{}, fmt.Errorf(
+				"can't load the certificate and key for serving HTTPS: %v",
+				err,
+			)
+		}
+	}
+	if s.SplitLargeEmails && s.MaxEmailBytes == 0 {
+		return Scraping{}, errors.New(
+			"splitting large emails requires a maximum email size",
+		)
+	}
+	if s.SubjectTemplateFile != "" {
+		t, err := parseTemplateFile(s.SubjectTemplateFile)
+		if err != nil {
+			return Scraping{}, fmt.Errorf("can't use the subject template: %v", err)
+		}
+		s.SubjectTemplate = t
+	}
+	if s.IntroTemplateFile != "" {
+		t, err := parseTemplateFile(s.IntroTemplateFile)
+		if err != nil {
+			return Scraping{}, fmt.Errorf("can't use the intro template: %v", err)
+		}
+		s.IntroTemplate = t
+	}
+	if s.CACertFile != "" {
+		cp, err := loadCACertFile(s.CACertFile)
+		if err != nil {
+			return Scraping{}, fmt.Errorf("can't use the CA certificate file: %v", err)
+		}
+		s.RootCAs = cp
+	}
 	if s.LinkExpiryDays == 0 {
 		s.LinkExpiryDays = 180
 	}
+	if s.MaxSourcesPerRun == 0 {
+		s.MaxSourcesPerRun = defaultMaxSourcesPerRun
+	}
+	if s.RunRetries > 0 && s.RunRetryBackoff == 0 {
+		s.RunRetryBackoff = defaultRunRetryBackoff
+	}
 
 	return *s, nil
 }
@@ -73,13 +397,48 @@ func (s *Scraping) CheckAndSetDefaults() (Scraping, error) {
 // UnmarshalYAML parses a user-provided YAML configuration, returning any
 // parsing errors.
 func (s *Scraping) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	v := make(map[string]string)
-	err := unmarshal(&v)
+	sv := make(map[string]scrapingValue)
+	err := unmarshal(&sv)
 
 	if err != nil {
 		return fmt.Errorf("can't parse the user config: %v", err)
 	}
 
+	v := make(map[string]string)
+	for k, x := range sv {
+		if k == "statusMessages" || k == "userAgents" {
+			continue
+		}
+		if x.messages != nil || x.list != nil {
+			return fmt.Errorf("can't parse the user config: %v must be a single value", k)
+		}
+		v[k] = x.text
+	}
+
+	if sm, ok := sv["statusMessages"]; ok {
+		if sm.messages == nil && strings.TrimSpace(sm.text) != "" {
+			return errors.New("can't parse the status messages: must map status codes to messages")
+		}
+		for c := range sm.messages {
+			if c < 100 || c > 999 {
+				return fmt.Errorf("can't parse the status messages: %v is not an HTTP status code", c)
+			}
+		}
+		s.StatusMessages = sm.messages
+	}
+
+	if ua, ok := sv["userAgents"]; ok {
+		if ua.list == nil && strings.TrimSpace(ua.text) != "" {
+			return errors.New("can't parse the user agents: must be a list")
+		}
+		for _, a := range ua.list {
+			if strings.TrimSpace(a) == "" {
+				return errors.New("can't parse the user agents: user agents can't be blank")
+			}
+		}
+		s.UserAgents = ua.list
+	}
+
 	d, ok := v["interval"]
 
 	if !ok {
@@ -115,9 +474,229 @@ func (s *Scraping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 	s.LinkExpiryDays = uint(lid)
 
+	sa, ok := v["serveAddr"]
+	if !ok {
+		sa = ""
+	}
+	s.ServeAddr = sa
+
+	if cf, ok := v["serveCertFile"]; ok {
+		s.ServeCertFile = cf
+	}
+
+	if ca, ok := v["caCertFile"]; ok {
+		s.CACertFile = ca
+	}
+
+	if kf, ok := v["serveKeyFile"]; ok {
+		s.ServeKeyFile = kf
+	}
+
+	if su, ok := v["serveUsername"]; ok {
+		s.ServeUsername = su
+	}
+
+	if sp, ok := v["servePassword"]; ok {
+		s.ServePassword = sp
+	}
+
+	if dm, ok := v["defaultMaxItems"]; ok {
+		dmi, err := strconv.Atoi(dm)
+		if err != nil || dmi < 0 {
+			return fmt.Errorf("can't parse the default maxItems as a positive integer")
+		}
+		s.DefaultMaxItems = uint(dmi)
+	}
+
+	if dw, ok := v["defaultMinElementWords"]; ok {
+		dwi, err := strconv.Atoi(dw)
+		if err != nil || dwi < 0 {
+			return fmt.Errorf("can't parse the default minElementWords as a positive integer")
+		}
+		s.DefaultMinElementWords = dwi
+	}
+
+	if of, ok := v["outputFormat"]; ok {
+		s.OutputFormat = of
+	}
+
+	if so, ok := v["sectionOrder"]; ok {
+		s.SectionOrder = so
+	}
+
+	if he, ok := v["hideEmptySections"]; ok {
+		b, err := strconv.ParseBool(he)
+		if err != nil {
+			return fmt.Errorf("can't parse hideEmptySections as true or false")
+		}
+		s.HideEmptySections = b
+	}
+
+	if eh, ok := v["emailHeading"]; ok {
+		s.EmailHeading = eh
+	}
+
+	if sc, ok := v["successCodes"]; ok {
+		c, err := parseStatusCodes(sc)
+		if err != nil {
+			return fmt.Errorf("can't parse the success codes: %v", err)
+		}
+		s.SuccessCodes = c
+	}
+
+	if pu, ok := v["pauseUntil"]; ok {
+		put, err := time.Parse(time.RFC3339, pu)
+		if err != nil {
+			return fmt.Errorf("can't parse pauseUntil as an RFC 3339 timestamp: %v", err)
+		}
+		s.PauseUntil = put
+	}
+
+	if pc, ok := v["pageCacheDir"]; ok {
+		s.PageCacheDir = pc
+	}
+
+	if ds, ok := v["degradeOnStorageError"]; ok {
+		b, err := strconv.ParseBool(ds)
+		if err != nil {
+			return fmt.Errorf("can't parse degradeOnStorageError as true or false")
+		}
+		s.DegradeOnStorageError = b
+	}
+
+	if ad, ok := v["appendDiagnostics"]; ok {
+		b, err := strconv.ParseBool(ad)
+		if err != nil {
+			return fmt.Errorf("can't parse appendDiagnostics as true or false")
+		}
+		s.AppendDiagnostics = b
+	}
+
+	if mi, ok := v["maxIdleConnsPerHost"]; ok {
+		mii, err := strconv.Atoi(mi)
+		if err != nil || mii < 1 {
+			return fmt.Errorf("can't parse maxIdleConnsPerHost as a positive integer")
+		}
+		s.MaxIdleConnsPerHost = uint(mii)
+	}
+
+	if it, ok := v["idleConnTimeout"]; ok {
+		itd, err := time.ParseDuration(it)
+		if err != nil || itd <= 0 {
+			return fmt.Errorf("can't parse idleConnTimeout as a positive duration")
+		}
+		s.IdleConnTimeout = itd
+	}
+
+	if ms, ok := v["maxSourcesPerRun"]; ok {
+		msi, err := strconv.Atoi(ms)
+		if err != nil || msi < 1 {
+			return fmt.Errorf("can't parse maxSourcesPerRun as a positive integer")
+		}
+		s.MaxSourcesPerRun = uint(msi)
+	}
+
+	if sw, ok := v["slowSourceWarnBytes"]; ok {
+		swi, err := strconv.Atoi(sw)
+		if err != nil || swi < 0 {
+			return fmt.Errorf("can't parse slowSourceWarnBytes as a positive integer")
+		}
+		s.SlowSourceWarnBytes = uint(swi)
+	}
+
+	if wz, ok := v["warnIfZeroItems"]; ok {
+		b, err := strconv.ParseBool(wz)
+		if err != nil {
+			return fmt.Errorf("can't parse warnIfZeroItems as true or false")
+		}
+		s.WarnIfZeroItems = b
+	}
+
+	if ws, ok := v["warnIfSlowerThan"]; ok {
+		wsd, err := time.ParseDuration(ws)
+		if err != nil || wsd < 0 {
+			return fmt.Errorf("can't parse warnIfSlowerThan as a positive duration")
+		}
+		s.WarnIfSlowerThan = wsd
+	}
+
+	if rp, ok := v["reportPath"]; ok {
+		s.ReportPath = rp
+	}
+
+	if st, ok := v["subjectTemplateFile"]; ok {
+		s.SubjectTemplateFile = st
+	}
+
+	if it, ok := v["introTemplateFile"]; ok {
+		s.IntroTemplateFile = it
+	}
+
+	if is, ok := v["includeSeenItems"]; ok {
+		b, err := strconv.ParseBool(is)
+		if err != nil {
+			return fmt.Errorf("can't parse includeSeenItems as true or false")
+		}
+		s.IncludeSeenItems = b
+	}
+
+	if mt, ok := v["maxTotalItems"]; ok {
+		mti, err := strconv.Atoi(mt)
+		if err != nil || mti < 0 {
+			return fmt.Errorf("can't parse the maximum number of link items as a positive integer")
+		}
+		s.MaxTotalItems = uint(mti)
+	}
+
+	if sl, ok := v["splitLargeEmails"]; ok {
+		b, err := strconv.ParseBool(sl)
+		if err != nil {
+			return fmt.Errorf("can't parse splitLargeEmails as true or false")
+		}
+		s.SplitLargeEmails = b
+	}
+
+	if rr, ok := v["runRetries"]; ok {
+		rri, err := strconv.Atoi(rr)
+		if err != nil || rri < 0 {
+			return fmt.Errorf("can't parse runRetries as a positive integer")
+		}
+		s.RunRetries = uint(rri)
+	}
+
+	if rb, ok := v["runRetryBackoff"]; ok {
+		rbd, err := time.ParseDuration(rb)
+		if err != nil || rbd < 0 {
+			return fmt.Errorf("can't parse runRetryBackoff as a positive duration")
+		}
+		s.RunRetryBackoff = rbd
+	}
+
+	if mb, ok := v["maxEmailBytes"]; ok {
+		mbi, err := strconv.Atoi(mb)
+		if err != nil || mbi < 0 {
+			return fmt.Errorf("can't parse the maximum email size as a positive integer")
+		}
+		s.MaxEmailBytes = uint(mbi)
+	}
+
 	return nil
 }
 
+// parseStatusCodes parses a comma-separated list of HTTP status codes, e.g.,
+// "203, 999".
+func parseStatusCodes(s string) ([]int, error) {
+	var c []int
+	for _, p := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil || i < 100 || i > 999 {
+			return nil, fmt.Errorf("%q is not an HTTP status code", p)
+		}
+		c = append(c, i)
+	}
+	return c, nil
+}
+
 // CheckAndSetDefaults validates m and either returns a copy of m with default
 // settings applied or returns an error due to an invalid configuration
 func (m *Meta) CheckAndSetDefaults() (Meta, error) {
@@ -135,9 +714,44 @@ func (m *Meta) CheckAndSetDefaults() (Meta, error) {
 	}
 	c.EmailSettings = e
 
-	c.LinkSources = make([]linksrc.Config, len(m.LinkSources))
-	for n, s := range m.LinkSources {
-		ns, err := s.CheckAndSetDefaults()
+	// Link sources with several URLs become one link source per URL. The
+	// user doesn't choose the names of these, so make sure they don't
+	// collide with the names of other link sources.
+	var ls []linksrc.Config
+	// Whether the link source with each name came from a list of URLs
+	expanded := make(map[string]bool)
+	for _, s := range m.LinkSources {
+		exp := len(s.URLs) > 0
+		for _, es := range s.Expand() {
+			if e, ok := expanded[es.Name]; ok && (e || exp) {
+				return Meta{}, fmt.Errorf(
+					"the link source name %q, which we generated from a list of URLs, is already in use. Rename one of the link sources",
+					es.Name,
+				)
+			}
+			expanded[es.Name] = exp
+			ls = append(ls, es)
+		}
+	}
+	if uint(len(ls)) > c.Scraping.MaxSourcesPerRun {
+		return Meta{}, fmt.Errorf(
+			"the config includes %v link sources, but the limit is %v. Each link source needs its own connection and memory during a scrape, so split the link sources across several configs or, if the host can handle it, raise maxSourcesPerRun",
+			len(ls),
+			c.Scraping.MaxSourcesPerRun,
+		)
+	}
+
+	c.LinkSources = make([]linksrc.Config, len(ls))
+	for n, s := range ls {
+		// Apply any defaults from the scraping config before the link
+		// source's own defaults.
+		is := s.InheritDefaults(
+			c.Scraping.DefaultMaxItems,
+			c.Scraping.DefaultMinElementWords,
+		)
+		is.SuccessCodes = c.Scraping.SuccessCodes
+		is.StatusMessages = c.Scraping.StatusMessages
+		ns, err := is.CheckAndSetDefaults()
 		if err != nil {
 			return Meta{}, err
 		}
@@ -148,6 +762,105 @@ func (m *Meta) CheckAndSetDefaults() (Meta, error) {
 
 }
 
+// MarshalYAML implements the yaml.Marshaler interface. We use the same keys as
+// UnmarshalYAML and leave out options with zero values, which mean that the
+// option is off or unset. Options that come from command-line flags, e.g.,
+// TestMode, aren't part of the config file, so we leave them out too.
+func (s Scraping) MarshalYAML() (interface{}, error) {
+	var o yaml.MapSlice
+	add := func(key string, v interface{}) {
+		if reflect.ValueOf(v).IsZero() {
+			return
+		}
+		switch t := v.(type) {
+		case time.Duration:
+			v = t.String()
+		case time.Time:
+			v = t.Format(time.RFC3339)
+		case []int:
+			c := make([]string, len(t))
+			for i, n := range t {
+				c[i] = strconv.Itoa(n)
+			}
+			v = strings.Join(c, ", ")
+		}
+		o = append(o, yaml.MapItem{Key: key, Value: v})
+	}
+
+	add("interval", s.Interval)
+	add("storageDir", s.StorageDirPath)
+	add("linkExpiryDays", s.LinkExpiryDays)
+	add("serveAddr", s.ServeAddr)
+	add("serveCertFile", s.ServeCertFile)
+	add("serveKeyFile", s.ServeKeyFile)
+	add("serveUsername", s.ServeUsername)
+	add("servePassword", s.ServePassword)
+	add("maxEmailBytes", s.MaxEmailBytes)
+	add("splitLargeEmails", s.SplitLargeEmails)
+	add("includeSeenItems", s.IncludeSeenItems)
+	add("maxTotalItems", s.MaxTotalItems)
+	add("defaultMaxItems", s.DefaultMaxItems)
+	add("defaultMinElementWords", s.DefaultMinElementWords)
+	add("successCodes", s.SuccessCodes)
+	add("statusMessages", s.StatusMessages)
+	add("emailHeading", s.EmailHeading)
+	add("sectionOrder", s.SectionOrder)
+	add("hideEmptySections", s.HideEmptySections)
+	add("subjectTemplateFile", s.SubjectTemplateFile)
+	add("introTemplateFile", s.IntroTemplateFile)
+	add("outputFormat", s.OutputFormat)
+	add("pauseUntil", s.PauseUntil)
+	add("pageCacheDir", s.PageCacheDir)
+	add("reportPath", s.ReportPath)
+	add("userAgents", s.UserAgents)
+	add("maxIdleConnsPerHost", s.MaxIdleConnsPerHost)
+	add("idleConnTimeout", s.IdleConnTimeout)
+	add("maxSourcesPerRun", s.MaxSourcesPerRun)
+	add("caCertFile", s.CACertFile)
+	add("slowSourceWarnBytes", s.SlowSourceWarnBytes)
+	add("warnIfZeroItems", s.WarnIfZeroItems)
+	add("warnIfSlowerThan", s.WarnIfSlowerThan)
+	add("appendDiagnostics", s.AppendDiagnostics)
+	add("degradeOnStorageError", s.DegradeOnStorageError)
+	add("runRetries", s.RunRetries)
+	add("runRetryBackoff", s.RunRetryBackoff)
+
+	return o, nil
+}
+
+// The value that Redacted uses in place of secrets
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of m with secrets, e.g., passwords and cookies,
+// replaced so it's safe to print
+func (m Meta) Redacted() Meta {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = redactedValue
+		}
+	}
+
+	redact(&m.EmailSettings.Password)
+	redact(&m.Scraping.ServePassword)
+
+	ls := make([]linksrc.Config, len(m.LinkSources))
+	copy(ls, m.LinkSources)
+	for i := range ls {
+		redact(&ls[i].Cookie)
+		// Request bodies often include API tokens or form credentials.
+		// Keep the length so readers can tell the body isn't empty.
+		if b := ls[i].Body; b != "" {
+			ls[i].Body = fmt.Sprintf("%v (%v bytes)", redactedValue, len(b))
+		}
+		if p, ok := ls[i].URL.User.Password(); ok && p != "" {
+			ls[i].URL.User = url.UserPassword(ls[i].URL.User.Username(), redactedValue)
+		}
+	}
+	m.LinkSources = ls
+
+	return m
+}
+
 // Parse generates usable configurations from possibly arbitrary user input.
 // An error indicates a problem with parsing or validation. The Reader r
 // can be either JSON or YAML.
@@ -158,13 +871,99 @@ func Parse(r io.Reader) (*Meta, error) {
 		return &Meta{}, fmt.Errorf("can't read the config file as YAML: %v", err)
 	}
 
+	return checkParsed(m)
+}
+
+// ParseDir generates usable configurations from every YAML file (i.e., with
+// a .yaml or .yml extension) in the directory at path p. Each file can
+// include any of the sections of a config file, and we combine the link
+// sources from all of the files. The email and scraping sections must each
+// appear in exactly one file, and link source names must be unique across
+// files.
+func ParseDir(p string) (*Meta, error) {
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return &Meta{}, fmt.Errorf("can't read the config directory: %v", err)
+	}
+
+	var m Meta
+	// The file that defines each section or link source, for reporting
+	// duplicates
+	var emailFile, scrapingFile string
+	sourceFiles := make(map[string]string)
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		fp := filepath.Join(p, e.Name())
+		f, err := os.Open(fp)
+		if err != nil {
+			return &Meta{}, fmt.Errorf("can't open the config file %v: %v", fp, err)
+		}
+		var fm Meta
+		err = yaml.NewDecoder(f).Decode(&fm)
+		f.Close()
+		// An empty file has nothing to add
+		if errors.Is(err, io.EOF) {
+			continue
+		}
+		if err != nil {
+			return &Meta{}, fmt.Errorf("can't read the config file %v as YAML: %v", fp, err)
+		}
+
+		if fm.EmailSettings != (email.UserConfig{}) {
+			if emailFile != "" {
+				return &Meta{}, fmt.Errorf(
+					"both %v and %v include an \"email\" section",
+					emailFile,
+					fp,
+				)
+			}
+			emailFile = fp
+			m.EmailSettings = fm.EmailSettings
+		}
+
+		if !reflect.DeepEqual(fm.Scraping, Scraping{}) {
+			if scrapingFile != "" {
+				return &Meta{}, fmt.Errorf(
+					"both %v and %v include a \"scraping\" section",
+					scrapingFile,
+					fp,
+				)
+			}
+			scrapingFile = fp
+			m.Scraping = fm.Scraping
+		}
+
+		for _, ls := range fm.LinkSources {
+			if df, ok := sourceFiles[ls.Name]; ok {
+				return &Meta{}, fmt.Errorf(
+					"both %v and %v include a link source named %q",
+					df,
+					fp,
+					ls.Name,
+				)
+			}
+			sourceFiles[ls.Name] = fp
+			m.LinkSources = append(m.LinkSources, ls)
+		}
+	}
+
+	return checkParsed(m)
+}
+
+// checkParsed makes sure that m, which we have just parsed from user input,
+// includes all required sections, and returns m with any adjustments for the
+// mode that One Newsletter is running in.
+func checkParsed(m Meta) (*Meta, error) {
 	var es email.UserConfig = email.UserConfig{}
 	if m.EmailSettings == es {
 		return &Meta{}, errors.New("must include an \"email\" section")
 	}
 
-	var sc Scraping = Scraping{}
-	if m.Scraping == sc {
+	if reflect.DeepEqual(m.Scraping, Scraping{}) {
 		return &Meta{}, errors.New("must include a \"scraping\" section")
 	}
 